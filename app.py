@@ -8,6 +8,9 @@ from plexapi.server import PlexServer
 
 from logger import Logger
 
+# Shape of the JSON returned by getPlaylists that this script understands
+SCHEMA_VERSION = 2
+
 
 class DjMdContent(TypedDict):
     id: Union[str, None]
@@ -176,17 +179,61 @@ def build_plex_playlist_map(plex: PlexServer, logger: Logger) -> Dict[str, Dict]
     return playlist_map
 
 
+ProgressCallback = ctypes.CFUNCTYPE(None, ctypes.c_int, ctypes.c_int)
+
+
+@ProgressCallback
+def _report_progress(processed: int, total: int):
+    print(f'\rReading rekordbox playlists {processed}/{total}', end='', file=sys.stderr)
+
+
 def get_playlists() -> List[PlaylistObj]:
     library = ctypes.cdll.LoadLibrary('./library.so')
     getPlaylists = library.getPlaylists
+    getPlaylists.argtypes = [ctypes.c_char_p]
     getPlaylists.restype = ctypes.c_void_p
 
-    playlists = getPlaylists()
+    freeString = library.freeString
+    freeString.argtypes = [ctypes.c_void_p]
+    freeString.restype = None
+
+    getLastStatus = library.getLastStatus
+    getLastStatus.argtypes = []
+    getLastStatus.restype = ctypes.c_int
+
+    getLastError = library.getLastError
+    getLastError.argtypes = []
+    getLastError.restype = ctypes.c_void_p
+
+    setProgressCallback = library.setProgressCallback
+    setProgressCallback.argtypes = [ProgressCallback]
+    setProgressCallback.restype = None
+    setProgressCallback(_report_progress)
+
+    playlists = getPlaylists(None)
+    setProgressCallback(ProgressCallback())
+    print(file=sys.stderr)
     playlists_bytes = ctypes.string_at(playlists)
+    freeString(playlists)
+
+    if getLastStatus() != 0:
+        error = getLastError()
+        message = ctypes.string_at(error).decode('utf-8')
+        freeString(error)
+        print(f'Failed to read rekordbox playlists: {message}')
+        sys.exit(1)
+
     playlists_str = playlists_bytes.decode('utf-8')
     playlists_parsed = json.loads(playlists_str)
 
-    return playlists_parsed
+    if playlists_parsed.get('schema_version') != SCHEMA_VERSION:
+        print(
+            f'Unsupported playlist schema {playlists_parsed.get("schema_version")} '
+            f'from library version {playlists_parsed.get("version")}, expected {SCHEMA_VERSION}'
+        )
+        sys.exit(1)
+
+    return playlists_parsed['playlists']
 
 
 pl = get_playlists()
@@ -226,13 +273,6 @@ not_found_tracks = []  # Track not found tracks for export
 for p in logger.tqdm(pl, desc='Syncing playlists', unit='playlist'):
     playlist_title = p['dj_md_playlist']['name']
 
-    # Skip smart playlists
-    smart_list_data = p['dj_md_playlist'].get('smart_list')
-    if smart_list_data and smart_list_data != '':
-        logger.skipping_smart_playlist(playlist_title)
-        smart_playlists_skipped.append(playlist_title)
-        continue
-
     logger.processing_playlist(playlist_title)
 
     if 'dj_md_contents' not in p or len(p['dj_md_contents']) == 0:
@@ -0,0 +1,181 @@
+// Package collector reads the playlists of a rekordbox library, with their
+// tracks, cues and analysis, and exports them or syncs them to Plex. It is
+// what the rekordbox-plexamp-sync command and shared library are built on:
+// Go programs can import it to do the same without going through JSON.
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrUnavailable is matched, with errors.Is, by the errors of the functions
+// here when the rekordbox database could not be opened, as opposed to
+// reading it failing.
+var ErrUnavailable = errors.New("rekordbox database unavailable")
+
+// unavailableError keeps the message of an error opening the database while
+// matching ErrUnavailable.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string        { return e.err.Error() }
+func (e *unavailableError) Unwrap() error        { return e.err }
+func (e *unavailableError) Is(target error) bool { return target == ErrUnavailable }
+
+// withLibrary opens the rekordbox database opts selects and calls fn with
+// it, after readying opts for collecting from it.
+func withLibrary(opts *CollectOptions, fn func(client libraryClient) error) error {
+	if err := opts.compileNameFilters(); err != nil {
+		return err
+	}
+
+	lib, err := openClient(opts.OptionsPath, opts.Snapshot)
+	if err != nil {
+		return &unavailableError{err: err}
+	}
+	defer lib.Close()
+	opts.detectAnalysisDir(opts.OptionsPath)
+
+	return fn(opts.retrying(lib))
+}
+
+// Collect returns the playlists of the rekordbox library at opts.OptionsPath
+// that opts selects, with their tracks resolved.
+func Collect(ctx context.Context, opts CollectOptions) ([]*Playlist, error) {
+	var playlists []*Playlist
+	err := withLibrary(&opts, func(client libraryClient) (err error) {
+		playlists, err = collectPlaylists(ctx, client, opts)
+		return err
+	})
+
+	return playlists, err
+}
+
+// CollectTree is Collect with the playlists nested in their folders.
+func CollectTree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error) {
+	var tree []*PlaylistNode
+	err := withLibrary(&opts, func(client libraryClient) (err error) {
+		tree, err = collectTree(ctx, client, opts)
+		return err
+	})
+
+	return tree, err
+}
+
+// WritePlaylists writes the envelope of getPlaylists for the playlists
+// Collect would return to w, encoding each playlist as soon as it is
+// collected instead of holding them all in memory.
+func WritePlaylists(ctx context.Context, opts CollectOptions, w io.Writer) error {
+	return withLibrary(&opts, func(client libraryClient) error {
+		return streamPlaylists(ctx, client, opts, w, false)
+	})
+}
+
+// ListPlaylists is Collect without resolving any tracks, only naming and
+// counting the playlists, which is fast even on a large library.
+func ListPlaylists(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error) {
+	var listings []*PlaylistListing
+	err := withLibrary(&opts, func(client libraryClient) (err error) {
+		listings, err = listPlaylists(ctx, client, opts)
+		return err
+	})
+
+	return listings, err
+}
+
+// OrphanTracks returns the tracks of the collection that aren't in any
+// playlist, filtered by the track filters of opts.
+func OrphanTracks(ctx context.Context, opts CollectOptions) ([]*Track, error) {
+	var tracks []*Track
+	err := withLibrary(&opts, func(client libraryClient) (err error) {
+		tracks, err = orphanTracks(ctx, client, opts)
+		return err
+	})
+
+	return tracks, err
+}
+
+// PlexSyncOptions combine what to collect with how to sync it to Plex. As
+// JSON, e.g. {"include_prefixes": ["Plexamp - "], "match_threshold": 0.8,
+// "prune": true, "path_remaps": [{"from": "/Users/me/Music", "to":
+// "/data/music"}]}, it is the options object of the Plex sync exports.
+type PlexSyncOptions struct {
+	CollectOptions
+	SyncOptions
+	PathRemaps []PathRemap `json:"path_remaps"`
+	// PlexRPS caps the requests per second sent to Plex, zero for no limit
+	PlexRPS float64 `json:"plex_rps"`
+	// PlexSection is the title or key of the only music section to match
+	// tracks in, empty for all
+	PlexSection string `json:"plex_section"`
+	// PlexSections are several sections to match tracks in, each tried in
+	// turn until one has a match. PlexSection, if set, is tried first.
+	PlexSections []string `json:"plex_sections"`
+	// PlexInsecure skips verifying the server's TLS certificate, and
+	// PlexCAFile is a PEM bundle of extra certificates to trust instead
+	PlexInsecure bool   `json:"plex_insecure"`
+	PlexCAFile   string `json:"plex_ca_file"`
+	// PlexUserAgent replaces the User-Agent sent to Plex
+	PlexUserAgent string `json:"plex_user_agent"`
+}
+
+// DefaultPlexSyncOptions returns the options used for whatever a host leaves
+// unset.
+func DefaultPlexSyncOptions() *PlexSyncOptions {
+	return &PlexSyncOptions{SyncOptions: defaultSyncOptions()}
+}
+
+// SyncToPlex matches the playlists opts selects against the Plex server at
+// serverURL and writes them to it. With dryRun nothing is written and the
+// plan of what would be is returned instead of the summary of what was; both
+// list the Plex track each rekordbox track was matched to.
+func SyncToPlex(ctx context.Context, serverURL, token string, opts *PlexSyncOptions, dryRun bool) (interface{}, error) {
+	plex := newPlexClient(serverURL, token)
+	if err := plex.configureHTTP(opts.PlexInsecure, opts.PlexCAFile, opts.PlexUserAgent); err != nil {
+		return nil, err
+	}
+	plex.pathRemaps = opts.PathRemaps
+	opts.fileRemaps = opts.PathRemaps
+	plex.limiter = newRateLimiter(opts.PlexRPS)
+	plex.sections = opts.PlexSections
+	if opts.PlexSection != "" {
+		plex.sections = append([]string{opts.PlexSection}, plex.sections...)
+	}
+
+	var result interface{}
+	err := withLibrary(&opts.CollectOptions, func(client libraryClient) (err error) {
+		result, err = syncToPlex(ctx, &dbSource{client: client}, plex, opts.CollectOptions, opts.SyncOptions, dryRun)
+		return err
+	})
+
+	return result, err
+}
+
+// WriteM3U8 writes one .m3u8 file per playlist into dir, with the track
+// paths rewritten by remaps, and returns the paths written.
+func WriteM3U8(dir string, playlists []*Playlist, remaps []PathRemap) ([]string, error) {
+	return writeM3U8Playlists(playlists, dir, remaps)
+}
+
+// WriteCSV writes one row per playlist entry to w.
+func WriteCSV(w io.Writer, playlists []*Playlist) error {
+	return writeLibraryCSV(w, playlists)
+}
+
+// WriteRekordboxXML writes tree as a rekordbox XML collection to w.
+func WriteRekordboxXML(w io.Writer, tree []*PlaylistNode) error {
+	return writeRekordboxXML(w, tree)
+}
+
+// WriteITunesXML writes tree as an iTunes Library XML file to w.
+func WriteITunesXML(w io.Writer, tree []*PlaylistNode) error {
+	return writeITunesXML(w, tree)
+}
+
+// Version returns the version of this build.
+func Version() string {
+	return version
+}
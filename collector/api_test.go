@@ -0,0 +1,98 @@
+package collector_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvcrn/rekordbox-playlist-sync/collector"
+)
+
+// testPlaylists returns playlists as Collect would, built through the
+// exported fields only.
+func testPlaylists() []*collector.Playlist {
+	return []*collector.Playlist{
+		{
+			CombinedName: "Sets - Peak",
+			Tracks: []*collector.Track{
+				{Title: "Intro", ArtistName: "Artist", BPM: 128, Rating: 4, DurationMs: 61400, FolderPath: "/Users/me/Music/intro.mp3"},
+				{Title: "Closer", BPM: 126.5, FolderPath: "/Users/me/Music/closer.flac"},
+			},
+		},
+		{CombinedName: "Empty"},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := collector.WriteCSV(&b, testPlaylists()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "playlist,track_no,artist,title,album,bpm,key,rating,path\n" +
+		"Sets - Peak,1,Artist,Intro,,128,,4,/Users/me/Music/intro.mp3\n" +
+		"Sets - Peak,2,,Closer,,126.5,,0,/Users/me/Music/closer.flac\n"
+	if got := b.String(); got != want {
+		t.Errorf("CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteM3U8(t *testing.T) {
+	dir := t.TempDir()
+	remaps := []collector.PathRemap{{From: "/Users/me/Music", To: "/data/music"}}
+
+	paths, err := collector.WriteM3U8(dir, testPlaylists(), remaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("wrote %d files, want 2", len(paths))
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{
+			file: "Sets - Peak.m3u8",
+			want: "#EXTM3U\n#PLAYLIST:Sets - Peak\n" +
+				"#EXTINF:61,Artist - Intro\n/data/music/intro.mp3\n" +
+				"#EXTINF:-1,Closer\n/data/music/closer.flac\n",
+		},
+		{
+			file: "Empty.m3u8",
+			want: "#EXTM3U\n#PLAYLIST:Empty\n",
+		},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestWritePlaylistsMissingLibrary(t *testing.T) {
+	opts := collector.CollectOptions{OptionsPath: filepath.Join(t.TempDir(), "options.json")}
+
+	var b bytes.Buffer
+	err := collector.WritePlaylists(context.Background(), opts, &b)
+	if !errors.Is(err, collector.ErrUnavailable) {
+		t.Errorf("error %v, want ErrUnavailable", err)
+	}
+	if b.Len() > 0 {
+		t.Errorf("wrote %q for a library that couldn't be opened", b.String())
+	}
+}
+
+func TestVersion(t *testing.T) {
+	if collector.Version() == "" {
+		t.Error("empty version")
+	}
+}
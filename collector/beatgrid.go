@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// BeatGridEntry starts a run of beats at a constant tempo. A grid with tempo
+// changes has one entry per change; the beats in between follow at bpm.
+type BeatGridEntry struct {
+	PositionMs float64 `json:"position_ms"`
+	BPM        float64 `json:"bpm"`
+	// Beat is the position of the beat within its bar, 1 to 4
+	Beat int `json:"beat"`
+}
+
+// anlzBeat is one beat of a PQTZ tag.
+type anlzBeat struct {
+	beat   uint16
+	tempo  uint16 // BPM multiplied by 100
+	timeMs uint32
+}
+
+// beatGrid returns the beat grid of content, read from its analysis (ANLZ)
+// file. rekordbox doesn't keep beat grids in the database, so tracks that
+// haven't been analysed, or whose analysis file can't be found, have none.
+func (r *resolver) beatGrid(content *rekordbox.DjmdContent) []*BeatGridEntry {
+	r.mu.Lock()
+	grid, ok := r.beatGrids[content.ID.String()]
+	r.mu.Unlock()
+	if ok {
+		return grid
+	}
+
+	grid = []*BeatGridEntry{}
+	if r.analysisDir != "" && content.AnalysisDataPath.String() != "" {
+		path := filepath.Join(r.analysisDir, filepath.FromSlash(content.AnalysisDataPath.String()))
+		beats, err := readANLZBeats(path)
+		if err != nil {
+			slog.Warn("beat grid not readable", "content_id", content.ID.String(), "file", path, "error", err)
+		}
+		grid = beatGridSegments(beats)
+	}
+
+	r.mu.Lock()
+	r.beatGrids[content.ID.String()] = grid
+	r.mu.Unlock()
+	return grid
+}
+
+// beatGridSegments collapses beats into one entry per tempo change, keeping
+// the first beat of each run.
+func beatGridSegments(beats []anlzBeat) []*BeatGridEntry {
+	grid := []*BeatGridEntry{}
+	for i, b := range beats {
+		if i > 0 && beats[i-1].tempo == b.tempo {
+			continue
+		}
+
+		grid = append(grid, &BeatGridEntry{
+			PositionMs: float64(b.timeMs),
+			BPM:        bpmFromStored(int64(b.tempo)),
+			Beat:       int(b.beat),
+		})
+	}
+
+	return grid
+}
+
+// readANLZBeats reads the beats of the PQTZ (beat grid) tag of the ANLZ file
+// at path.
+func readANLZBeats(path string) ([]anlzBeat, error) {
+	tag, headerLen, err := readANLZTag(path, "PQTZ")
+	if err != nil || tag == nil {
+		return nil, err
+	}
+
+	return parsePQTZ(tag, headerLen)
+}
+
+// readANLZTag returns the first tag of the given kind in the ANLZ file at
+// path, along with its header length, or nil if there is none. All numbers
+// in the format are big-endian.
+func readANLZTag(path, kind string) ([]byte, int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(b) < 12 || string(b[:4]) != "PMAI" {
+		return nil, 0, fmt.Errorf("not an ANLZ file")
+	}
+
+	// every section starts with its type, header length and total length
+	offset := int(binary.BigEndian.Uint32(b[4:8]))
+	for offset+12 <= len(b) {
+		tagKind := string(b[offset : offset+4])
+		headerLen := int(binary.BigEndian.Uint32(b[offset+4 : offset+8]))
+		tagLen := int(binary.BigEndian.Uint32(b[offset+8 : offset+12]))
+		if tagLen < 12 || offset+tagLen > len(b) {
+			return nil, 0, fmt.Errorf("truncated %s tag", tagKind)
+		}
+
+		if tagKind == kind {
+			return b[offset : offset+tagLen], headerLen, nil
+		}
+
+		offset += tagLen
+	}
+
+	return nil, 0, nil
+}
+
+func parsePQTZ(tag []byte, headerLen int) ([]anlzBeat, error) {
+	if headerLen < 24 || headerLen > len(tag) {
+		return nil, fmt.Errorf("invalid PQTZ header")
+	}
+
+	count := int(binary.BigEndian.Uint32(tag[20:24]))
+	if headerLen+count*8 > len(tag) {
+		return nil, fmt.Errorf("truncated PQTZ tag")
+	}
+
+	beats := make([]anlzBeat, 0, count)
+	for i := 0; i < count; i++ {
+		entry := tag[headerLen+i*8:]
+		beats = append(beats, anlzBeat{
+			beat:   binary.BigEndian.Uint16(entry[0:2]),
+			tempo:  binary.BigEndian.Uint16(entry[2:4]),
+			timeMs: binary.BigEndian.Uint32(entry[4:8]),
+		})
+	}
+
+	return beats, nil
+}
@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (opts CollectOptions) cacheTTL() time.Duration {
+	return time.Duration(opts.CacheTTLSeconds * float64(time.Second))
+}
+
+// outputCache keeps the JSON an export returned on disk, so a host calling
+// it again soon after gets the same answer without the database work. An
+// entry is used while it is younger than the TTL and the database files
+// haven't changed since. A nil *outputCache caches nothing.
+type outputCache struct {
+	path    string
+	ttl     time.Duration
+	dbFiles []string
+	// modTimes are those of dbFiles before the export ran, so changes made
+	// while it runs invalidate what it saves
+	modTimes []time.Time
+}
+
+// outputCacheEntry is the file an outputCache writes.
+type outputCacheEntry struct {
+	WrittenAt time.Time `json:"written_at"`
+	// DBModTimes are the modification times of the database and its WAL
+	// when the output was produced
+	DBModTimes []time.Time     `json:"db_mod_times"`
+	Output     json.RawMessage `json:"output"`
+}
+
+// newOutputCache returns the cache of the export kind called with opts, or
+// nil if caching is off or the database can't be located.
+func newOutputCache(kind string, opts CollectOptions) *outputCache {
+	if opts.cacheTTL() <= 0 {
+		return nil
+	}
+
+	// NoCache refreshes the entry the same options would otherwise read
+	opts.NoCache = false
+	key, err := json.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+
+	optionsPath, err := resolveOptionsPath(opts.OptionsPath)
+	if err != nil {
+		return nil
+	}
+	options, err := readOptions(optionsPath)
+	if err != nil || options["db-path"] == "" {
+		return nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+
+	sum := sha1.Sum([]byte(kind + "\x00" + optionsPath + "\x00" + string(key)))
+	c := &outputCache{
+		path:    filepath.Join(cacheDir, "rekordbox-plexamp-sync", kind+"-"+hex.EncodeToString(sum[:8])+".json"),
+		ttl:     opts.cacheTTL(),
+		dbFiles: []string{options["db-path"], options["db-path"] + "-wal"},
+	}
+	c.modTimes = c.dbModTimes()
+
+	return c
+}
+
+// dbModTimes returns the modification time of each database file, the zero
+// time for files that don't exist.
+func (c *outputCache) dbModTimes() []time.Time {
+	times := make([]time.Time, len(c.dbFiles))
+	for i, path := range c.dbFiles {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+
+	return times
+}
+
+// load returns the cached output if it is still valid.
+func (c *outputCache) load() ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &outputCacheEntry{}
+	if err := json.Unmarshal(b, entry); err != nil || time.Since(entry.WrittenAt) > c.ttl {
+		return nil, false
+	}
+
+	if len(entry.DBModTimes) != len(c.modTimes) {
+		return nil, false
+	}
+	for i := range c.modTimes {
+		if !entry.DBModTimes[i].Equal(c.modTimes[i]) {
+			return nil, false
+		}
+	}
+
+	return entry.Output, true
+}
+
+// writeFileAtomic replaces the file at path by one holding b, written under
+// a unique temporary name next to it and renamed into place. Concurrent
+// writers each rename a complete file of their own; the last one wins.
+func writeFileAtomic(path string, b []byte) error {
+	return writeFileAtomicFunc(path, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// writeFileAtomicFunc is writeFileAtomic with the content written by write.
+func writeFileAtomicFunc(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// save stores output. Failing to is only logged, the output is still good.
+func (c *outputCache) save(output []byte) {
+	if c == nil {
+		return
+	}
+
+	b, err := json.Marshal(&outputCacheEntry{WrittenAt: time.Now(), DBModTimes: c.modTimes, Output: output})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0o755)
+	}
+	if err == nil {
+		// write to a temporary file first so a concurrent load can't read
+		// half an entry, and to one of its own so concurrent saves don't
+		// write into the same one
+		err = writeFileAtomic(c.path, b)
+	}
+	if err != nil {
+		slog.Warn("could not cache the output", "file", c.path, "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	contents := map[string]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		b := bytes.Repeat([]byte(fmt.Sprintf("writer %d\n", i)), 10000)
+		contents[string(b)] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeFileAtomic(path, b); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contents[string(b)] {
+		t.Errorf("file holds %d bytes that no single writer wrote", len(b))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in the directory, want only the written one", len(entries))
+	}
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pitchClasses maps note letters to their pitch class, C being 0.
+var pitchClasses = map[byte]int{
+	'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11,
+}
+
+// camelotMajor and camelotMinor are the Camelot wheel numbers of the major and
+// minor keys on each pitch class, C to B. Major keys are the "B" ring and minor
+// keys the "A" ring, so C is 8B and A minor 8A.
+var (
+	camelotMajor = [12]int{8, 3, 10, 5, 12, 7, 2, 9, 4, 11, 6, 1}
+	camelotMinor = [12]int{5, 12, 7, 2, 9, 4, 11, 6, 1, 8, 3, 10}
+)
+
+// camelotKey converts a rekordbox key name such as "Abm", "F#" or "Ebmaj" to
+// Camelot notation, e.g. "1A". Enharmonic spellings map to the same key, and
+// names already in Camelot notation are normalized. Unknown or empty names
+// give "".
+func camelotKey(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+
+	if n, ring, ok := parseCamelot(name); ok {
+		return fmt.Sprintf("%d%c", n, ring)
+	}
+
+	pc, ok := pitchClasses[strings.ToUpper(name[:1])[0]]
+	if !ok {
+		return ""
+	}
+	rest := name[1:]
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		pc, rest = pc+1, rest[1:]
+	case strings.HasPrefix(rest, "♯"):
+		pc, rest = pc+1, rest[len("♯"):]
+	case strings.HasPrefix(rest, "b"):
+		pc, rest = pc-1, rest[1:]
+	case strings.HasPrefix(rest, "♭"):
+		pc, rest = pc-1, rest[len("♭"):]
+	}
+	pc = (pc + 12) % 12
+
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", "maj", "major":
+		return fmt.Sprintf("%dB", camelotMajor[pc])
+	case "m", "min", "minor":
+		return fmt.Sprintf("%dA", camelotMinor[pc])
+	}
+
+	return ""
+}
+
+// parseCamelot parses keys like "8A" or "08b", for libraries where rekordbox
+// was set to show keys in Camelot notation.
+func parseCamelot(name string) (int, byte, bool) {
+	ring := strings.ToUpper(name[len(name)-1:])[0]
+	if ring != 'A' && ring != 'B' {
+		return 0, 0, false
+	}
+
+	n, err := strconv.Atoi(name[:len(name)-1])
+	if err != nil || n < 1 || n > 12 {
+		return 0, 0, false
+	}
+
+	return n, ring, true
+}
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// contentHash fingerprints what a playlist holds: its name and the IDs of its
+// tracks in order. Unlike UpdatedAt, which rekordbox doesn't always bump, for
+// example when tracks are only reordered, it changes with every edit that
+// changes the exported track list.
+func contentHash(name string, tracks []*Track) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", name)
+	for _, track := range tracks {
+		fmt.Fprintf(h, "%s\n", track.ContentID)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// addContentHashes sets the ContentHash of playlists.
+func addContentHashes(playlists []*Playlist) {
+	for _, pl := range playlists {
+		pl.ContentHash = contentHash(pl.CombinedName, pl.Tracks)
+	}
+}
+
+// changedSource passes on only the playlists whose ContentHash differs from
+// the one recorded for them in previous, which maps playlist keys to hashes.
+// hashes collects the hashes of every playlist read, for the next run.
+type changedSource struct {
+	src      playlistSource
+	previous map[string]string
+	hashes   map[string]string
+}
+
+func newChangedSource(src playlistSource, previous map[string]string) *changedSource {
+	hashes := map[string]string{}
+	// playlists filtered out this time keep their hashes
+	for key, hash := range previous {
+		hashes[key] = hash
+	}
+
+	return &changedSource{src: src, previous: previous, hashes: hashes}
+}
+
+func (s *changedSource) playlists(ctx context.Context, opts CollectOptions) ([]*Playlist, error) {
+	playlists, err := s.src.playlists(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []*Playlist{}
+	for _, pl := range playlists {
+		s.hashes[pl.key()] = pl.ContentHash
+		if s.previous[pl.key()] != pl.ContentHash {
+			changed = append(changed, pl)
+		}
+	}
+
+	return changed, nil
+}
+
+func (s *changedSource) tree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error) {
+	return nil, fmt.Errorf("--changed-only exports single playlists, it cannot be combined with a tree")
+}
+
+func (s *changedSource) list(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error) {
+	return s.src.list(ctx, opts)
+}
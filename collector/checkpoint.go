@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// syncCheckpoint records the playlists an unfinished sync already wrote, so
+// running it again picks up where it stopped instead of starting over.
+type syncCheckpoint struct {
+	Target string `json:"target"`
+	// Done maps the rekordbox IDs of synced playlists to their Plex rating
+	// keys
+	Done map[string]string `json:"done"`
+}
+
+func defaultCheckpointPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".config", "rekordbox-plexamp-sync", "checkpoint.json"), nil
+}
+
+// loadCheckpoint reads the checkpoint file at path. A missing file, or one
+// left by a sync to a different target, yields an empty checkpoint.
+func loadCheckpoint(path, target string) (*syncCheckpoint, error) {
+	checkpoint := &syncCheckpoint{}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if checkpoint.Done == nil || checkpoint.Target != target {
+		checkpoint = &syncCheckpoint{Target: target, Done: map[string]string{}}
+	}
+
+	return checkpoint, nil
+}
+
+func saveCheckpoint(path string, checkpoint *syncCheckpoint) error {
+	b, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// like the mapping file, replace it atomically so an interrupted write
+	// can't lose what was done before
+	return writeFileAtomic(path, b)
+}
+
+// removeCheckpoint deletes the checkpoint file once a sync has finished.
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
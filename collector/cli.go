@@ -0,0 +1,609 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Output formats selected with --format.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// cliConfig is everything the command line flags select.
+type cliConfig struct {
+	source       string
+	inputPath    string
+	optionsPaths []string
+	outPath      string
+	pretty       bool
+	format       string
+	m3uDir       string
+	splitDir     string
+	tree         bool
+	xmlPath      string
+	itunesPath   string
+	stateFile    string
+	changedOnly  bool
+	collect      CollectOptions
+
+	plexURL      string
+	plexToken    string
+	plexRPS      float64
+	plexSections []string
+	pathRemaps   pathRemaps
+	sync         SyncOptions
+	dryRun       bool
+
+	// plexInsecure, plexCAFile and plexUserAgent configure the HTTP client,
+	// see plexClient.configureHTTP
+	plexInsecure  bool
+	plexCAFile    string
+	plexUserAgent string
+
+	// command is the subcommand given before the flags, empty for the
+	// default of exporting or syncing
+	command string
+	oldPath string
+
+	// socketPath is where the serve command listens
+	socketPath string
+}
+
+// Subcommands given before the flags. commandDiff compares the library with
+// a previous export, see diffExports; commandList only lists the playlists,
+// see listPlaylists; commandPlanUpdate prints what a Plex sync would add and
+// remove, see planPlexUpdate; commandOptions describes the options.json
+// files read, see describeOptions; commandOrphans prints the tracks in no
+// playlist, see orphanTracks; commandServe answers requests on a Unix
+// socket, see servePlaylists.
+const (
+	commandDiff       = "diff"
+	commandList       = "list"
+	commandPlanUpdate = "plan-update"
+	commandOptions    = "options"
+	commandOrphans    = "orphans"
+	commandServe      = "serve"
+)
+
+// exitInterrupted is the exit code of a run stopped by SIGINT or SIGTERM, the
+// shell's 128 + SIGINT.
+const exitInterrupted = 130
+
+// errInterrupted is returned by run when a signal stopped it.
+var errInterrupted = errors.New("interrupted")
+
+// RunCLI is the standalone entrypoint, used when the tool is run as a binary
+// rather than loaded as a shared library. It returns the process exit code.
+func RunCLI(args []string) int {
+	cfg, err := parseFlags(args)
+	if err != nil {
+		return 2
+	}
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, errInterrupted) {
+			return exitInterrupted
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func parseFlags(args []string) (*cliConfig, error) {
+	cfg := &cliConfig{}
+	if len(args) > 0 && (args[0] == commandDiff || args[0] == commandList || args[0] == commandPlanUpdate || args[0] == commandOptions || args[0] == commandOrphans || args[0] == commandServe) {
+		cfg.command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("rekordbox-plexamp-sync", flag.ContinueOnError)
+	configPath := fs.String("config", "", "JSON config file (default ~/.config/rekordbox-plexamp-sync/config.json)")
+	fs.StringVar(&cfg.source, "source", sourceDB, "where to read the playlists from: db (the rekordbox database) or xml (an exported collection, see --input)")
+	fs.StringVar(&cfg.inputPath, "input", "", "with --source xml, the rekordbox XML collection to read")
+	fs.BoolVar(&cfg.collect.Snapshot, "snapshot", false, "read a temporary copy of the database, so rekordbox can keep running (the database itself can't be opened read-only or with a busy timeout; see --db-retries)")
+	fs.Var((*stringList)(&cfg.optionsPaths), "options", "path to rekordbox's options.json (default $REKORDBOX_OPTIONS_PATH, or detected); repeat it, or give a directory of them, to read several libraries")
+	fs.StringVar(&cfg.outPath, "out", "", "file to write the JSON output to (stdout if empty)")
+	fs.BoolVar(&cfg.pretty, "pretty", false, "indent the JSON output")
+	fs.StringVar(&cfg.format, "format", formatJSON, "output format: json, or csv for one row per playlist entry")
+	fs.StringVar(&cfg.m3uDir, "m3u-dir", "", "write one .m3u8 file per playlist into this directory instead of the JSON")
+	fs.StringVar(&cfg.splitDir, "split-out", "", "write one JSON file per playlist into this directory, listed in its index.json, instead of a single JSON")
+	fs.StringVar(&cfg.xmlPath, "xml", "", "write the playlists as a rekordbox XML collection to this file instead of the JSON")
+	fs.StringVar(&cfg.itunesPath, "itunes-xml", "", "write the playlists as an iTunes Library XML file, which Apple Music imports, to this file instead of the JSON")
+	fs.BoolVar(&cfg.tree, "tree", false, "nest the playlists in their folders in the JSON output")
+	fs.Var((*stringList)(&cfg.collect.IncludePrefixes), "include-prefix", "only export playlists whose combined name starts with this prefix (repeatable)")
+	fs.Var((*stringList)(&cfg.collect.IncludeRegex), "include-regex", "only export playlists whose combined name matches this regular expression (repeatable)")
+	fs.Var((*stringList)(&cfg.collect.ExcludeRegex), "exclude-regex", "leave out playlists whose combined name matches this regular expression, even if included (repeatable)")
+	fields := fs.String("fields", "", "comma-separated track sub-objects to export, e.g. cues,beat_grid,my_tags (default all of cues, beat_grid, energy, my_tags, history and related_tracks)")
+	fs.IntVar(&cfg.collect.MinTracks, "min-tracks", 0, "leave out playlists with fewer tracks than this, or when syncing fewer matched tracks")
+	fs.Var((*stringList)(&cfg.collect.TrackTags), "track-tag", "only export tracks with this My Tag, dropping playlists left empty (repeatable)")
+	fs.Float64Var(&cfg.collect.BPMMin, "bpm-min", 0, "only export tracks of at least this BPM, dropping playlists left empty (0 for no minimum)")
+	fs.Float64Var(&cfg.collect.BPMMax, "bpm-max", 0, "only export tracks of at most this BPM, dropping playlists left empty (0 for no maximum)")
+	fs.BoolVar(&cfg.collect.SkipUnanalyzedBPM, "skip-unanalyzed-bpm", false, "leave out tracks without an analyzed BPM")
+	fs.BoolVar(&cfg.collect.AnalyzedOnly, "analyzed-only", false, "leave out tracks rekordbox hasn't analyzed, dropping playlists left empty")
+	fs.Int64Var(&cfg.collect.MinRating, "min-rating", 0, "leave out tracks rated fewer than this many stars (1-5) or unrated, dropping playlists left empty")
+	fs.BoolVar(&cfg.collect.IncludeUnrated, "include-unrated", false, "with --min-rating, keep unrated tracks")
+	fs.IntVar(&cfg.collect.Concurrency, "concurrency", 0, "number of playlists to resolve in parallel (0 uses the number of CPUs)")
+	fs.StringVar(&cfg.collect.NameSeparator, "name-separator", " - ", "separator between folder levels in combined playlist names")
+	fs.BoolVar(&cfg.collect.NamePath, "name-path", false, "also export each playlist's folder path as an array")
+	fs.StringVar(&cfg.collect.NameMode, "name-mode", "", "how playlists are named: full (folder path joined), leaf (playlist name only) or path-array (full plus the path as an array)")
+	fs.StringVar(&cfg.collect.Sort, "sort", sortName, "order of the exported playlists: name (by combined name) or seq (as rekordbox lists them)")
+	fs.BoolVar(&cfg.collect.StatFiles, "stat-files", false, "export when each track's file was last modified, looked up at its remapped path (slow on large libraries)")
+	fs.BoolVar(&cfg.collect.CheckFiles, "check-files", false, "mark whether each track's file exists, looked up at its remapped path with symlinks resolved, and list the missing ones")
+	fs.BoolVar(&cfg.collect.KeepDuplicates, "keep-duplicates", false, "keep tracks that appear more than once in a playlist instead of only the first entry")
+	timeout := fs.Duration("timeout", 0, "abort the collection after this long, e.g. 30s (0 waits forever)")
+	queryTimeout := fs.Duration("query-timeout", 0, "give up on a single database query after this long, skipping what it was for (0 waits forever)")
+	fs.IntVar(&cfg.collect.DBRetries, "db-retries", defaultDBRetries, "how often to retry a query while rekordbox has the database locked, in place of a SQLite busy timeout (0 disables)")
+	dbRetryDelay := fs.Duration("db-retry-delay", defaultDBRetryDelay, "wait before the first retry of a locked query, doubling after each")
+	since := fs.String("since", "", "only export playlists changed after this RFC 3339 time")
+	addedSince := fs.String("added-since", "", "only export tracks added after this date or RFC 3339 time, or within this many days, e.g. 30d")
+	fs.BoolVar(&cfg.changedOnly, "changed-only", false, "with --state-file, only export playlists whose tracks or name changed since the last run, compared by content hash rather than change times")
+	fs.StringVar(&cfg.stateFile, "state-file", "", "remember the last run in this file and only export playlists changed since then")
+	logLevel := fs.String("log-level", "info", "minimum level of log messages on stderr: debug, info, warn or error")
+	logFormat := fs.String("log-format", logFormatText, "format of log messages on stderr: text, or json for one JSON object per line")
+
+	fs.StringVar(&cfg.plexURL, "plex-url", "", "sync the playlists to the Plex server at this URL instead of exporting them")
+	fs.StringVar(&cfg.plexToken, "plex-token", "", "Plex authentication token")
+	fs.Float64Var(&cfg.plexRPS, "plex-rps", 0, "maximum requests per second sent to Plex (0 for no limit)")
+	fs.BoolVar(&cfg.plexInsecure, "plex-insecure", false, "don't verify the Plex server's TLS certificate, e.g. a self-signed one behind a reverse proxy")
+	fs.StringVar(&cfg.plexCAFile, "plex-ca-file", "", "PEM bundle of certificates to trust for the Plex server, in addition to the system's")
+	fs.StringVar(&cfg.plexUserAgent, "plex-user-agent", "", "User-Agent sent to Plex (default rekordbox-plexamp-sync/VERSION)")
+	fs.Var((*stringList)(&cfg.plexSections), "plex-section", "only match tracks in the Plex music section with this title or key (repeatable; sections are tried in the order given)")
+	pathFrom := fs.String("path-from", "", "rekordbox path prefix to rewrite before matching against Plex")
+	pathTo := fs.String("path-to", "", "prefix that replaces --path-from")
+	var remapFlags stringList
+	fs.Var(&remapFlags, "path-remap", "rewrite rekordbox paths starting with FROM to start with TO, given as FROM=TO (repeatable; the longest matching FROM wins)")
+	cfg.sync = defaultSyncOptions()
+	fs.Float64Var(&cfg.sync.MatchThreshold, "match-threshold", cfg.sync.MatchThreshold, "minimum artist/title similarity (0..1) for metadata matches")
+	fs.BoolVar(&cfg.sync.Prune, "prune", false, "delete Plex playlists created by this tool whose rekordbox playlist no longer exists")
+	fs.StringVar(&cfg.sync.Target, "target", targetPlaylist, "what to sync each playlist to in Plex: playlist or collection")
+	mappingFile, _ := defaultMappingPath()
+	fs.StringVar(&cfg.sync.MappingFile, "mapping-file", mappingFile, "file remembering which Plex playlist each rekordbox playlist was synced to (empty disables it)")
+	checkpointFile, _ := defaultCheckpointPath()
+	fs.StringVar(&cfg.sync.CheckpointFile, "checkpoint-file", checkpointFile, "file recording the playlists of an unfinished sync, so running it again resumes it (empty disables it)")
+	matchCacheFile, _ := defaultMatchCachePath()
+	fs.StringVar(&cfg.sync.MatchCacheFile, "match-cache", matchCacheFile, "file keeping the Plex match of every track between runs, so only new or changed tracks are matched again (empty disables it)")
+	fs.BoolVar(&cfg.sync.Force, "force", false, "ignore the checkpoint of an interrupted sync and sync every playlist again")
+	fs.BoolVar(&cfg.sync.SyncRatings, "sync-ratings", false, "copy the star ratings of matched tracks to Plex, overwriting ratings given there")
+	fs.BoolVar(&cfg.sync.PushMetadata, "push-metadata", false, "write the rekordbox title, artist, album and genre of matched tracks to the Plex fields that are empty")
+	fs.BoolVar(&cfg.sync.ForceMetadata, "force-metadata", false, "with --push-metadata, overwrite the Plex fields that aren't empty too")
+	fs.BoolVar(&cfg.sync.TrackReport, "track-report", false, "list every track in the sync summary with its Plex rating key, match method and score")
+	fs.BoolVar(&cfg.sync.Verify, "verify", false, "read every synced playlist back from Plex and report missing or extra tracks")
+	fs.StringVar(&cfg.sync.MergeInto, "merge-into", "", "also sync every matched track of the selected playlists, deduplicated, to one Plex playlist of this name")
+	fs.StringVar(&cfg.sync.MergeOrder, "merge-order", mergeOrderAppearance, "order of the tracks in the --merge-into playlist: appearance, artist or title")
+	fs.StringVar(&cfg.sync.UnmatchedOut, "unmatched-out", "", "write the tracks that found no Plex match to this CSV file")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "with --plex-url, print the sync plan without modifying Plex")
+	fs.StringVar(&cfg.oldPath, "old", "", "with the diff command, the previous JSON export to compare the library with")
+	fs.StringVar(&cfg.socketPath, "socket", "", "with the serve command, the Unix socket to listen on")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := configureLogging(os.Stderr, *logLevel, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging flags: %v\n", err)
+		return nil, err
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// flags given on the command line win over the config file
+	if !set["options"] && config.OptionsPath != "" {
+		cfg.optionsPaths = []string{config.OptionsPath}
+	}
+	if !set["plex-url"] {
+		cfg.plexURL = config.PlexURL
+	}
+	if !set["plex-token"] {
+		cfg.plexToken = config.PlexToken
+	}
+	cfg.pathRemaps = config.PathRemaps
+	cfg.sync.ColorMoods = config.ColorMoods
+	cfg.sync.Extensions, cfg.sync.ExcludeExtensions = config.Extensions, config.ExcludeExtensions
+	cfg.sync.SummaryTemplate = config.SummaryTemplate
+	if *pathFrom != "" || len(remapFlags) > 0 {
+		cfg.pathRemaps = nil
+	}
+	if *pathFrom != "" {
+		cfg.pathRemaps = append(cfg.pathRemaps, PathRemap{From: *pathFrom, To: *pathTo})
+	}
+	for _, flagValue := range remapFlags {
+		from, to, ok := strings.Cut(flagValue, "=")
+		if !ok {
+			err := fmt.Errorf("invalid --path-remap %q, want FROM=TO", flagValue)
+			fmt.Fprintln(os.Stderr, err)
+			return nil, err
+		}
+		cfg.pathRemaps = append(cfg.pathRemaps, PathRemap{From: from, To: to})
+	}
+	if cfg.changedOnly && cfg.stateFile == "" {
+		err := fmt.Errorf("--changed-only needs --state-file")
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	if cfg.changedOnly && cfg.sync.Prune {
+		// unchanged playlists are left out, so they would all look deleted
+		err := fmt.Errorf("--changed-only cannot be combined with --prune")
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	if cfg.command == commandDiff && cfg.oldPath == "" {
+		err := fmt.Errorf("diff needs --old")
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	if cfg.command == commandPlanUpdate && cfg.plexURL == "" {
+		err := fmt.Errorf("plan-update needs --plex-url")
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	if cfg.format != formatJSON && cfg.format != formatCSV {
+		err := fmt.Errorf("unknown --format %q, want json or csv", cfg.format)
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	cfg.collect.fileRemaps = cfg.pathRemaps
+	cfg.collect.TimeoutSeconds = timeout.Seconds()
+	cfg.collect.QueryTimeoutSeconds = queryTimeout.Seconds()
+	if cfg.collect.DBRetries == 0 {
+		cfg.collect.DBRetries = -1
+	}
+	cfg.collect.DBRetryDelaySeconds = dbRetryDelay.Seconds()
+	if *fields != "" {
+		cfg.collect.Fields = strings.Split(*fields, ",")
+	}
+	if err := cfg.collect.compileNameFilters(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+
+	if *since != "" {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --since: %v\n", err)
+			return nil, err
+		}
+		cfg.collect.Since = t
+	}
+
+	if *addedSince != "" {
+		t, err := parseAddedSince(*addedSince, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --added-since: %v\n", err)
+			return nil, err
+		}
+		cfg.collect.AddedSince = t
+	}
+
+	return cfg, nil
+}
+
+// parseAddedSince parses --added-since: a number of days before now such as
+// "30d", a local date, or an RFC 3339 time.
+func parseAddedSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("%q is not a number of days", value)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func run(cfg *cliConfig) error {
+	cfg.collect.stats = newRunStats()
+
+	if cfg.command == commandOptions {
+		return runOptions(cfg)
+	}
+	if cfg.command == commandServe {
+		return runServe(cfg)
+	}
+
+	var src playlistSource
+	switch cfg.source {
+	case sourceDB:
+		paths, err := expandOptionsPaths(cfg.optionsPaths)
+		if err != nil {
+			return err
+		}
+		if len(paths) > 1 {
+			libraries := openLibraries(paths, cfg.collect)
+			defer libraries.Close()
+			src = libraries
+			break
+		}
+		optionsPath := ""
+		if len(paths) == 1 {
+			optionsPath = paths[0]
+		}
+
+		client, err := openClient(optionsPath, cfg.collect.Snapshot)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		cfg.collect.detectAnalysisDir(optionsPath)
+		src = &dbSource{client: cfg.collect.retrying(client)}
+	case sourceXML:
+		if cfg.inputPath == "" {
+			return fmt.Errorf("--source xml needs --input")
+		}
+		src = &xmlSource{path: cfg.inputPath}
+	default:
+		return fmt.Errorf("unknown source %q", cfg.source)
+	}
+	cfg.collect.Progress = func(processed, total int) {
+		slog.Debug("collecting playlists", "processed", processed, "total", total)
+	}
+
+	// a signal cancels ctx: collecting stops, while a sync finishes the
+	// playlist it is writing so the checkpoint can resume after it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// record the start so edits made while we run are picked up next time
+	started := time.Now()
+	state := &syncState{}
+	if cfg.stateFile != "" {
+		var err error
+		if state, err = loadState(cfg.stateFile); err != nil {
+			return fmt.Errorf("reading state file: %w", err)
+		}
+		// change times would hide the edits the hashes are there to catch
+		if state.LastRun.After(cfg.collect.Since) && !cfg.changedOnly {
+			cfg.collect.Since = state.LastRun
+		}
+	}
+	var changed *changedSource
+	if cfg.changedOnly {
+		changed = newChangedSource(src, state.Hashes)
+		src = changed
+	}
+
+	if err := runCommand(ctx, src, cfg); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", errInterrupted, err)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Done: %s\n", cfg.collect.stats.finish())
+
+	// a dry run or another command writes no export, so the next export
+	// still has to pick up what changed
+	if cfg.stateFile != "" && cfg.command == "" && !cfg.dryRun {
+		state.LastRun = started
+		if changed != nil {
+			state.Hashes = changed.hashes
+		}
+		return saveState(cfg.stateFile, state)
+	}
+
+	return nil
+}
+
+// runOptions prints the description of every options.json the run would
+// read, without opening a database.
+func runOptions(cfg *cliConfig) error {
+	paths, err := expandOptionsPaths(cfg.optionsPaths)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	reports := []*optionsReport{}
+	for _, path := range paths {
+		reports = append(reports, describeOptions(path))
+	}
+
+	return writeJSON(cfg.outPath, cfg.pretty, reports)
+}
+
+// runServe serves the playlists of the library at --options on --socket
+// until a signal stops it. Each request opens the database anew, like the
+// exports of the shared library.
+func runServe(cfg *cliConfig) error {
+	if cfg.socketPath == "" {
+		return fmt.Errorf("serve needs --socket")
+	}
+	if cfg.source != sourceDB {
+		return fmt.Errorf("serve only reads the rekordbox database")
+	}
+	paths, err := expandOptionsPaths(cfg.optionsPaths)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 1 {
+		return fmt.Errorf("serve reads a single library, not %d", len(paths))
+	}
+	if len(paths) == 1 {
+		cfg.collect.OptionsPath = paths[0]
+	}
+
+	l, err := listenSocket(cfg.socketPath)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("serving playlists", "socket", cfg.socketPath)
+	return servePlaylists(ctx, l, cfg.collect, withLibrary)
+}
+
+func runCommand(ctx context.Context, src playlistSource, cfg *cliConfig) error {
+	if cfg.command == commandList {
+		listings, err := src.list(ctx, cfg.collect)
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cfg.outPath, cfg.pretty, listings)
+	}
+
+	if cfg.command == commandOrphans {
+		tracks, err := src.orphans(ctx, cfg.collect)
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cfg.outPath, cfg.pretty, tracks)
+	}
+
+	if cfg.command == commandDiff {
+		old, err := loadExport(cfg.oldPath)
+		if err != nil {
+			return err
+		}
+
+		playlists, err := src.playlists(ctx, cfg.collect)
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cfg.outPath, cfg.pretty, diffExports(old, playlists))
+	}
+
+	if cfg.plexURL != "" {
+		plex := newPlexClient(cfg.plexURL, cfg.plexToken)
+		if err := plex.configureHTTP(cfg.plexInsecure, cfg.plexCAFile, cfg.plexUserAgent); err != nil {
+			return err
+		}
+		plex.pathRemaps = cfg.pathRemaps
+		plex.limiter = newRateLimiter(cfg.plexRPS)
+		plex.sections = cfg.plexSections
+
+		if cfg.command == commandPlanUpdate {
+			update, err := planPlexUpdate(ctx, src, plex, cfg.collect, cfg.sync)
+			if err != nil {
+				return err
+			}
+
+			return writeJSON(cfg.outPath, cfg.pretty, update)
+		}
+
+		result, err := syncToPlex(ctx, src, plex, cfg.collect, cfg.sync, cfg.dryRun)
+		if result == nil {
+			return err
+		}
+
+		// an interrupted sync still reports what it wrote
+		return errors.Join(writeJSON(cfg.outPath, cfg.pretty, result), err)
+	}
+
+	if cfg.tree || cfg.xmlPath != "" || cfg.itunesPath != "" {
+		tree, err := src.tree(ctx, cfg.collect)
+		if err != nil {
+			return err
+		}
+
+		if cfg.xmlPath != "" {
+			return writeOutput(cfg.xmlPath, func(w io.Writer) error {
+				return writeRekordboxXML(w, tree)
+			})
+		}
+		if cfg.itunesPath != "" {
+			return writeOutput(cfg.itunesPath, func(w io.Writer) error {
+				return writeITunesXML(w, tree)
+			})
+		}
+
+		return writeJSON(cfg.outPath, cfg.pretty, newPlaylistTreeEnvelope(tree, cfg.collect.stats))
+	}
+
+	// a single database is encoded as it is collected; the other sources
+	// only hand over every playlist at once
+	if db, ok := src.(*dbSource); ok && cfg.m3uDir == "" && cfg.splitDir == "" && cfg.format != formatCSV {
+		return writeOutput(cfg.outPath, func(w io.Writer) error {
+			return streamPlaylists(ctx, db.client, cfg.collect, w, cfg.pretty)
+		})
+	}
+
+	playlists, err := src.playlists(ctx, cfg.collect)
+	if err != nil {
+		return err
+	}
+
+	if cfg.m3uDir != "" {
+		_, err := writeM3U8Playlists(playlists, cfg.m3uDir, cfg.pathRemaps)
+		return err
+	}
+
+	if cfg.splitDir != "" {
+		return writeSplitPlaylists(cfg.splitDir, playlists, cfg.collect.stats, cfg.pretty)
+	}
+
+	if cfg.format == formatCSV {
+		return writeOutput(cfg.outPath, func(w io.Writer) error {
+			return writeLibraryCSV(w, playlists)
+		})
+	}
+
+	return writeOutput(cfg.outPath, func(w io.Writer) error {
+		return encodePlaylists(w, newPlaylistsEnvelope(playlists, cfg.collect.stats), cfg.pretty)
+	})
+}
+
+// writeJSON writes v to the file at outPath, or stdout if empty.
+func writeJSON(outPath string, pretty bool, v interface{}) error {
+	return writeOutput(outPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		if pretty {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(v)
+	})
+}
+
+// writeOutput calls write with the file at outPath, or stdout if empty. The
+// file is written under a temporary name and only renamed into place once
+// complete, so a failed or interrupted write never leaves a truncated file.
+func writeOutput(outPath string, write func(w io.Writer) error) error {
+	if outPath == "" {
+		return write(os.Stdout)
+	}
+
+	return writeFileAtomicFunc(outPath, write)
+}
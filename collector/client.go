@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+// libraryClient is the part of *rekordbox.Client that collection uses. The
+// collection code depends on it rather than the concrete client, so it can be
+// run against a fake library instead of an encrypted database file.
+type libraryClient interface {
+	AllDjmdPlaylist(ctx context.Context) ([]*rekordbox.DjmdPlaylist, error)
+	DjmdPlaylistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdPlaylist, error)
+	DjmdSongPlaylistByPlaylistID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdSongPlaylist, error)
+	AllDjmdSongPlaylist(ctx context.Context) ([]*rekordbox.DjmdSongPlaylist, error)
+	AllDjmdContent(ctx context.Context) ([]*rekordbox.DjmdContent, error)
+	DjmdContentByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdContent, error)
+
+	DjmdArtistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdArtist, error)
+	DjmdAlbumByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdAlbum, error)
+	DjmdGenreByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdGenre, error)
+	DjmdKeyByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdKey, error)
+	DjmdColorByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdColor, error)
+	DjmdLabelByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdLabel, error)
+	DjmdCueByContentID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdCue, error)
+
+	AllDjmdMyTag(ctx context.Context) ([]*rekordbox.DjmdMyTag, error)
+	AllDjmdSongMyTag(ctx context.Context) ([]*rekordbox.DjmdSongMyTag, error)
+	AllDjmdHistory(ctx context.Context) ([]*rekordbox.DjmdHistory, error)
+	AllDjmdSongHistory(ctx context.Context) ([]*rekordbox.DjmdSongHistory, error)
+	AllDjmdSongRelatedTracks(ctx context.Context) ([]*rekordbox.DjmdSongRelatedTracks, error)
+	AllDjmdProperty(ctx context.Context) ([]*rekordbox.DjmdProperty, error)
+}
+
+var _ libraryClient = (*rekordbox.Client)(nil)
+
+// openClient opens the rekordbox database described by the options.json at
+// optionsFilePath. When empty, $REKORDBOX_OPTIONS_PATH is used, and failing
+// that the location is detected for the current platform. With snapshot, a
+// copy of the database is opened, see snapshotLibrary.
+func openClient(optionsFilePath string, snapshot bool) (lib *library, err error) {
+	optionsFilePath, err = resolveOptionsPath(optionsFilePath)
+	if err != nil {
+		return nil, err
+	}
+	dbPath, err := validateOptions(optionsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotDir := ""
+	if snapshot {
+		if snapshotDir, optionsFilePath, err = snapshotLibrary(optionsFilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() {
+		// NewClient may still panic on input we don't validate; a panic
+		// would take down the host process
+		if r := recover(); r != nil {
+			err = fmt.Errorf("opening rekordbox database: %v", r)
+			if isKeyFailure(fmt.Sprint(r)) {
+				slog.Debug("decrypting database failed", "db_path", dbPath, "error", err)
+				err = fmt.Errorf("%s: %w", dbPath, errUnsupportedKey)
+			}
+		}
+		if err != nil && snapshotDir != "" {
+			os.RemoveAll(snapshotDir)
+		}
+	}()
+
+	// Files and paths
+	client, err := rekordbox.NewClient(optionsFilePath)
+	if err != nil && isKeyFailure(err.Error()) {
+		slog.Debug("decrypting database failed", "db_path", dbPath, "error", err)
+		return nil, fmt.Errorf("%s: %w", dbPath, errUnsupportedKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening rekordbox database: %w", err)
+	}
+	if err := pingDatabase(client, dbPath); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &library{Client: client, snapshotDir: snapshotDir}, nil
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+// fakeLibrary is an in-memory libraryClient. Lookups by ID of rows it
+// doesn't hold fail like the database does, and the optional tables are
+// empty.
+type fakeLibrary struct {
+	playlists []*rekordbox.DjmdPlaylist
+	songs     []*rekordbox.DjmdSongPlaylist
+	contents  []*rekordbox.DjmdContent
+	artists   map[string]string
+	// failing holds the IDs of the playlists whose entries can't be listed
+	failing map[string]bool
+}
+
+var _ libraryClient = (*fakeLibrary)(nil)
+
+// addPlaylist adds a playlist, or a folder with attribute
+// playlistAttributeFolder, under the folder parentID.
+func (f *fakeLibrary) addPlaylist(id, parentID, name string, seq, attribute int64) {
+	f.playlists = append(f.playlists, &rekordbox.DjmdPlaylist{
+		ID:        nulltype.NullStringOf(id),
+		ParentID:  nulltype.NullStringOf(parentID),
+		Name:      nulltype.NullStringOf(name),
+		Seq:       nulltype.NullInt64Of(seq),
+		Attribute: nulltype.NullInt64Of(attribute),
+	})
+}
+
+// addContent adds a track, and returns it for setting further columns.
+func (f *fakeLibrary) addContent(id, title string) *rekordbox.DjmdContent {
+	content := &rekordbox.DjmdContent{
+		ID:    nulltype.NullStringOf(id),
+		Title: nulltype.NullStringOf(title),
+	}
+	f.contents = append(f.contents, content)
+
+	return content
+}
+
+// addSong adds contentID to playlistID at position trackNo.
+func (f *fakeLibrary) addSong(playlistID, contentID string, trackNo int64) {
+	f.songs = append(f.songs, &rekordbox.DjmdSongPlaylist{
+		ID:         nulltype.NullStringOf(fmt.Sprintf("%s-%d", playlistID, len(f.songs)+1)),
+		PlaylistID: nulltype.NullStringOf(playlistID),
+		ContentID:  nulltype.NullStringOf(contentID),
+		TrackNo:    nulltype.NullInt64Of(trackNo),
+	})
+}
+
+func (f *fakeLibrary) AllDjmdPlaylist(ctx context.Context) ([]*rekordbox.DjmdPlaylist, error) {
+	return f.playlists, nil
+}
+
+func (f *fakeLibrary) DjmdPlaylistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdPlaylist, error) {
+	for _, playlist := range f.playlists {
+		if playlist.ID.String() == id.String() {
+			return playlist, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no playlist with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdSongPlaylistByPlaylistID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdSongPlaylist, error) {
+	if f.failing[id.String()] {
+		return nil, fmt.Errorf("listing the entries of playlist %s: database disk image is malformed", id.String())
+	}
+
+	songs := []*rekordbox.DjmdSongPlaylist{}
+	for _, song := range f.songs {
+		if song.PlaylistID.String() == id.String() {
+			songs = append(songs, song)
+		}
+	}
+
+	return songs, nil
+}
+
+func (f *fakeLibrary) AllDjmdSongPlaylist(ctx context.Context) ([]*rekordbox.DjmdSongPlaylist, error) {
+	return f.songs, nil
+}
+
+func (f *fakeLibrary) AllDjmdContent(ctx context.Context) ([]*rekordbox.DjmdContent, error) {
+	return f.contents, nil
+}
+
+func (f *fakeLibrary) DjmdContentByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdContent, error) {
+	for _, content := range f.contents {
+		if content.ID.String() == id.String() {
+			return content, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no content with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdArtistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdArtist, error) {
+	name, ok := f.artists[id.String()]
+	if !ok {
+		return nil, fmt.Errorf("no artist with ID %s", id.String())
+	}
+
+	return &rekordbox.DjmdArtist{ID: id, Name: nulltype.NullStringOf(name)}, nil
+}
+
+func (f *fakeLibrary) DjmdAlbumByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdAlbum, error) {
+	return nil, fmt.Errorf("no album with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdGenreByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdGenre, error) {
+	return nil, fmt.Errorf("no genre with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdKeyByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdKey, error) {
+	return nil, fmt.Errorf("no key with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdColorByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdColor, error) {
+	return nil, fmt.Errorf("no color with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdLabelByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdLabel, error) {
+	return nil, fmt.Errorf("no label with ID %s", id.String())
+}
+
+func (f *fakeLibrary) DjmdCueByContentID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdCue, error) {
+	return []*rekordbox.DjmdCue{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdMyTag(ctx context.Context) ([]*rekordbox.DjmdMyTag, error) {
+	return []*rekordbox.DjmdMyTag{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdSongMyTag(ctx context.Context) ([]*rekordbox.DjmdSongMyTag, error) {
+	return []*rekordbox.DjmdSongMyTag{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdHistory(ctx context.Context) ([]*rekordbox.DjmdHistory, error) {
+	return []*rekordbox.DjmdHistory{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdSongHistory(ctx context.Context) ([]*rekordbox.DjmdSongHistory, error) {
+	return []*rekordbox.DjmdSongHistory{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdSongRelatedTracks(ctx context.Context) ([]*rekordbox.DjmdSongRelatedTracks, error) {
+	return []*rekordbox.DjmdSongRelatedTracks{}, nil
+}
+
+func (f *fakeLibrary) AllDjmdProperty(ctx context.Context) ([]*rekordbox.DjmdProperty, error) {
+	return []*rekordbox.DjmdProperty{}, nil
+}
@@ -0,0 +1,938 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Values of DjmdPlaylist.Attribute, which tells what kind of node a playlist
+// row is.
+const (
+	playlistAttributePlaylist = 0
+	playlistAttributeFolder   = 1
+	playlistAttributeSmart    = 4
+)
+
+type Playlist struct {
+	CombinedName string `json:"combined_name"`
+	// Path holds the names of the enclosing folders and the playlist itself,
+	// set only in the "path-array" name mode
+	Path         []string                 `json:"path,omitempty"`
+	DJMdPlaylist *rekordbox.DjmdPlaylist  `json:"dj_md_playlist,omitempty"`
+	DJMdContents []*rekordbox.DjmdContent `json:"dj_md_contents,omitempty"`
+	Tracks       []*Track                 `json:"tracks,omitempty"`
+	// DuplicatesRemoved counts the repeated entries of a track left out
+	DuplicatesRemoved int `json:"duplicates_removed,omitempty"`
+	// Seq is the position of the playlist within its folder in rekordbox,
+	// and SeqPath that of each enclosing folder followed by Seq. Sorting by
+	// SeqPath gives the order of rekordbox's sidebar.
+	Seq     int64   `json:"seq"`
+	SeqPath []int64 `json:"seq_path"`
+	// UUID is the playlist's rekordbox UUID, which unlike its ID is never
+	// reused for another playlist. Empty for libraries that have none.
+	UUID string `json:"uuid,omitempty"`
+	// ContentHash is a SHA-256 over the combined name and the content IDs
+	// of the tracks in order, which changes whenever the exported track
+	// list does
+	ContentHash string `json:"content_hash"`
+	// TrackCount is the number of tracks exported, after the track filters
+	// and without entries whose track is missing, and TotalDurationMs their
+	// combined duration
+	TrackCount      int   `json:"track_count"`
+	TotalDurationMs int64 `json:"total_duration_ms"`
+}
+
+// key identifies pl in the files a sync keeps between runs: by UUID, or by
+// ID where there is none.
+func (pl *Playlist) key() string {
+	if pl.UUID != "" {
+		return pl.UUID
+	}
+
+	return pl.DJMdPlaylist.ID.String()
+}
+
+// CollectOptions select and shape what collect gathers. The JSON form is what
+// the exported functions accept from the host.
+type CollectOptions struct {
+	// OptionsPath is the rekordbox options.json to open, see openClient. It is
+	// only read by the exported functions; the CLI has its own flag.
+	OptionsPath string `json:"options_path"`
+	// CacheTTLSeconds lets getPlaylists and getPlaylistTree answer from an
+	// on-disk cache of their last output for this long, as long as the
+	// database hasn't changed. Zero disables the cache.
+	CacheTTLSeconds float64 `json:"cache_ttl_seconds"`
+	// NoCache ignores a cached output, refreshing it
+	NoCache bool `json:"no_cache"`
+	// Snapshot reads a copy of the database rather than the file rekordbox
+	// keeps locked while running, see snapshotLibrary
+	Snapshot bool `json:"snapshot"`
+	// IncludePrefixes keeps only playlists whose combined name starts with one
+	// of the prefixes, compared case-insensitively. Empty keeps all.
+	IncludePrefixes []string `json:"include_prefixes"`
+	// IncludeRegex keeps only playlists whose combined name matches one of
+	// the regular expressions, in Go syntax, e.g. "(?i)^club/". Empty keeps
+	// all.
+	IncludeRegex []string `json:"include_regex"`
+	// ExcludeRegex drops playlists whose combined name matches one of the
+	// regular expressions, even if included otherwise
+	ExcludeRegex []string `json:"exclude_regex"`
+	// PlaylistID keeps only the playlist with this rekordbox ID, empty for
+	// all
+	PlaylistID string `json:"playlist_id"`
+	// TimeoutSeconds aborts the collection after this long; zero waits forever,
+	// which can hang if rekordbox holds a lock on the database
+	TimeoutSeconds float64 `json:"timeout_seconds"`
+	// DBRetries is how often a query failing because the database is locked
+	// is retried, 3 times when zero; negative disables retrying
+	DBRetries int `json:"db_retries"`
+	// DBRetryDelaySeconds is the wait before the first retry, doubling after
+	// each one; 0.1 when zero
+	DBRetryDelaySeconds float64 `json:"db_retry_delay_seconds"`
+	// QueryTimeoutSeconds cuts off any single query taking longer, zero for
+	// no limit. A playlist whose query times out is skipped; the listings
+	// loaded up front still abort the collection.
+	QueryTimeoutSeconds float64 `json:"query_timeout_seconds"`
+	// Since omits playlists that haven't changed after this time. The zero
+	// value keeps all.
+	Since time.Time `json:"since"`
+	// AnalysisDir is the folder rekordbox's analysis files are relative to,
+	// detected from options.json when empty. Beat grids are read from there,
+	// and artwork paths are made absolute against it.
+	AnalysisDir string `json:"analysis_dir"`
+	// TrackTags keeps only tracks that have at least one of these My Tags,
+	// compared case-insensitively, and drops playlists left without tracks.
+	// Empty keeps all tracks.
+	TrackTags []string `json:"track_tags"`
+	// BPMMin and BPMMax keep only tracks whose BPM lies within them, both
+	// inclusive; zero leaves that end open. Playlists left without tracks
+	// are dropped.
+	BPMMin float64 `json:"bpm_min"`
+	BPMMax float64 `json:"bpm_max"`
+	// SkipUnanalyzedBPM drops tracks without a BPM, which a range starting
+	// at zero would otherwise all keep
+	SkipUnanalyzedBPM bool `json:"skip_unanalyzed_bpm"`
+	// AnalyzedOnly drops tracks rekordbox hasn't analyzed, which have no beat
+	// grid, after the other track filters. Playlists left without tracks
+	// are dropped.
+	AnalyzedOnly bool `json:"analyzed_only"`
+	// MinRating keeps only tracks rated at least this many stars, 1 to 5,
+	// dropping playlists left without tracks. Zero keeps all.
+	MinRating int64 `json:"min_rating"`
+	// IncludeUnrated keeps unrated tracks despite MinRating, as having no
+	// rating isn't the same as having a low one
+	IncludeUnrated bool `json:"include_unrated"`
+	// AddedSince keeps only tracks added to the collection after this time,
+	// dropping playlists left without tracks. The zero value keeps all.
+	AddedSince time.Time `json:"added_since"`
+	// MinTracks drops playlists with fewer tracks than this, counted after
+	// the track filters. When syncing, it counts the matched tracks instead.
+	// Zero keeps all.
+	MinTracks int `json:"min_tracks"`
+	// Concurrency is how many playlists are resolved at once, the number of
+	// CPUs when zero
+	Concurrency int `json:"concurrency"`
+	// NameSeparator joins the folder levels of CombinedName, " - " when empty
+	NameSeparator string `json:"name_separator"`
+	// NamePath also exports the levels unjoined, as Path. It is the same as
+	// NameMode "path-array".
+	NamePath bool `json:"name_path"`
+	// NameMode selects how CombinedName is built: "full" joins the folder
+	// levels (the default), "leaf" keeps only the playlist's own name and
+	// "path-array" is "full" with Path also set
+	NameMode string `json:"name_mode"`
+	// Sort orders the playlists: "name" by combined name (the default) or
+	// "seq" the way rekordbox lists them in its sidebar
+	Sort string `json:"sort"`
+	// StatFiles exports when each track's file was last modified, as
+	// FileModifiedAt, looking the file up at its remapped path
+	StatFiles bool `json:"stat_files"`
+	// CheckFiles sets each track's FileExists, looking the file up at its
+	// remapped path with symlinks resolved, and lists the missing ones in
+	// the envelope's missing_files
+	CheckFiles bool `json:"check_files"`
+	// Fields lists the track sub-objects to populate: cues, beat_grid,
+	// energy, my_tags, history (play_count and last_played) and
+	// related_tracks. The others are left empty without being read, which
+	// makes a minimal selection faster too. Empty populates all of them.
+	Fields []string `json:"fields"`
+	// KeepDuplicates keeps every entry of a track added to a playlist more
+	// than once; by default only the first is kept
+	KeepDuplicates bool `json:"keep_duplicates"`
+	// Progress, if set, is called after each playlist is resolved with how
+	// many of the selected playlists are done. Calls never overlap.
+	Progress func(processed, total int) `json:"-"`
+
+	// stats, if set, accumulates the counts of what was collected
+	stats *runStats
+	// fileRemaps rewrite the paths StatFiles and CheckFiles look files up
+	// at, see PathRemap
+	fileRemaps pathRemaps
+	// includeRes and excludeRes are IncludeRegex and ExcludeRegex compiled
+	// by compileNameFilters
+	includeRes, excludeRes []*regexp.Regexp
+	// emit, if set, is given each playlist of a database collection as soon
+	// as it and those before it are resolved, in output order, instead of
+	// the playlists being kept in the collection. An error stops the
+	// collection and is returned.
+	emit func(pl *Playlist) error
+}
+
+func (opts CollectOptions) concurrency() int {
+	if opts.Concurrency > 0 {
+		return opts.Concurrency
+	}
+
+	return runtime.NumCPU()
+}
+
+func (opts CollectOptions) nameSeparator() string {
+	if opts.NameSeparator != "" {
+		return opts.NameSeparator
+	}
+
+	return " - "
+}
+
+const (
+	nameModeFull      = "full"
+	nameModeLeaf      = "leaf"
+	nameModePathArray = "path-array"
+)
+
+func (opts CollectOptions) nameMode() (string, error) {
+	switch opts.NameMode {
+	case "":
+		if opts.NamePath {
+			return nameModePathArray, nil
+		}
+		return nameModeFull, nil
+	case nameModeFull, nameModeLeaf, nameModePathArray:
+		return opts.NameMode, nil
+	}
+
+	return "", fmt.Errorf("unknown name mode %q, expected full, leaf or path-array", opts.NameMode)
+}
+
+// applyName names pl after its folder path according to the name mode.
+// Include prefixes are matched against the full name beforehand, so the mode
+// only changes what is exported.
+func (opts CollectOptions) applyName(pl *Playlist, path []string) {
+	mode, _ := opts.nameMode()
+	switch mode {
+	case nameModeLeaf:
+		pl.CombinedName = path[len(path)-1]
+	case nameModePathArray:
+		pl.Path = path
+	}
+}
+
+// validate checks the options that select one of several modes, so a typo
+// fails before anything is read.
+func (opts CollectOptions) validate() error {
+	if _, err := opts.nameMode(); err != nil {
+		return err
+	}
+	if _, err := opts.fields(); err != nil {
+		return err
+	}
+	if opts.MinRating < 0 || opts.MinRating > maxStars {
+		return fmt.Errorf("minimum rating %d is not between 0 and %d stars", opts.MinRating, maxStars)
+	}
+	_, err := opts.playlistSort()
+
+	return err
+}
+
+// disambiguateNames renames playlists whose combined name, compared
+// case-insensitively, was already taken by an earlier one, appending " (2)",
+// " (3)" and so on. Two folders can hold playlists of the same name, which
+// consumers and Plex would otherwise be unable to tell apart.
+func disambiguateNames(playlists []*Playlist) {
+	taken := nameSet{}
+	for _, pl := range playlists {
+		taken.disambiguate(pl)
+	}
+}
+
+// nameSet holds the lowercased combined names taken, for disambiguating
+// playlists one at a time.
+type nameSet map[string]bool
+
+// disambiguate renames pl as disambiguateNames does if its name is taken,
+// then takes the name.
+func (taken nameSet) disambiguate(pl *Playlist) {
+	name := pl.CombinedName
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)", pl.CombinedName, n)
+	}
+	taken[strings.ToLower(name)] = true
+
+	if name != pl.CombinedName {
+		slog.Warn("renamed playlist with a duplicate name", "playlist", pl.CombinedName, "id", pl.DJMdPlaylist.ID.String(), "name", name)
+		pl.CombinedName = name
+	}
+}
+
+func (opts CollectOptions) timeout() time.Duration {
+	return time.Duration(opts.TimeoutSeconds * float64(time.Second))
+}
+
+// compileNameFilters compiles IncludeRegex and ExcludeRegex for includes, so
+// that an invalid expression is reported up front instead of never matching.
+func (opts *CollectOptions) compileNameFilters() error {
+	compile := func(exprs []string) ([]*regexp.Regexp, error) {
+		res := make([]*regexp.Regexp, 0, len(exprs))
+		for _, expr := range exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid playlist regex %q: %w", expr, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+
+	var err error
+	if opts.includeRes, err = compile(opts.IncludeRegex); err != nil {
+		return err
+	}
+	opts.excludeRes, err = compile(opts.ExcludeRegex)
+
+	return err
+}
+
+func (opts CollectOptions) includes(combinedName string) bool {
+	for _, re := range opts.excludeRes {
+		if re.MatchString(combinedName) {
+			return false
+		}
+	}
+
+	if len(opts.includeRes) > 0 && !matchesAny(opts.includeRes, combinedName) {
+		return false
+	}
+
+	if len(opts.IncludePrefixes) == 0 {
+		return true
+	}
+
+	name := strings.ToLower(combinedName)
+	for _, prefix := range opts.IncludePrefixes {
+		if strings.HasPrefix(name, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (opts CollectOptions) keepsTrack(tags []*MyTag) bool {
+	if len(opts.TrackTags) == 0 {
+		return true
+	}
+
+	for _, tag := range tags {
+		for _, want := range opts.TrackTags {
+			if strings.EqualFold(tag.Name, want) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// keepsBPM reports whether a track with the given BPM passes the BPM filters.
+func (opts CollectOptions) keepsBPM(bpm float64) bool {
+	if bpm == 0 && opts.SkipUnanalyzedBPM {
+		return false
+	}
+	if opts.BPMMin > 0 && bpm < opts.BPMMin {
+		return false
+	}
+	if opts.BPMMax > 0 && bpm > opts.BPMMax {
+		return false
+	}
+
+	return true
+}
+
+// keepsAdded reports whether a track added at dateAdded passes the AddedSince
+// filter. Tracks of unknown age never do.
+func (opts CollectOptions) keepsAdded(dateAdded *time.Time) bool {
+	if opts.AddedSince.IsZero() {
+		return true
+	}
+
+	return dateAdded != nil && dateAdded.After(opts.AddedSince)
+}
+
+// keepsRating reports whether a track with the given rating passes the
+// MinRating filter.
+func (opts CollectOptions) keepsRating(rating int64) bool {
+	if opts.MinRating == 0 {
+		return true
+	}
+
+	stars := ratingStars(rating)
+	if stars == 0 {
+		return opts.IncludeUnrated
+	}
+
+	return stars >= opts.MinRating
+}
+
+// keepsContent applies the filters on DjmdContent columns to content.
+func (opts CollectOptions) keepsContent(content *rekordbox.DjmdContent) bool {
+	return opts.keepsBPM(contentBPM(content)) && opts.keepsAdded(contentDateAdded(content)) && opts.keepsRating(content.Rating.Int64Value())
+}
+
+// keepsAnalyzed reports whether content passes the AnalyzedOnly filter.
+func (opts CollectOptions) keepsAnalyzed(content *rekordbox.DjmdContent) bool {
+	return !opts.AnalyzedOnly || content.Analysed.Int64Value() != 0
+}
+
+// filtersTracks reports whether tracks are filtered by tag, BPM, age,
+// analysis or rating, in which case playlists left empty are dropped.
+func (opts CollectOptions) filtersTracks() bool {
+	return len(opts.TrackTags) > 0 || opts.BPMMin > 0 || opts.BPMMax > 0 || opts.SkipUnanalyzedBPM || !opts.AddedSince.IsZero() || opts.AnalyzedOnly || opts.MinRating > 0
+}
+
+// contentBPM returns the BPM of content, which rekordbox stores multiplied by
+// 100.
+func contentBPM(content *rekordbox.DjmdContent) float64 {
+	return bpmFromStored(content.BPM.Int64Value())
+}
+
+// collection is everything gathered in a single pass over the library.
+type collection struct {
+	Playlists []*Playlist
+	// Unresolved lists entries whose content row or file is missing
+	Unresolved []*unresolvedTrack
+	// SkippedEmpty counts playlists dropped because filtering their tracks
+	// left none
+	SkippedEmpty int
+	// SkippedSmall counts playlists dropped for having fewer than MinTracks
+	// tracks
+	SkippedSmall int
+	// Unanalyzed counts the entries CollectOptions.AnalyzedOnly dropped
+	Unanalyzed int
+}
+
+// maxPlaylistDepth caps how many folder levels getRecursivePlaylistPath
+// follows. rekordbox doesn't nest anywhere near this deep, so longer chains
+// come from a corrupt library; their outermost levels are dropped and the
+// outermost level kept is prefixed with "…/", so the combined name starts
+// with it whatever the separator.
+const maxPlaylistDepth = 32
+
+// isRootParent reports whether parentID marks a top-level playlist. rekordbox
+// writes "root", but libraries migrated from older versions or edited by
+// other tools are also seen with "0" or no parent at all.
+func isRootParent(parentID string) bool {
+	switch parentID {
+	case "root", "0", "":
+		return true
+	}
+
+	return false
+}
+
+// getRecursivePlaylistPath prefixes pathSoFar with the names of all of the
+// playlist's ancestors. Ancestors are looked up in nodes first, and any that
+// had to be fetched are added to it, so each node is queried at most once.
+//
+// visited holds the IDs walked so far; a corrupted library can have a parent
+// chain that loops, and the walk stops at the first repeated ID instead of
+// recursing forever. Pass nil to start a walk.
+func getRecursivePlaylistPath(ctx context.Context, client libraryClient, nodes map[string]*rekordbox.DjmdPlaylist, playlist *rekordbox.DjmdPlaylist, pathSoFar []string, visited map[string]bool) []string {
+	// check if has a parent
+	if isRootParent(playlist.ParentID.String()) {
+		return pathSoFar
+	}
+
+	if visited == nil {
+		visited = map[string]bool{}
+	}
+	visited[playlist.ID.String()] = true
+	if visited[playlist.ParentID.String()] {
+		slog.Warn("circular playlist parents", "playlist", strings.Join(pathSoFar, "/"), "parent_id", playlist.ParentID.String())
+		return pathSoFar
+	}
+	if len(pathSoFar) >= maxPlaylistDepth {
+		slog.Warn("playlist nested too deeply, truncating its name", "playlist", strings.Join(pathSoFar, "/"), "max_depth", maxPlaylistDepth)
+		truncated := append([]string{}, pathSoFar...)
+		truncated[0] = "…/" + truncated[0]
+		return truncated
+	}
+
+	// get parent
+	parent, ok := nodes[playlist.ParentID.String()]
+	if !ok {
+		var err error
+		parent, err = client.DjmdPlaylistByID(ctx, playlist.ParentID)
+		if err != nil {
+			// unlike a root sentinel, a parent ID that resolves to no
+			// row is a broken link; name the playlist as if top level
+			slog.Warn("dangling playlist parent, treating playlist as top level", "playlist", strings.Join(pathSoFar, "/"), "parent_id", playlist.ParentID.String(), "error", err)
+			return pathSoFar
+		}
+
+		nodes[parent.ID.String()] = parent
+	}
+
+	path := append([]string{parent.Name.String()}, pathSoFar...)
+	return getRecursivePlaylistPath(ctx, client, nodes, parent, path, visited)
+}
+
+// collectPlaylists resolves every playlist together with its tracks in
+// playlist order. Folders are skipped; playlists without songs are kept with no
+// tracks so callers can report them as empty.
+func collectPlaylists(ctx context.Context, client libraryClient, opts CollectOptions) ([]*Playlist, error) {
+	c, err := collect(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Playlists, nil
+}
+
+func collect(ctx context.Context, client libraryClient, opts CollectOptions) (*collection, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := opts.timeout()
+	if timeout <= 0 {
+		return collectLibrary(ctx, client, opts)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c, err := collectLibrary(ctx, client, opts)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("collection timed out after %s", timeout)
+	}
+
+	return c, err
+}
+
+// newResolver returns a resolver for client, set up as opts asks and with
+// the content rows and the optional tables loaded.
+func (opts CollectOptions) newResolver(ctx context.Context, client libraryClient) (*resolver, error) {
+	r := newResolver(client, opts.AnalysisDir)
+	r.statFiles, r.fileRemaps = opts.StatFiles, opts.fileRemaps
+	r.checkFiles = opts.CheckFiles
+	r.fields, _ = opts.fields()
+	r.tagFilter = len(opts.TrackTags) > 0
+	if err := r.loadContents(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.loadOptional(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func collectLibrary(ctx context.Context, client libraryClient, opts CollectOptions) (*collection, error) {
+	playlists, err := client.AllDjmdPlaylist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing playlists: %w", err)
+	}
+
+	// every node is already in the listing, so parent lookups rarely need a
+	// query of their own
+	nodes := make(map[string]*rekordbox.DjmdPlaylist, len(playlists))
+	for _, playlist := range playlists {
+		nodes[playlist.ID.String()] = playlist
+	}
+
+	c := &collection{
+		Playlists:  []*Playlist{},
+		Unresolved: []*unresolvedTrack{},
+	}
+	r, err := opts.newResolver(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// names are resolved up front since nodes isn't safe for concurrent use
+	selected := []*Playlist{}
+	for _, playlist := range playlists {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if playlist.Attribute.Int64Value() == playlistAttributeFolder {
+			continue
+		}
+		if opts.PlaylistID != "" && playlist.ID.String() != opts.PlaylistID {
+			continue
+		}
+
+		pl := &Playlist{}
+
+		path := getRecursivePlaylistPath(ctx, client, nodes, playlist, []string{playlist.Name.String()}, nil)
+		pl.CombinedName = strings.Join(path, opts.nameSeparator())
+		if !opts.includes(pl.CombinedName) {
+			continue
+		}
+		opts.applyName(pl, path)
+
+		pl.DJMdPlaylist = playlist
+		pl.UUID = playlist.UUID.String()
+		selected = append(selected, pl)
+	}
+	// names and seqs are known before any track is, so the playlists are
+	// sorted up front and each can be finished as soon as those before it
+	// are
+	addSeqs(selected, nodes)
+	sortPlaylists(selected, opts)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		i    int
+		part *collection
+		err  error
+	}
+	jobs := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				part, err := tryCollectPlaylist(ctx, r, selected[i], opts)
+				results <- result{i: i, part: part, err: err}
+			}
+		}()
+	}
+	go func() {
+		for i := range selected {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	parts := make([]*collection, len(selected))
+	errs := make([]error, len(selected))
+	next, processed := 0, 0
+	taken := nameSet{}
+	var emitErr error
+	for res := range results {
+		processed++
+		if opts.Progress != nil {
+			opts.Progress(processed, len(selected))
+		}
+
+		if res.part == nil {
+			res.part = &collection{}
+		}
+		parts[res.i], errs[res.i] = res.part, res.err
+
+		// finishing in sorted order keeps the output stable however the
+		// workers were scheduled
+		for ; next < len(parts) && parts[next] != nil && emitErr == nil; next++ {
+			part := parts[next]
+			parts[next] = &collection{}
+
+			// one broken playlist shouldn't cost the others, so failures are
+			// reported alongside the playlists that did collect. A failed
+			// playlist still takes its name, so it is reported by the name
+			// the playlist has in Plex and the others keep theirs.
+			if err := errs[next]; err != nil && ctx.Err() == nil {
+				pl := selected[next]
+				taken.disambiguate(pl)
+				slog.Warn("skipping playlist that failed to collect", "playlist", pl.CombinedName, "error", err)
+				opts.stats.addFailure(pl.CombinedName, err)
+			}
+			addContentHashes(part.Playlists)
+			addTotals(part.Playlists)
+			for _, pl := range part.Playlists {
+				taken.disambiguate(pl)
+			}
+			opts.stats.addPlaylists(part.Playlists, part.SkippedEmpty, part.SkippedSmall)
+
+			c.Unresolved = append(c.Unresolved, part.Unresolved...)
+			c.SkippedEmpty += part.SkippedEmpty
+			c.SkippedSmall += part.SkippedSmall
+			c.Unanalyzed += part.Unanalyzed
+			if opts.emit == nil {
+				c.Playlists = append(c.Playlists, part.Playlists...)
+				continue
+			}
+			for _, pl := range part.Playlists {
+				if emitErr = opts.emit(pl); emitErr != nil {
+					cancel()
+					break
+				}
+			}
+		}
+	}
+
+	if emitErr != nil {
+		return nil, emitErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	opts.stats.addUnanalyzed(c.Unanalyzed)
+	if opts.CheckFiles {
+		opts.stats.addMissingFiles(c.Unresolved)
+	}
+
+	return c, nil
+}
+
+// tryCollectPlaylist is collectPlaylist with a panic while resolving pl, such
+// as one from the rekordbox client on a malformed row, turned into an error.
+func tryCollectPlaylist(ctx context.Context, r *resolver, pl *Playlist, opts CollectOptions) (c *collection, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			c, err = nil, fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return collectPlaylist(ctx, r, pl, opts)
+}
+
+// collectPlaylist resolves the tracks of pl. The returned collection holds pl
+// unless it was filtered out, along with its unresolved entries.
+func collectPlaylist(ctx context.Context, r *resolver, pl *Playlist, opts CollectOptions) (*collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c := &collection{}
+	playlist := pl.DJMdPlaylist
+
+	if playlist.Attribute.Int64Value() == playlistAttributeSmart {
+		contents, err := evaluateSmartPlaylist(ctx, r, playlist)
+		if err != nil {
+			// an empty export would empty the synced Plex playlist too
+			return nil, fmt.Errorf("evaluating smart playlist %s: %w", playlist.Name.String(), err)
+		}
+
+		if !changedSince(r, opts.Since, playlist, nil, contents) {
+			return c, nil
+		}
+
+		for i, content := range contents {
+			if !opts.keepsTrack(r.contentMyTags(content.ID.String())) || !opts.keepsContent(content) {
+				continue
+			}
+			if !opts.keepsAnalyzed(content) {
+				c.Unanalyzed++
+				continue
+			}
+			c.addTrack(ctx, r, pl, int64(i+1), content, nil)
+		}
+
+		c.addPlaylist(pl, opts)
+		return c, nil
+	}
+
+	playlistSongs, err := r.client.DjmdSongPlaylistByPlaylistID(ctx, playlist.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing songs of playlist %s: %w", playlist.Name.String(), err)
+	}
+
+	if !changedSince(r, opts.Since, playlist, playlistSongs, nil) {
+		return c, nil
+	}
+
+	sortPlaylistSongs(playlistSongs)
+
+	seen := map[string]bool{}
+	for _, playlistSong := range playlistSongs {
+		// Skip deleted playlist entries
+		if playlistSong.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		if !opts.KeepDuplicates {
+			if seen[playlistSong.ContentID.String()] {
+				pl.DuplicatesRemoved++
+				continue
+			}
+			seen[playlistSong.ContentID.String()] = true
+		}
+
+		if !opts.keepsTrack(r.contentMyTags(playlistSong.ContentID.String())) {
+			continue
+		}
+
+		content, ok := r.contents[playlistSong.ContentID.String()]
+		if !ok {
+			// Skip this track if not found
+			slog.Warn("track content not found", "playlist", pl.CombinedName, "content_id", playlistSong.ContentID.String(), "entry_id", playlistSong.ID.String(), "track_no", playlistSong.TrackNo.Int64Value())
+			c.Unresolved = append(c.Unresolved, &unresolvedTrack{
+				Playlist:  pl.CombinedName,
+				TrackNo:   playlistSong.TrackNo.Int64Value(),
+				ContentID: playlistSong.ContentID.String(),
+				Reason:    reasonContentMissing,
+			})
+			continue
+		}
+
+		if !opts.keepsContent(content) {
+			continue
+		}
+		if !opts.keepsAnalyzed(content) {
+			c.Unanalyzed++
+			continue
+		}
+
+		c.addTrack(ctx, r, pl, playlistSong.TrackNo.Int64Value(), content, entryAddedAt(playlistSong))
+	}
+
+	if pl.DuplicatesRemoved > 0 {
+		slog.Info("removed duplicate tracks", "playlist", pl.CombinedName, "count", pl.DuplicatesRemoved)
+	}
+
+	c.addPlaylist(pl, opts)
+	return c, nil
+}
+
+// changedSince reports whether the playlist, any of its entries (including
+// deleted ones) or any of its tracks were updated after since. A zero since
+// counts everything as changed.
+func changedSince(r *resolver, since time.Time, playlist *rekordbox.DjmdPlaylist, songs []*rekordbox.DjmdSongPlaylist, contents []*rekordbox.DjmdContent) bool {
+	if since.IsZero() || playlist.UpdatedAt.Time().After(since) {
+		return true
+	}
+
+	for _, song := range songs {
+		if song.UpdatedAt.Time().After(since) {
+			return true
+		}
+		if content, ok := r.contents[song.ContentID.String()]; ok && content.UpdatedAt.Time().After(since) {
+			return true
+		}
+	}
+
+	for _, content := range contents {
+		if content.UpdatedAt.Time().After(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addTotals sets the TrackCount and TotalDurationMs of playlists.
+func addTotals(playlists []*Playlist) {
+	for _, pl := range playlists {
+		pl.TrackCount, pl.TotalDurationMs = len(pl.Tracks), 0
+		for _, track := range pl.Tracks {
+			pl.TotalDurationMs += track.durationMs()
+		}
+	}
+}
+
+// addPlaylist keeps pl, unless filtering its tracks left none.
+func (c *collection) addPlaylist(pl *Playlist, opts CollectOptions) {
+	if opts.filtersTracks() && len(pl.Tracks) == 0 {
+		c.SkippedEmpty++
+		return
+	}
+	if len(pl.Tracks) < opts.MinTracks {
+		c.SkippedSmall++
+		return
+	}
+
+	c.Playlists = append(c.Playlists, pl)
+}
+
+// addTrack appends content to pl as its trackNo-th entry, placed in the
+// playlist at addedAt, noting it as unresolved if its file is missing.
+func (c *collection) addTrack(ctx context.Context, r *resolver, pl *Playlist, trackNo int64, content *rekordbox.DjmdContent, addedAt *time.Time) {
+	// Skip deleted content
+	if content.RbLocalDeleted.Int64Value() != 0 {
+		return
+	}
+
+	info, missing := checkContentFile(ctx, r, pl.CombinedName, trackNo, content)
+	if missing != nil {
+		c.Unresolved = append(c.Unresolved, missing)
+	}
+
+	track := r.track(ctx, content)
+	track.AddedToPlaylistAt = addedAt
+	if r.statFiles && info != nil {
+		modified := info.ModTime()
+		track.FileModifiedAt = &modified
+	}
+	if r.checkFiles {
+		exists := info != nil
+		track.FileExists = &exists
+	}
+
+	pl.DJMdContents = append(pl.DJMdContents, content)
+	pl.Tracks = append(pl.Tracks, track)
+}
+
+// entryAddedAt returns when song was added to its playlist, which is when
+// its row was created, or nil if that isn't recorded.
+func entryAddedAt(song *rekordbox.DjmdSongPlaylist) *time.Time {
+	if t := song.CreatedAt.Time(); !t.IsZero() {
+		return &t
+	}
+
+	return nil
+}
+
+// sortPlaylistSongs orders entries the way the DJ arranged them. Corrupted
+// libraries can have duplicate track numbers, so ties are broken by entry ID to
+// keep the output deterministic.
+func sortPlaylistSongs(songs []*rekordbox.DjmdSongPlaylist) {
+	sort.Slice(songs, func(i, j int) bool {
+		a, b := songs[i], songs[j]
+		if a.TrackNo.Int64Value() != b.TrackNo.Int64Value() {
+			return a.TrackNo.Int64Value() < b.TrackNo.Int64Value()
+		}
+
+		return lessID(a.ID.String(), b.ID.String())
+	})
+}
+
+// lessID compares rekordbox IDs, which are numeric strings, by numeric value
+// when possible.
+func lessID(a, b string) bool {
+	ai, errA := strconv.ParseInt(a, 10, 64)
+	bi, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		return ai < bi
+	}
+
+	return a < b
+}
@@ -0,0 +1,387 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+// newTestLibrary returns a library of a folder Sets holding the playlists
+// Warmup and Peak, and the top-level playlists Techno and Empty:
+//
+//	Sets (seq 2)
+//	  Peak (seq 2): t3, t1
+//	  Warmup (seq 1): t2
+//	Techno (seq 1): t4, t2, t1
+//	Empty (seq 3)
+func newTestLibrary() *fakeLibrary {
+	f := &fakeLibrary{artists: map[string]string{"a1": "Artist"}}
+	f.addPlaylist("1", "root", "Sets", 2, playlistAttributeFolder)
+	f.addPlaylist("2", "1", "Peak", 2, playlistAttributePlaylist)
+	f.addPlaylist("3", "1", "Warmup", 1, playlistAttributePlaylist)
+	f.addPlaylist("4", "root", "Techno", 1, playlistAttributePlaylist)
+	f.addPlaylist("5", "root", "Empty", 3, playlistAttributePlaylist)
+
+	ratings := []int64{5, 0, 3, 4}
+	bpms := []int64{12800, 12000, 14000, 13000}
+	for i := range ratings {
+		content := f.addContent(fmt.Sprintf("t%d", i+1), fmt.Sprintf("Track %d", i+1))
+		content.Rating = nulltype.NullInt64Of(ratings[i])
+		content.BPM = nulltype.NullInt64Of(bpms[i])
+		content.ArtistID = nulltype.NullStringOf("a1")
+	}
+
+	// entries listed out of order, to be sorted by track number
+	f.addSong("2", "t1", 2)
+	f.addSong("2", "t3", 1)
+	f.addSong("3", "t2", 1)
+	f.addSong("4", "t1", 3)
+	f.addSong("4", "t4", 1)
+	f.addSong("4", "t2", 2)
+
+	return f
+}
+
+// playlistNames returns the combined name of each playlist.
+func playlistNames(playlists []*Playlist) []string {
+	names := []string{}
+	for _, pl := range playlists {
+		names = append(names, pl.CombinedName)
+	}
+
+	return names
+}
+
+// trackIDs returns the content ID of each track of pl.
+func trackIDs(pl *Playlist) []string {
+	ids := []string{}
+	for _, track := range pl.Tracks {
+		ids = append(ids, track.ContentID)
+	}
+
+	return ids
+}
+
+func TestCollectPlaylistsOrder(t *testing.T) {
+	tests := []struct {
+		sort  string
+		names []string
+	}{
+		{"", []string{"Empty", "Sets - Peak", "Sets - Warmup", "Techno"}},
+		{sortName, []string{"Empty", "Sets - Peak", "Sets - Warmup", "Techno"}},
+		{sortSeq, []string{"Techno", "Sets - Warmup", "Sets - Peak", "Empty"}},
+	}
+
+	for _, tt := range tests {
+		playlists, err := collectPlaylists(context.Background(), newTestLibrary(), CollectOptions{Sort: tt.sort})
+		if err != nil {
+			t.Fatalf("sort %q: %v", tt.sort, err)
+		}
+		if got := playlistNames(playlists); !reflect.DeepEqual(got, tt.names) {
+			t.Errorf("sort %q: playlists %v, want %v", tt.sort, got, tt.names)
+		}
+	}
+}
+
+func TestCollectPlaylistsTrackOrder(t *testing.T) {
+	playlists, err := collectPlaylists(context.Background(), newTestLibrary(), CollectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"Empty":         {},
+		"Sets - Peak":   {"t3", "t1"},
+		"Sets - Warmup": {"t2"},
+		"Techno":        {"t4", "t2", "t1"},
+	}
+	for _, pl := range playlists {
+		if got := trackIDs(pl); !reflect.DeepEqual(got, want[pl.CombinedName]) {
+			t.Errorf("%s: tracks %v, want %v", pl.CombinedName, got, want[pl.CombinedName])
+		}
+	}
+}
+
+func TestCollectPlaylistsSeqs(t *testing.T) {
+	playlists, err := collectPlaylists(context.Background(), newTestLibrary(), CollectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]int64{
+		"Empty":         {3},
+		"Sets - Peak":   {2, 2},
+		"Sets - Warmup": {2, 1},
+		"Techno":        {1},
+	}
+	for _, pl := range playlists {
+		if !reflect.DeepEqual(pl.SeqPath, want[pl.CombinedName]) {
+			t.Errorf("%s: seq path %v, want %v", pl.CombinedName, pl.SeqPath, want[pl.CombinedName])
+		}
+	}
+}
+
+func TestCollectEmit(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		var names []string
+		tracks := 0
+		opts := CollectOptions{Concurrency: concurrency, stats: newRunStats()}
+		opts.emit = func(pl *Playlist) error {
+			names = append(names, pl.CombinedName)
+			tracks += len(pl.Tracks)
+			return nil
+		}
+
+		c, err := collect(context.Background(), newTestLibrary(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Playlists) != 0 {
+			t.Errorf("concurrency %d: %d playlists kept, want them emitted only", concurrency, len(c.Playlists))
+		}
+		if want := []string{"Empty", "Sets - Peak", "Sets - Warmup", "Techno"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("concurrency %d: emitted %v, want %v", concurrency, names, want)
+		}
+		if tracks != 6 || opts.stats.PlaylistsProcessed != 4 || opts.stats.Tracks != 6 {
+			t.Errorf("concurrency %d: emitted %d tracks, stats %d playlists and %d tracks, want 6, 4 and 6", concurrency, tracks, opts.stats.PlaylistsProcessed, opts.stats.Tracks)
+		}
+	}
+}
+
+func TestCollectEmitError(t *testing.T) {
+	errStop := errors.New("stop")
+	emitted := 0
+	opts := CollectOptions{emit: func(pl *Playlist) error {
+		emitted++
+		return errStop
+	}}
+
+	if _, err := collect(context.Background(), newTestLibrary(), opts); !errors.Is(err, errStop) {
+		t.Errorf("error %v, want the emit error", err)
+	}
+	if emitted != 1 {
+		t.Errorf("emitted %d playlists, want to stop after the first", emitted)
+	}
+}
+
+func TestCollectPlaylistsFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CollectOptions
+		// want maps the combined name of every playlist expected to the
+		// content IDs of its tracks
+		want map[string][]string
+	}{
+		{
+			name: "include prefix",
+			opts: CollectOptions{IncludePrefixes: []string{"Sets"}},
+			want: map[string][]string{"Sets - Peak": {"t3", "t1"}, "Sets - Warmup": {"t2"}},
+		},
+		{
+			name: "include regex",
+			opts: CollectOptions{IncludeRegex: []string{"^Tech"}},
+			want: map[string][]string{"Techno": {"t4", "t2", "t1"}},
+		},
+		{
+			name: "exclusions win",
+			opts: CollectOptions{IncludePrefixes: []string{"Sets"}, ExcludeRegex: []string{"(?i)warm"}},
+			want: map[string][]string{"Sets - Peak": {"t3", "t1"}},
+		},
+		{
+			name: "single playlist",
+			opts: CollectOptions{PlaylistID: "3"},
+			want: map[string][]string{"Sets - Warmup": {"t2"}},
+		},
+		{
+			// Warmup and Empty are left empty by the filter and dropped
+			name: "BPM range",
+			opts: CollectOptions{BPMMin: 125, BPMMax: 135},
+			want: map[string][]string{"Sets - Peak": {"t1"}, "Techno": {"t4", "t1"}},
+		},
+		{
+			name: "minimum rating",
+			opts: CollectOptions{MinRating: 4},
+			want: map[string][]string{"Sets - Peak": {"t1"}, "Techno": {"t4", "t1"}},
+		},
+		{
+			name: "minimum rating with unrated",
+			opts: CollectOptions{MinRating: 4, IncludeUnrated: true},
+			want: map[string][]string{"Sets - Peak": {"t1"}, "Sets - Warmup": {"t2"}, "Techno": {"t4", "t2", "t1"}},
+		},
+		{
+			name: "minimum tracks",
+			opts: CollectOptions{MinTracks: 2},
+			want: map[string][]string{"Sets - Peak": {"t3", "t1"}, "Techno": {"t4", "t2", "t1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		if err := opts.compileNameFilters(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		playlists, err := collectPlaylists(context.Background(), newTestLibrary(), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		got := map[string][]string{}
+		for _, pl := range playlists {
+			got[pl.CombinedName] = trackIDs(pl)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollectPlaylistsDuplicates(t *testing.T) {
+	f := newTestLibrary()
+	f.addSong("3", "t2", 2)
+
+	tests := []struct {
+		keep bool
+		want []string
+	}{
+		{false, []string{"t2"}},
+		{true, []string{"t2", "t2"}},
+	}
+	for _, tt := range tests {
+		playlists, err := collectPlaylists(context.Background(), f, CollectOptions{PlaylistID: "3", KeepDuplicates: tt.keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(playlists) != 1 {
+			t.Fatalf("keep duplicates %v: %d playlists, want 1", tt.keep, len(playlists))
+		}
+		if got := trackIDs(playlists[0]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keep duplicates %v: tracks %v, want %v", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestRecursivePlaylistPath(t *testing.T) {
+	tests := []struct {
+		name string
+		// rows are the playlists of the library as ID, parent ID and name;
+		// the path of the last one is built
+		rows [][3]string
+		want []string
+	}{
+		{
+			name: "top level",
+			rows: [][3]string{{"1", "root", "A"}},
+			want: []string{"A"},
+		},
+		{
+			name: "no parent",
+			rows: [][3]string{{"1", "", "A"}},
+			want: []string{"A"},
+		},
+		{
+			name: "nested",
+			rows: [][3]string{{"1", "root", "F"}, {"2", "1", "G"}, {"3", "2", "A"}},
+			want: []string{"F", "G", "A"},
+		},
+		{
+			name: "dangling parent",
+			rows: [][3]string{{"1", "99", "A"}},
+			want: []string{"A"},
+		},
+		{
+			name: "own parent",
+			rows: [][3]string{{"1", "1", "A"}},
+			want: []string{"A"},
+		},
+		{
+			name: "parents of each other",
+			rows: [][3]string{{"1", "2", "F"}, {"2", "1", "G"}, {"3", "1", "A"}},
+			want: []string{"G", "F", "A"},
+		},
+	}
+
+	for _, tt := range tests {
+		f := &fakeLibrary{}
+		for _, row := range tt.rows {
+			f.addPlaylist(row[0], row[1], row[2], 1, playlistAttributeFolder)
+		}
+		playlist := f.playlists[len(f.playlists)-1]
+
+		got := getRecursivePlaylistPath(context.Background(), f, newNodes(f), playlist, []string{playlist.Name.String()}, nil)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: path %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecursivePlaylistPathDepth(t *testing.T) {
+	tests := []struct {
+		folders int
+		// want is the length of the path and its first element
+		length int
+		first  string
+	}{
+		{maxPlaylistDepth - 2, maxPlaylistDepth - 1, "F1"},
+		{maxPlaylistDepth - 1, maxPlaylistDepth, "F1"},
+		// a folder more, and the outermost is dropped
+		{maxPlaylistDepth, maxPlaylistDepth, "…/F2"},
+		{maxPlaylistDepth + 10, maxPlaylistDepth, "…/F12"},
+	}
+
+	for _, tt := range tests {
+		f := &fakeLibrary{}
+		parentID := "root"
+		for i := 1; i <= tt.folders; i++ {
+			f.addPlaylist(fmt.Sprint(i), parentID, fmt.Sprintf("F%d", i), 1, playlistAttributeFolder)
+			parentID = fmt.Sprint(i)
+		}
+		f.addPlaylist("leaf", parentID, "A", 1, playlistAttributePlaylist)
+		playlist := f.playlists[len(f.playlists)-1]
+
+		path := getRecursivePlaylistPath(context.Background(), f, newNodes(f), playlist, []string{"A"}, nil)
+		if len(path) != tt.length || path[0] != tt.first || path[len(path)-1] != "A" {
+			t.Errorf("%d folders: path of %d starting with %q, want %d starting with %q", tt.folders, len(path), path[0], tt.length, tt.first)
+		}
+	}
+}
+
+func TestCollectTruncatedName(t *testing.T) {
+	f := &fakeLibrary{}
+	parentID := "root"
+	for i := 1; i <= maxPlaylistDepth+5; i++ {
+		f.addPlaylist(fmt.Sprint(i), parentID, fmt.Sprintf("F%d", i), 1, playlistAttributeFolder)
+		parentID = fmt.Sprint(i)
+	}
+	f.addPlaylist("leaf", parentID, "A", 1, playlistAttributePlaylist)
+
+	for _, separator := range []string{"", "/", " > "} {
+		playlists, err := collectPlaylists(context.Background(), f, CollectOptions{NameSeparator: separator})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(playlists) != 1 {
+			t.Fatalf("%d playlists, want 1", len(playlists))
+		}
+		if name := playlists[0].CombinedName; !strings.HasPrefix(name, "…/F7") {
+			t.Errorf("separator %q: name %q doesn't start with …/F7", separator, name)
+		}
+	}
+}
+
+// newNodes returns the playlists of f by ID, as collectLibrary passes them
+// to getRecursivePlaylistPath.
+func newNodes(f *fakeLibrary) map[string]*rekordbox.DjmdPlaylist {
+	nodes := map[string]*rekordbox.DjmdPlaylist{}
+	for _, playlist := range f.playlists {
+		nodes[playlist.ID.String()] = playlist
+	}
+
+	return nodes
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// TrackColor is the color label assigned to a track.
+type TrackColor struct {
+	Name string `json:"name"`
+	// Hex is the color as "#rrggbb"
+	Hex string `json:"hex"`
+}
+
+// color returns the color label of content, or nil if it has none. Colors are
+// cached by ID since there are only a handful of them.
+func (r *resolver) color(ctx context.Context, content *rekordbox.DjmdContent) *TrackColor {
+	id := content.ColorID.String()
+	if unsetID(id) {
+		return nil
+	}
+
+	r.mu.Lock()
+	color, ok := r.colors[id]
+	r.mu.Unlock()
+	if ok {
+		return color
+	}
+
+	row, err := r.client.DjmdColorByID(ctx, content.ColorID)
+	if err != nil {
+		slog.Warn("color not found", "id", id, "content_id", content.ID.String(), "error", err)
+	} else if row != nil {
+		color = &TrackColor{
+			Name: row.Commnt.String(),
+			Hex:  fmt.Sprintf("#%06x", row.ColorCode.Int64Value()&0xffffff),
+		}
+	}
+
+	r.mu.Lock()
+	r.colors[id] = color
+	r.mu.Unlock()
+	return color
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileConfig is the JSON config file, holding settings that are awkward to pass
+// on the command line, such as the Plex token. Command line flags override it.
+type fileConfig struct {
+	PlexURL     string      `json:"plex_url"`
+	PlexToken   string      `json:"plex_token"`
+	OptionsPath string      `json:"options_path"`
+	PathRemaps  []PathRemap `json:"path_remaps"`
+	// ColorMoods maps rekordbox color names to Plex moods, e.g.
+	// {"Red": "Aggressive", "Blue": "Melancholy"}
+	ColorMoods map[string]string `json:"color_moods"`
+	// Extensions and ExcludeExtensions select the file types that are
+	// synced, see SyncOptions
+	Extensions        []string `json:"extensions"`
+	ExcludeExtensions []string `json:"exclude_extensions"`
+	// SummaryTemplate is the description written to synced playlists, see
+	// SyncOptions
+	SummaryTemplate string `json:"summary_template"`
+}
+
+func defaultConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".config", "rekordbox-plexamp-sync", "config.json"), nil
+}
+
+// loadConfig reads the config file at path, or at the default location when
+// path is empty. Only an explicitly given file has to exist.
+func loadConfig(path string) (*fileConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = defaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	config := &fileConfig{}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	return config, nil
+}
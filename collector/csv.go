@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// libraryCSVHeader names the columns written by writeLibraryCSV. Scripts rely
+// on their positions, so new columns only ever go at the end.
+var libraryCSVHeader = []string{"playlist", "track_no", "artist", "title", "album", "bpm", "key", "rating", "path"}
+
+// writeLibraryCSV flattens playlists into one row per playlist entry, with a
+// header row. track_no is the position in the playlist, counting from 1.
+func writeLibraryCSV(w io.Writer, playlists []*Playlist) error {
+	cw := csv.NewWriter(w)
+	cw.Write(libraryCSVHeader)
+
+	for _, pl := range playlists {
+		for i, track := range pl.Tracks {
+			cw.Write([]string{
+				pl.CombinedName,
+				strconv.Itoa(i + 1),
+				track.ArtistName,
+				track.Title,
+				track.AlbumName,
+				strconv.FormatFloat(track.BPM, 'f', -1, 64),
+				track.KeyName,
+				strconv.FormatInt(track.Rating, 10),
+				track.FolderPath,
+			})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
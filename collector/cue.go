@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Kinds of cue points.
+const (
+	cueTypeHot    = "hot_cue"
+	cueTypeMemory = "memory_cue"
+)
+
+// hotCueSlots maps DjmdCue.Kind to the pad a hot cue is assigned to. Kind 0 is
+// a memory cue; 4 is not used for hot cues.
+var hotCueSlots = map[int64]string{
+	1: "A", 2: "B", 3: "C", 5: "D", 6: "E", 7: "F", 8: "G", 9: "H",
+}
+
+// Cue is a hot cue or memory cue of a track.
+type Cue struct {
+	Type string `json:"type"`
+	// Slot is the hot cue pad (A-H), empty for memory cues
+	Slot       string  `json:"slot,omitempty"`
+	PositionMs float64 `json:"position_ms"`
+	// ColorIndex is the entry of rekordbox's cue color palette, 0 for none
+	ColorIndex int64  `json:"color_index"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+// cues returns the cue points of content ordered by position. Lookups are
+// cached since a track can appear in many playlists.
+func (r *resolver) cues(ctx context.Context, content *rekordbox.DjmdContent) []*Cue {
+	if r.noCues {
+		return []*Cue{}
+	}
+
+	r.mu.Lock()
+	cues, ok := r.cueCache[content.ID.String()]
+	r.mu.Unlock()
+	if ok {
+		return cues
+	}
+
+	rows, err := r.client.DjmdCueByContentID(ctx, content.ID)
+	if err != nil {
+		slog.Warn("cues not found", "content_id", content.ID.String(), "error", err)
+	}
+
+	cues = []*Cue{}
+	for _, row := range rows {
+		if row.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		cue := &Cue{
+			Type:       cueTypeMemory,
+			PositionMs: cuePositionMs(row, content.SampleRate.Int64Value()),
+			ColorIndex: row.ColorTableIndex.Int64Value(),
+			Comment:    row.Comment.String(),
+		}
+		if kind := row.Kind.Int64Value(); kind != 0 {
+			cue.Type = cueTypeHot
+			cue.Slot = hotCueSlots[kind]
+		}
+
+		cues = append(cues, cue)
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].PositionMs < cues[j].PositionMs
+	})
+
+	r.mu.Lock()
+	r.cueCache[content.ID.String()] = cues
+	r.mu.Unlock()
+	return cues
+}
+
+// cuePositionMs returns the position of the cue in milliseconds. Older
+// libraries leave InMsec unset and only store the position as a sample offset
+// in InFrame, which is converted using the track's sample rate.
+func cuePositionMs(cue *rekordbox.DjmdCue, sampleRate int64) float64 {
+	if cue.InMsec.Valid() && cue.InMsec.Int64Value() >= 0 {
+		return float64(cue.InMsec.Int64Value())
+	}
+
+	return msFromSamples(cue.InFrame.Int64Value(), sampleRate)
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// exportDiff is what changed between a previous export and the current
+// playlists. Playlists are told apart by their rekordbox ID, so a playlist
+// that kept its ID under a new name counts as renamed.
+type exportDiff struct {
+	Added   []*playlistRef     `json:"added"`
+	Removed []*playlistRef     `json:"removed"`
+	Renamed []*renamedPlaylist `json:"renamed"`
+	// Changed lists the playlists in both exports whose tracks differ
+	Changed []*playlistDiff `json:"changed"`
+}
+
+type playlistRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type renamedPlaylist struct {
+	ID      string `json:"id"`
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
+}
+
+type playlistDiff struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	TracksAdded   []*Track `json:"tracks_added"`
+	TracksRemoved []*Track `json:"tracks_removed"`
+}
+
+// loadExport reads the playlists of a JSON export written by getPlaylists or
+// the CLI.
+func loadExport(path string) ([]*Playlist, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &playlistsEnvelope{}
+	if err := json.Unmarshal(b, env); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if env.SchemaVersion == 0 || env.Playlists == nil {
+		return nil, fmt.Errorf("%s is not a playlists export", path)
+	}
+	if env.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("%s has schema version %d, newer than the supported %d", path, env.SchemaVersion, schemaVersion)
+	}
+
+	return env.Playlists, nil
+}
+
+// playlistID identifies pl across exports: by UUID or rekordbox ID, or by
+// name in exports without the playlist rows.
+func playlistID(pl *Playlist) string {
+	if pl.UUID != "" {
+		return pl.UUID
+	}
+	if pl.DJMdPlaylist != nil && pl.DJMdPlaylist.ID.String() != "" {
+		return pl.DJMdPlaylist.ID.String()
+	}
+
+	return pl.CombinedName
+}
+
+// diffExports compares the playlists of a previous export with the current
+// ones. Everything is listed in the order of the export it comes from.
+func diffExports(old, current []*Playlist) *exportDiff {
+	diff := &exportDiff{
+		Added:   []*playlistRef{},
+		Removed: []*playlistRef{},
+		Renamed: []*renamedPlaylist{},
+		Changed: []*playlistDiff{},
+	}
+
+	previous := make(map[string]*Playlist, len(old))
+	for _, pl := range old {
+		previous[playlistID(pl)] = pl
+	}
+
+	seen := map[string]bool{}
+	for _, pl := range current {
+		id := playlistID(pl)
+		seen[id] = true
+
+		was, ok := previous[id]
+		if !ok {
+			diff.Added = append(diff.Added, &playlistRef{ID: id, Name: pl.CombinedName})
+			continue
+		}
+
+		if was.CombinedName != pl.CombinedName {
+			diff.Renamed = append(diff.Renamed, &renamedPlaylist{ID: id, OldName: was.CombinedName, NewName: pl.CombinedName})
+		}
+
+		added, removed := diffTracks(was.Tracks, pl.Tracks), diffTracks(pl.Tracks, was.Tracks)
+		if len(added) > 0 || len(removed) > 0 {
+			diff.Changed = append(diff.Changed, &playlistDiff{ID: id, Name: pl.CombinedName, TracksAdded: added, TracksRemoved: removed})
+		}
+	}
+
+	for _, pl := range old {
+		if id := playlistID(pl); !seen[id] {
+			diff.Removed = append(diff.Removed, &playlistRef{ID: id, Name: pl.CombinedName})
+		}
+	}
+
+	return diff
+}
+
+// diffTracks returns the tracks of b whose content ID isn't in a.
+func diffTracks(a, b []*Track) []*Track {
+	in := make(map[string]bool, len(a))
+	for _, track := range a {
+		in[track.ContentID] = true
+	}
+
+	diff := []*Track{}
+	for _, track := range b {
+		if !in[track.ContentID] {
+			diff = append(diff, track)
+		}
+	}
+
+	return diff
+}
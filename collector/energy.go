@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Energy levels of Track.Energy. They are rekordbox's phrase analysis moods,
+// which it stores as 1 for high, 2 for mid and 3 for low, turned around so a
+// higher number means more energy.
+const (
+	energyLow  = 1
+	energyMid  = 2
+	energyHigh = 3
+)
+
+// pssiMask is XORed over the PSSI tag from the mood on by rekordbox 6 and
+// later, after adding the number of entries to each byte.
+var pssiMask = []byte{0xcb, 0xe1, 0xee, 0xfa, 0xe5, 0xee, 0xad, 0xee, 0xe9, 0xd2, 0xe9, 0xeb, 0xe1, 0xe9, 0xf3, 0xe8, 0xe9, 0xf4, 0xe1}
+
+// energy returns the energy of content from the phrase analysis in its
+// extended (.EXT) analysis file. The database has no column for it, and
+// tracks analyzed without phrases, or by versions of rekordbox that didn't
+// analyze them, have none, so nil is a normal result.
+func (r *resolver) energy(content *rekordbox.DjmdContent) *int {
+	dataPath := content.AnalysisDataPath.String()
+	if r.analysisDir == "" || !strings.EqualFold(filepath.Ext(dataPath), ".dat") {
+		return nil
+	}
+
+	path := filepath.Join(r.analysisDir, filepath.FromSlash(strings.TrimSuffix(dataPath, filepath.Ext(dataPath))+".EXT"))
+	tag, _, err := readANLZTag(path, "PSSI")
+	if err != nil {
+		slog.Debug("phrase analysis not readable", "content_id", content.ID.String(), "file", path, "error", err)
+		return nil
+	}
+	if tag == nil {
+		return nil
+	}
+
+	energy, err := parsePSSIEnergy(tag)
+	if err != nil {
+		slog.Debug("phrase analysis not readable", "content_id", content.ID.String(), "file", path, "error", err)
+		return nil
+	}
+
+	return &energy
+}
+
+// parsePSSIEnergy reads the mood of a PSSI (song structure) tag, unmasking
+// it if needed.
+func parsePSSIEnergy(tag []byte) (int, error) {
+	if len(tag) < 20 {
+		return 0, fmt.Errorf("truncated PSSI tag")
+	}
+
+	mood := binary.BigEndian.Uint16(tag[18:20])
+	if mood < 1 || mood > 3 {
+		entries := binary.BigEndian.Uint16(tag[16:18])
+		masked := [2]byte{
+			tag[18] ^ (pssiMask[0] + byte(entries)),
+			tag[19] ^ (pssiMask[1] + byte(entries)),
+		}
+		mood = binary.BigEndian.Uint16(masked[:])
+	}
+
+	switch mood {
+	case 1:
+		return energyHigh, nil
+	case 2:
+		return energyMid, nil
+	case 3:
+		return energyLow, nil
+	}
+
+	return 0, fmt.Errorf("unknown phrase mood %d", mood)
+}
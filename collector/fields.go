@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Track sub-objects that can be selected with CollectOptions.Fields. Each
+// costs a query or file read of its own, which is skipped when it isn't
+// selected.
+const (
+	fieldCues     = "cues"
+	fieldBeatGrid = "beat_grid"
+	fieldEnergy   = "energy"
+	fieldMyTags   = "my_tags"
+	fieldHistory  = "history"
+	fieldRelated  = "related_tracks"
+)
+
+var trackFields = []string{fieldCues, fieldBeatGrid, fieldEnergy, fieldMyTags, fieldHistory, fieldRelated}
+
+// fieldSet is the selected track sub-objects. A nil set selects all of them.
+type fieldSet map[string]bool
+
+// has reports whether field is selected.
+func (fields fieldSet) has(field string) bool {
+	return fields == nil || fields[field]
+}
+
+// fields parses Fields. Names are compared ignoring case and underscores, so
+// "beatgrid" selects beat_grid.
+func (opts CollectOptions) fields() (fieldSet, error) {
+	if len(opts.Fields) == 0 {
+		return nil, nil
+	}
+
+	fields := fieldSet{}
+	for _, name := range opts.Fields {
+		field, ok := lookupField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, expected %s", name, strings.Join(trackFields, ", "))
+		}
+		fields[field] = true
+	}
+
+	return fields, nil
+}
+
+func lookupField(name string) (string, bool) {
+	normalize := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), "_", "")
+	}
+	for _, field := range trackFields {
+		if normalize(name) == normalize(field) {
+			return field, true
+		}
+	}
+
+	return "", false
+}
+
+// clear empties the sub-objects of track that aren't selected, for sources
+// that read them all at once anyway.
+func (fields fieldSet) clear(track *Track) {
+	if !fields.has(fieldCues) {
+		track.Cues = []*Cue{}
+	}
+	if !fields.has(fieldBeatGrid) {
+		track.BeatGrid = []*BeatGridEntry{}
+	}
+	if !fields.has(fieldEnergy) {
+		track.Energy = nil
+	}
+	if !fields.has(fieldMyTags) {
+		track.MyTags = []*MyTag{}
+	}
+	if !fields.has(fieldHistory) {
+		track.PlayCount, track.LastPlayed = 0, nil
+	}
+	if !fields.has(fieldRelated) {
+		track.RelatedTracks = []*RelatedTrack{}
+	}
+}
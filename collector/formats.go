@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// defaultExtensions are the audio formats Plex plays, used when
+// SyncOptions.Extensions is empty.
+var defaultExtensions = []string{".mp3", ".m4a", ".aac", ".flac", ".alac", ".wav", ".aif", ".aiff", ".ogg", ".opus", ".wma"}
+
+// supportsFile reports whether the track at path has a file extension that
+// is synced: one of Extensions (or the defaults) and none of
+// ExcludeExtensions, compared case-insensitively.
+func (opts SyncOptions) supportsFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	for _, excluded := range opts.ExcludeExtensions {
+		if ext == normalizeExtension(excluded) {
+			return false
+		}
+	}
+
+	allowed := opts.Extensions
+	if len(allowed) == 0 {
+		allowed = defaultExtensions
+	}
+	for _, extension := range allowed {
+		if ext == normalizeExtension(extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeExtension accepts extensions with or without the leading dot.
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	return ext
+}
@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// envCheck is the outcome of one step of checkEnvironment.
+type envCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// envReport is what checkEnvironment returns. OK is set if every check
+// passed.
+type envReport struct {
+	OK     bool        `json:"ok"`
+	Checks []*envCheck `json:"checks"`
+}
+
+func (report *envReport) add(name string, err error, message string) bool {
+	check := &envCheck{Name: name, OK: err == nil, Message: message}
+	if err != nil {
+		check.Message = err.Error()
+		report.OK = false
+	}
+	report.Checks = append(report.Checks, check)
+
+	return err == nil
+}
+
+func (report *envReport) skip(name, reason string) {
+	report.Checks = append(report.Checks, &envCheck{Name: name, Message: "skipped: " + reason})
+	report.OK = false
+}
+
+// plexCheckTimeout bounds the Plex checks, so an unreachable server fails
+// the check rather than hanging it.
+const plexCheckTimeout = 10 * time.Second
+
+// checkEnvironmentReport verifies, without reading any playlists, that the
+// options.json at optionsPath (located as by resolveOptionsPath if empty) can
+// be read, that the database it names exists, opens and answers a query and,
+// if plexURL is set, that the Plex server is reachable and accepts token.
+// Checks that depend on a failed one are reported as skipped.
+func checkEnvironmentReport(ctx context.Context, optionsPath, plexURL, token string) *envReport {
+	report := &envReport{OK: true, Checks: []*envCheck{}}
+	checkDatabase(ctx, report, optionsPath)
+
+	if plexURL == "" {
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, plexCheckTimeout)
+	defer cancel()
+
+	plex := newPlexClient(plexURL, token)
+	machineID, err := plex.machineIdentifier(ctx)
+	if !report.add("plex_server", err, fmt.Sprintf("reached server %s", machineID)) {
+		report.skip("plex_auth", "Plex server not reachable")
+		return report
+	}
+
+	// /identity answers without a token, the library doesn't
+	sections, err := plex.musicSections(ctx)
+	report.add("plex_auth", err, fmt.Sprintf("token accepted, %d music sections", len(sections)))
+
+	return report
+}
+
+func checkDatabase(ctx context.Context, report *envReport, optionsPath string) {
+	optionsPath, err := resolveOptionsPath(optionsPath)
+	if err == nil {
+		_, err = readOptions(optionsPath)
+	}
+	if !report.add("options", err, fmt.Sprintf("read %s", optionsPath)) {
+		report.skip("database_file", "options.json not readable")
+		report.skip("database_open", "options.json not readable")
+		report.skip("database_query", "options.json not readable")
+		return
+	}
+
+	dbPath, err := validateOptions(optionsPath)
+	if !report.add("database_file", err, fmt.Sprintf("found %s", dbPath)) {
+		report.skip("database_open", "database not found")
+		report.skip("database_query", "database not found")
+		return
+	}
+
+	lib, err := openClient(optionsPath, false)
+	if !report.add("database_open", err, "opened database") {
+		report.skip("database_query", "database could not be opened")
+		return
+	}
+	defer lib.Close()
+
+	// unlike pingDatabase, a locked database fails here, since every
+	// query of a sync would have to wait for it
+	queryCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	properties, err := lib.AllDjmdProperty(queryCtx)
+	message := "queried database"
+	if err == nil && len(properties) > 0 {
+		message = fmt.Sprintf("queried database version %s", properties[0].DBVersion.String())
+	}
+	report.add("database_query", err, message)
+}
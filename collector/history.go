@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// playHistory is how often and when a track was last played, according to
+// rekordbox's history playlists.
+type playHistory struct {
+	count      int
+	lastPlayed time.Time
+}
+
+// loadHistory aggregates every history entry by content ID. Each entry of a
+// history playlist is one play. Libraries with history recording disabled
+// simply have no entries.
+func (r *resolver) loadHistory(ctx context.Context) error {
+	histories, err := r.client.AllDjmdHistory(ctx)
+	if err != nil {
+		return fmt.Errorf("loading history: %w", err)
+	}
+
+	// history playlists that were deleted don't count
+	deleted := map[string]bool{}
+	for _, history := range histories {
+		if history.RbLocalDeleted.Int64Value() != 0 {
+			deleted[history.ID.String()] = true
+		}
+	}
+
+	songs, err := r.client.AllDjmdSongHistory(ctx)
+	if err != nil {
+		return fmt.Errorf("loading history entries: %w", err)
+	}
+
+	r.history = map[string]*playHistory{}
+	for _, song := range songs {
+		if song.RbLocalDeleted.Int64Value() != 0 || deleted[song.HistoryID.String()] {
+			continue
+		}
+
+		h, ok := r.history[song.ContentID.String()]
+		if !ok {
+			h = &playHistory{}
+			r.history[song.ContentID.String()] = h
+		}
+
+		h.count++
+		// entries are created as the track is played
+		if playedAt := song.CreatedAt.Time(); playedAt.After(h.lastPlayed) {
+			h.lastPlayed = playedAt
+		}
+	}
+
+	return nil
+}
+
+// playCount returns how often the content with contentID was played, and when
+// it was last played, nil if never.
+func (r *resolver) playCount(contentID string) (int, *time.Time) {
+	h, ok := r.history[contentID]
+	if !ok {
+		return 0, nil
+	}
+
+	if h.lastPlayed.IsZero() {
+		return h.count, nil
+	}
+
+	lastPlayed := h.lastPlayed
+	return h.count, &lastPlayed
+}
@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The functions here back the exports of the shared library: they take the
+// options a host passes as JSON and return the JSON it gets back, leaving the
+// C conversions to the exports.
+
+// ErrorStatus returns the Status code a host is given for err.
+func ErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return StatusOK
+	case errors.Is(err, ErrUnavailable):
+		return StatusUnavailable
+	default:
+		return StatusFailed
+	}
+}
+
+// ParseCollectOptions decodes the JSON CollectOptions object a host passes
+// in. An empty string selects the defaults.
+func ParseCollectOptions(raw string) (CollectOptions, error) {
+	opts := CollectOptions{}
+	if raw == "" {
+		return opts, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+		return opts, fmt.Errorf("invalid options: %w", err)
+	}
+
+	return opts, opts.compileNameFilters()
+}
+
+// ParsePlexSyncOptions decodes the JSON PlexSyncOptions object a host passes
+// in. An empty string selects the defaults.
+func ParsePlexSyncOptions(raw string) (*PlexSyncOptions, error) {
+	opts := DefaultPlexSyncOptions()
+
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), opts); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	if err := opts.compileNameFilters(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// PlaylistsJSON returns the envelope of getPlaylists, from the output cache
+// when it holds an answer fresh enough for opts.
+func PlaylistsJSON(ctx context.Context, opts CollectOptions) ([]byte, error) {
+	cache := newOutputCache("playlists", opts)
+	if b, ok := cachedOutput(cache, opts); ok {
+		return b, nil
+	}
+
+	opts.stats = newRunStats()
+	stop := captureWarnings()
+	playlists, err := Collect(ctx, opts)
+	warnings := stop()
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := newPlaylistsEnvelope(playlists, opts.stats)
+	envelope.Warnings = warnings
+	return marshalCached(cache, envelope)
+}
+
+// PlaylistTreeJSON is PlaylistsJSON with the envelope of getPlaylistTree.
+func PlaylistTreeJSON(ctx context.Context, opts CollectOptions) ([]byte, error) {
+	cache := newOutputCache("tree", opts)
+	if b, ok := cachedOutput(cache, opts); ok {
+		return b, nil
+	}
+
+	opts.stats = newRunStats()
+	stop := captureWarnings()
+	tree, err := CollectTree(ctx, opts)
+	warnings := stop()
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := newPlaylistTreeEnvelope(tree, opts.stats)
+	envelope.Warnings = warnings
+	return marshalCached(cache, envelope)
+}
+
+// PlaylistNamesJSON returns the listing of getPlaylistNames.
+func PlaylistNamesJSON(ctx context.Context, opts CollectOptions) ([]byte, error) {
+	listings, err := ListPlaylists(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(listings)
+}
+
+// OrphanTracksJSON returns the tracks of getOrphanTracks.
+func OrphanTracksJSON(ctx context.Context, opts CollectOptions) ([]byte, error) {
+	tracks, err := OrphanTracks(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(tracks)
+}
+
+// PlaylistJSON returns the playlist with the given rekordbox ID.
+func PlaylistJSON(ctx context.Context, id string) ([]byte, error) {
+	playlists, err := Collect(ctx, CollectOptions{PlaylistID: id})
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return nil, fmt.Errorf("no playlist with ID %s", id)
+	}
+
+	return json.Marshal(playlists[0])
+}
+
+// UnmatchedReportJSON returns every playlist entry whose content row or file
+// on disk is missing.
+func UnmatchedReportJSON(ctx context.Context) ([]byte, error) {
+	opts := CollectOptions{}
+	var c *collection
+	err := withLibrary(&opts, func(client libraryClient) (err error) {
+		c, err = collect(ctx, client, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(c.Unresolved)
+}
+
+// PlexSyncJSON returns the summary of SyncToPlex, or with dryRun its plan.
+func PlexSyncJSON(ctx context.Context, serverURL, token string, opts *PlexSyncOptions, dryRun bool) ([]byte, error) {
+	opts.stats = newRunStats()
+	stop := captureWarnings()
+	result, err := SyncToPlex(ctx, serverURL, token, opts, dryRun)
+	warnings := stop()
+	if err != nil {
+		return nil, err
+	}
+
+	switch result := result.(type) {
+	case *syncSummary:
+		result.Warnings = warnings
+	case *syncPlan:
+		result.Warnings = warnings
+	}
+	return json.Marshal(result)
+}
+
+// InfoJSON returns the build and library description of getInfo.
+func InfoJSON(ctx context.Context) ([]byte, error) {
+	info := newBuildInfo()
+
+	optionsPath, err := resolveOptionsPath("")
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return json.Marshal(info)
+	}
+	info.OptionsPath = optionsPath
+
+	client, err := openClient(optionsPath, false)
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return json.Marshal(info)
+	}
+	defer client.Close()
+
+	properties, err := client.AllDjmdProperty(ctx)
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+	} else if len(properties) > 0 {
+		info.DBVersion = properties[0].DBVersion.String()
+	}
+
+	return json.Marshal(info)
+}
+
+// EnvironmentJSON returns the report of checkEnvironment.
+func EnvironmentJSON(ctx context.Context, optionsPath, plexURL, token string) ([]byte, error) {
+	return json.Marshal(checkEnvironmentReport(ctx, optionsPath, plexURL, token))
+}
+
+// OptionsJSON returns the description of the options.json at optionsPath of
+// getOptionsInfo.
+func OptionsJSON(optionsPath string) ([]byte, error) {
+	return json.Marshal(describeOptions(optionsPath))
+}
+
+// marshalCached marshals v, also storing the result in cache.
+func marshalCached(cache *outputCache, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	cache.save(b)
+
+	return b, nil
+}
+
+// cachedOutput returns the output cache holds, unless opts asks for a fresh
+// one.
+func cachedOutput(cache *outputCache, opts CollectOptions) ([]byte, bool) {
+	if opts.NoCache {
+		return nil, false
+	}
+
+	return cache.load()
+}
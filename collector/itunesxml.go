@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// The iTunes Library XML format, a property list that Apple Music, iTunes
+// and much other software can import. It has no schema; the keys are the
+// ones iTunes writes.
+
+const itunesHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// plistWriter writes indented property list elements, keeping the first error
+// so callers only check it once at the end.
+type plistWriter struct {
+	w     io.Writer
+	depth int
+	err   error
+}
+
+func (p *plistWriter) line(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "%s"+format+"\n", append([]interface{}{strings.Repeat("\t", p.depth)}, args...)...)
+}
+
+func (p *plistWriter) open(tag string) {
+	p.line("<%s>", tag)
+	p.depth++
+}
+
+func (p *plistWriter) close(tag string) {
+	p.depth--
+	p.line("</%s>", tag)
+}
+
+func (p *plistWriter) key(name string) {
+	p.line("<key>%s</key>", escapeXML(name))
+}
+
+func (p *plistWriter) integer(name string, value int64) {
+	p.key(name)
+	p.line("<integer>%d</integer>", value)
+}
+
+// text writes a string value, leaving it out if empty as iTunes does.
+func (p *plistWriter) text(name, value string) {
+	if value == "" {
+		return
+	}
+	p.key(name)
+	p.line("<string>%s</string>", escapeXML(value))
+}
+
+func (p *plistWriter) date(name string, value time.Time) {
+	p.key(name)
+	p.line("<date>%s</date>", value.UTC().Format(time.RFC3339))
+}
+
+func (p *plistWriter) boolean(name string) {
+	p.key(name)
+	p.line("<true/>")
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeITunesXML writes the playlists of tree and the tracks they contain as
+// an iTunes Library XML file. Tracks are numbered in the order they are first
+// found; folders are kept, linked through their persistent IDs.
+func writeITunesXML(w io.Writer, tree []*PlaylistNode) error {
+	if _, err := io.WriteString(w, itunesHeader); err != nil {
+		return err
+	}
+
+	p := &plistWriter{w: w}
+	p.open("dict")
+	p.integer("Major Version", 1)
+	p.integer("Minor Version", 1)
+	p.text("Application Version", "rekordbox-plexamp-sync "+version)
+	p.date("Date", time.Now())
+
+	trackIDs := map[string]int64{}
+	p.key("Tracks")
+	p.open("dict")
+	var addTracks func(nodes []*PlaylistNode)
+	addTracks = func(nodes []*PlaylistNode) {
+		for _, node := range nodes {
+			for _, track := range node.Tracks {
+				if _, ok := trackIDs[track.ContentID]; ok {
+					continue
+				}
+				id := int64(len(trackIDs) + 1)
+				trackIDs[track.ContentID] = id
+				writeITunesTrack(p, id, track)
+			}
+			addTracks(node.Children)
+		}
+	}
+	addTracks(tree)
+	p.close("dict")
+
+	p.key("Playlists")
+	p.open("array")
+	playlistID := int64(0)
+	var addPlaylists func(nodes []*PlaylistNode, parent string)
+	addPlaylists = func(nodes []*PlaylistNode, parent string) {
+		for _, node := range nodes {
+			playlistID++
+			persistentID := itunesPersistentID("playlist:" + node.ID)
+
+			p.open("dict")
+			p.text("Name", node.Name)
+			p.integer("Playlist ID", playlistID)
+			p.text("Playlist Persistent ID", persistentID)
+			p.text("Parent Persistent ID", parent)
+			p.boolean("All Items")
+			if node.Kind == nodeKindFolder {
+				p.boolean("Folder")
+			} else {
+				p.key("Playlist Items")
+				p.open("array")
+				for _, track := range node.Tracks {
+					p.open("dict")
+					p.integer("Track ID", trackIDs[track.ContentID])
+					p.close("dict")
+				}
+				p.close("array")
+			}
+			p.close("dict")
+
+			addPlaylists(node.Children, persistentID)
+		}
+	}
+	addPlaylists(tree, "")
+	p.close("array")
+
+	p.close("dict")
+	p.line("</plist>")
+
+	return p.err
+}
+
+func writeITunesTrack(p *plistWriter, id int64, track *Track) {
+	p.key(fmt.Sprint(id))
+	p.open("dict")
+	p.integer("Track ID", id)
+	p.text("Name", track.Title)
+	p.text("Artist", track.ArtistName)
+	p.text("Album", track.AlbumName)
+	p.text("Genre", track.GenreName)
+	p.text("Kind", track.FileType+" audio file")
+	if track.DurationMs > 0 {
+		p.integer("Total Time", track.DurationMs)
+	}
+	if track.BPM > 0 {
+		p.integer("BPM", int64(math.Round(track.BPM)))
+	}
+	if track.BitRate > 0 {
+		p.integer("Bit Rate", track.BitRate)
+	}
+	if track.SampleRate > 0 {
+		p.integer("Sample Rate", track.SampleRate)
+	}
+	if track.PlayCount > 0 {
+		p.integer("Play Count", int64(track.PlayCount))
+	}
+	if track.LastPlayed != nil {
+		p.date("Play Date UTC", *track.LastPlayed)
+	}
+	if track.Rating > 0 {
+		p.integer("Rating", starsToITunesRating(track.Rating))
+	}
+	if track.DateAdded != nil {
+		p.date("Date Added", *track.DateAdded)
+	}
+	p.text("Comments", track.Comment)
+	p.text("Persistent ID", itunesPersistentID("track:"+track.ContentID))
+	p.text("Track Type", "File")
+	p.text("Location", fileURL(track.FolderPath))
+	p.close("dict")
+}
+
+// itunesPersistentID derives the 16 hex digit persistent ID iTunes expects
+// from a rekordbox ID, prefixed with its kind, so the same item gets the same
+// ID on every export.
+func itunesPersistentID(id string) string {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("%016X", h.Sum64())
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// PlaylistListing is a playlist named and counted without resolving its
+// tracks, for hosts that only need to offer a selection.
+type PlaylistListing struct {
+	ID           string `json:"id"`
+	ParentID     string `json:"parent_id"`
+	CombinedName string `json:"combined_name"`
+	// TrackCount is the number of entries in the playlist, including any
+	// whose track has since been deleted, or null for smart playlists,
+	// whose tracks are only known by evaluating their rules
+	TrackCount *int `json:"track_count"`
+}
+
+// listPlaylists lists the playlists opts selects, ordered by name. It reads
+// only the playlist and playlist entry tables, with one query each however
+// many playlists there are, instead of every track's row and analysis.
+func listPlaylists(ctx context.Context, client libraryClient, opts CollectOptions) ([]*PlaylistListing, error) {
+	rows, err := client.AllDjmdPlaylist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing playlists: %w", err)
+	}
+	songs, err := client.AllDjmdSongPlaylist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing playlist entries: %w", err)
+	}
+
+	counts := map[string]int{}
+	for _, song := range songs {
+		counts[song.PlaylistID.String()]++
+	}
+
+	nodes := make(map[string]*rekordbox.DjmdPlaylist, len(rows))
+	for _, row := range rows {
+		nodes[row.ID.String()] = row
+	}
+
+	listings := []*PlaylistListing{}
+	for _, row := range rows {
+		if row.Attribute.Int64Value() == playlistAttributeFolder {
+			continue
+		}
+
+		path := getRecursivePlaylistPath(ctx, client, nodes, row, []string{row.Name.String()}, nil)
+		listing := &PlaylistListing{
+			ID:           row.ID.String(),
+			ParentID:     row.ParentID.String(),
+			CombinedName: strings.Join(path, opts.nameSeparator()),
+		}
+		if !opts.includes(listing.CombinedName) {
+			continue
+		}
+		if row.Attribute.Int64Value() != playlistAttributeSmart {
+			count := counts[listing.ID]
+			listing.TrackCount = &count
+		}
+		listings = append(listings, listing)
+	}
+	sortListings(listings)
+
+	return listings, nil
+}
+
+func sortListings(listings []*PlaylistListing) {
+	sort.Slice(listings, func(i, j int) bool {
+		a, b := listings[i], listings[j]
+		if a.CombinedName != b.CombinedName {
+			return a.CombinedName < b.CombinedName
+		}
+
+		return lessID(a.ID, b.ID)
+	})
+}
+
+func (s *xmlSource) list(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error) {
+	lib, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	listings := []*PlaylistListing{}
+	for _, row := range lib.rows {
+		if row.Attribute.Int64Value() == playlistAttributeFolder {
+			continue
+		}
+
+		count := len(lib.entries[row.ID.String()])
+		listing := &PlaylistListing{
+			ID:           row.ID.String(),
+			ParentID:     row.ParentID.String(),
+			CombinedName: strings.Join(lib.paths[row.ID.String()], opts.nameSeparator()),
+			TrackCount:   &count,
+		}
+		if opts.includes(listing.CombinedName) {
+			listings = append(listings, listing)
+		}
+	}
+	sortListings(listings)
+
+	return listings, nil
+}
+
+// list prefixes names and IDs with the library name, as tree does.
+func (m *multiSource) list(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error) {
+	all := []*PlaylistListing{}
+	err := m.each(opts, func(lib *namedSource, opts CollectOptions) error {
+		listings, err := lib.src.list(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, listing := range listings {
+			listing.ID = lib.name + ":" + listing.ID
+			listing.ParentID = lib.name + ":" + listing.ParentID
+			listing.CombinedName = lib.name + opts.nameSeparator() + listing.CombinedName
+		}
+		all = append(all, listings...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
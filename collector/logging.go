@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logLevel is shared by every handler configureLogging installs, so the level
+// can be changed without rebuilding the logger.
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	slog.SetDefault(slog.New(&captureHandler{next: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})}))
+}
+
+// Formats of the log output.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// configureLogging sends log records at level and above to w, as text or,
+// with format "json", one JSON object per line, such as {"time": "...",
+// "level": "WARN", "message": "track content not found", "playlist": "...",
+// "content_id": "..."}.
+func configureLogging(w io.Writer, level, format string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case "", logFormatText:
+		handler = slog.NewTextHandler(w, options)
+	case logFormatJSON:
+		options.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.MessageKey {
+				a.Key = "message"
+			}
+			return a
+		}
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+
+	logLevel.Set(lvl)
+	slog.SetDefault(slog.New(&captureHandler{next: handler}))
+	return nil
+}
+
+// logFile is the file RedirectLogs last opened, closed when replaced.
+var (
+	logFileMu sync.Mutex
+	logFile   *os.File
+)
+
+// RedirectLogs sends log records at level and above to the file at path, or
+// back to stderr if path is empty. It is safe to call concurrently with
+// itself and with logging.
+func RedirectLogs(path, level string) error {
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+
+	if _, err := parseLogLevel(level); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
+			return err
+		}
+		w = f
+	}
+
+	if err := configureLogging(w, level, logFormatText); err != nil {
+		return err
+	}
+
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
@@ -0,0 +1,169 @@
+package collector
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// uniqueNames hands out file names, appending a counter to names that were
+// already handed out so playlists with the same combined name don't overwrite
+// each other.
+type uniqueNames map[string]int
+
+func (u uniqueNames) next(name string) string {
+	key := strings.ToLower(name)
+	u[key]++
+	if n := u[key]; n > 1 {
+		return fmt.Sprintf("%s (%d)", name, n)
+	}
+
+	return name
+}
+
+// maxFilenameLength caps sanitized names in bytes, leaving room within the
+// usual 255 byte limit for the counter of uniqueNames and the extension.
+const maxFilenameLength = 200
+
+// windowsReservedNames are device names Windows refuses as file names, with
+// or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilename turns name into a file name that goos accepts: characters
+// it doesn't allow are replaced, and names longer than maxFilenameLength are
+// cut short and suffixed with a hash of the full name, so names that only
+// differ after the cut stay distinct.
+func sanitizeFilename(name, goos string) string {
+	illegal := "/"
+	switch goos {
+	case "windows":
+		illegal = `/\:*?"<>|`
+	case "darwin":
+		illegal = "/:"
+	}
+
+	sanitized := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(illegal, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if len(sanitized) > maxFilenameLength {
+		sum := sha1.Sum([]byte(name))
+		suffix := "-" + hex.EncodeToString(sum[:4])
+
+		cut := maxFilenameLength - len(suffix)
+		for cut > 0 && !utf8.RuneStart(sanitized[cut]) {
+			cut--
+		}
+		sanitized = sanitized[:cut] + suffix
+	}
+
+	// Windows drops trailing dots and spaces, and leading ones are hidden or
+	// awkward everywhere
+	sanitized = strings.Trim(sanitized, " .")
+	if sanitized == "" {
+		return "_"
+	}
+
+	if goos == "windows" {
+		base, _, _ := strings.Cut(sanitized, ".")
+		if windowsReservedNames[strings.ToUpper(base)] {
+			sanitized = "_" + sanitized
+		}
+	}
+
+	return sanitized
+}
+
+// writeM3U8 writes playlist as an extended M3U playlist into dir, named after
+// its combined name, and returns the path of the written file. The file is
+// UTF-8 without a byte order mark, as the m3u8 format expects. Track paths are
+// rewritten with remaps, so the playlist can be read where the files are
+// mounted elsewhere.
+func writeM3U8(playlist *Playlist, dir string, names uniqueNames, remaps pathRemaps) (string, error) {
+	path := filepath.Join(dir, names.next(sanitizeFilename(playlist.CombinedName, runtime.GOOS))+".m3u8")
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintf(w, "#PLAYLIST:%s\n", oneLine(playlist.CombinedName))
+
+	for _, track := range playlist.Tracks {
+		fmt.Fprintln(w, extinf(track))
+		fmt.Fprintln(w, remaps.apply(track.FolderPath))
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	return path, f.Close()
+}
+
+// extinf returns the #EXTINF line of track: its duration in whole seconds,
+// -1 if unknown as the format has it, and "Artist - Title" with the artist
+// as exported in ArtistName, including any featured artists rekordbox keeps
+// in it. Without an artist only the title is given, and without a title the
+// file name, so strict players never see an empty field.
+func extinf(track *Track) string {
+	seconds := int64(-1)
+	switch {
+	case track.DurationMs > 0:
+		seconds = (track.DurationMs + 500) / 1000
+	case track.Length > 0:
+		seconds = track.Length
+	}
+
+	title := oneLine(track.Title)
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(track.FolderPath), filepath.Ext(track.FolderPath))
+	}
+	if artist := oneLine(track.ArtistName); artist != "" {
+		title = artist + " - " + title
+	}
+
+	return fmt.Sprintf("#EXTINF:%d,%s", seconds, title)
+}
+
+// oneLine trims s and replaces its line breaks, which would end the line
+// they are written on.
+func oneLine(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s))
+}
+
+// writeM3U8Playlists writes one .m3u8 file per playlist into dir.
+func writeM3U8Playlists(playlists []*Playlist, dir string, remaps pathRemaps) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	names := uniqueNames{}
+	paths := []string{}
+	for _, playlist := range playlists {
+		path, err := writeM3U8(playlist, dir, names, remaps)
+		if err != nil {
+			return nil, fmt.Errorf("writing playlist %s: %w", playlist.CombinedName, err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// plexIDMapping remembers which Plex object each rekordbox playlist was synced
+// to, keyed by sync target and then by rekordbox playlist UUID, or ID for
+// playlists without one. Both survive renames on both sides, so a renamed
+// playlist is updated rather than duplicated; UUIDs also aren't reused, so a
+// new playlist can't inherit the Plex object of a deleted one.
+type plexIDMapping struct {
+	Targets map[string]map[string]string `json:"targets"`
+}
+
+func defaultMappingPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".config", "rekordbox-plexamp-sync", "plex-ids.json"), nil
+}
+
+// loadMapping reads the mapping file at path. A missing file yields an empty
+// mapping.
+func loadMapping(path string) (*plexIDMapping, error) {
+	mapping := &plexIDMapping{}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, mapping); err != nil {
+			return nil, err
+		}
+	}
+
+	if mapping.Targets == nil {
+		mapping.Targets = map[string]map[string]string{}
+	}
+
+	return mapping, nil
+}
+
+func saveMapping(path string, mapping *plexIDMapping) error {
+	b, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// like the state file, replace it atomically
+	return writeFileAtomic(path, b)
+}
+
+// ids returns the rekordbox ID to Plex rating key map of target, creating it if
+// needed.
+func (m *plexIDMapping) ids(target string) map[string]string {
+	if target == "" {
+		target = targetPlaylist
+	}
+
+	ids, ok := m.Targets[target]
+	if !ok {
+		ids = map[string]string{}
+		m.Targets[target] = ids
+	}
+
+	return ids
+}
+
+// record updates the mapping with the outcome of a sync: every playlist that
+// now exists in Plex is remembered, and deleted ones are forgotten.
+func (m *plexIDMapping) record(target string, plan *syncPlan) {
+	ids := m.ids(target)
+
+	for _, pp := range plan.Playlists {
+		if pp.Key != "" && pp.PlexID != "" {
+			ids[pp.Key] = pp.PlexID
+			if pp.Key != pp.RekordboxID {
+				// the entry of an older mapping file, now keyed by UUID
+				delete(ids, pp.RekordboxID)
+			}
+		}
+	}
+
+	for _, pruned := range plan.Prune {
+		if pruned.Error != "" {
+			continue
+		}
+		for id, plexID := range ids {
+			if plexID == pruned.PlexID {
+				delete(ids, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+var errNoPlexMatch = errors.New("no matching Plex track")
+
+func isNoMatch(err error) bool {
+	return errors.Is(err, errNoPlexMatch)
+}
+
+// How a rekordbox track was matched to its Plex item.
+const (
+	matchMethodPath     = "path"
+	matchMethodISRC     = "isrc"
+	matchMethodMetadata = "metadata"
+	matchMethodName     = "name"
+)
+
+// PathRemap rewrites a rekordbox path prefix into the prefix under which Plex
+// indexed the same files, e.g. /Users/me/Music -> /data/music.
+type PathRemap struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (r PathRemap) apply(path string) (string, bool) {
+	if r.From == "" || !strings.HasPrefix(path, r.From) {
+		return path, false
+	}
+
+	rest := path[len(r.From):]
+	// only remap on a path component boundary
+	if rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(r.From, "/") {
+		return path, false
+	}
+
+	return r.To + rest, true
+}
+
+// pathRemaps is an ordered list of rewrite rules. The rule with the longest
+// matching prefix wins, ties going to the earlier rule, so /Users/me/Music/Sets
+// can be remapped apart from the rest of /Users/me/Music.
+type pathRemaps []PathRemap
+
+// apply rewrites path with the best matching rule, returning it unchanged if
+// none matches.
+func (remaps pathRemaps) apply(path string) string {
+	best := -1
+	for i, remap := range remaps {
+		if _, ok := remap.apply(path); ok && (best < 0 || len(remap.From) > len(remaps[best].From)) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return path
+	}
+
+	remapped, _ := remaps[best].apply(path)
+	return remapped
+}
+
+// plexTrackIndex holds every track of the server's music sections, keyed the
+// ways we look them up when matching rekordbox content. Where tracks of
+// several sections share a key, the one of the section listed first wins.
+type plexTrackIndex struct {
+	byPath     map[string]*plexMetadata
+	byFilename map[string]*plexMetadata
+	byTitle    map[string]*plexMetadata
+	byISRC     map[string]*plexMetadata
+	byKey      map[string]*plexMetadata
+	// sections maps rating keys to the key of their library section
+	sections map[string]string
+	// sectionTitles maps section keys to their titles
+	sectionTitles map[string]string
+	// sectionKeys are the keys of the sections indexed, in the order they
+	// are tried, so matching never has to list the sections again
+	sectionKeys []string
+}
+
+// setOnce adds track to m under key unless a track already has it.
+func setOnce(m map[string]*plexMetadata, key string, track *plexMetadata) {
+	if _, ok := m[key]; !ok {
+		m[key] = track
+	}
+}
+
+// inSection reports whether track belongs to the section with key
+// sectionKey, with an empty key matching any section.
+func (index *plexTrackIndex) inSection(track *plexMetadata, sectionKey string) bool {
+	return sectionKey == "" || index.sections[track.RatingKey] == sectionKey
+}
+
+func buildPlexTrackIndex(ctx context.Context, plex *plexClient) (*plexTrackIndex, error) {
+	index := &plexTrackIndex{
+		byPath:     map[string]*plexMetadata{},
+		byFilename: map[string]*plexMetadata{},
+		byTitle:    map[string]*plexMetadata{},
+		byISRC:     map[string]*plexMetadata{},
+		byKey:      map[string]*plexMetadata{},
+		sections:   map[string]string{},
+
+		sectionTitles: map[string]string{},
+	}
+
+	sections, err := plex.musicSections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, section := range sections {
+		tracks, err := plex.sectionTracks(ctx, section.Key)
+		if err != nil {
+			return nil, fmt.Errorf("listing tracks of section %s: %w", section.Title, err)
+		}
+
+		index.sectionTitles[section.Key] = section.Title
+		index.sectionKeys = append(index.sectionKeys, section.Key)
+		for _, track := range tracks {
+			index.byKey[track.RatingKey] = track
+			index.sections[track.RatingKey] = section.Key
+			for _, media := range track.Media {
+				for _, part := range media.Part {
+					setOnce(index.byPath, part.File, track)
+					setOnce(index.byFilename, filepath.Base(part.File), track)
+				}
+			}
+
+			if track.Title != "" {
+				setOnce(index.byTitle, strings.ToLower(track.Title), track)
+			}
+			for _, guid := range track.Guid {
+				if isrc, ok := strings.CutPrefix(guid.ID, "isrc://"); ok {
+					setOnce(index.byISRC, normalizeISRC(isrc), track)
+				}
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// matchByName finds the Plex track for content in the section with key
+// sectionKey (any if empty) by file name, falling back to title. It is the
+// last resort when the full path cannot be matched.
+func (index *plexTrackIndex) matchByName(content *rekordbox.DjmdContent, sectionKey string) *plexMetadata {
+	if track, ok := index.byFilename[content.FileNameL.String()]; ok && index.inSection(track, sectionKey) {
+		return track
+	}
+
+	if title := content.Title.String(); title != "" {
+		if track, ok := index.byTitle[strings.ToLower(title)]; ok && index.inSection(track, sectionKey) {
+			return track
+		}
+	}
+
+	return nil
+}
+
+// trackIndex returns the index of all Plex music tracks, building it on first
+// use.
+func (p *plexClient) trackIndex(ctx context.Context) (*plexTrackIndex, error) {
+	p.tracksMu.Lock()
+	defer p.tracksMu.Unlock()
+
+	if p.tracks != nil {
+		return p.tracks, nil
+	}
+
+	index, err := buildPlexTrackIndex(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	p.tracks = index
+	return index, nil
+}
+
+// matchContentByPath returns the ratingKey of the Plex track whose media file
+// is the one rekordbox stores at content.FolderPath, after applying the
+// client's path remaps and, failing that, resolving symlinks. Only tracks in
+// the section with key sectionKey count, or in any if it is empty.
+func matchContentByPath(ctx context.Context, plex *plexClient, content *rekordbox.DjmdContent, sectionKey string) (string, error) {
+	path := content.FolderPath.String()
+	if path == "" {
+		return "", errNoPlexMatch
+	}
+
+	path = plex.pathRemaps.apply(path)
+
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	track, ok := index.byPath[path]
+	if !ok {
+		// the folder rekordbox stored may be a symlink to where Plex
+		// indexed the files, e.g. ~/Music pointing at an external drive
+		track, ok = index.byPath[resolveSymlinks(path)]
+	}
+	if !ok || !index.inSection(track, sectionKey) {
+		return "", fmt.Errorf("%w for path %s", errNoPlexMatch, path)
+	}
+
+	return track.RatingKey, nil
+}
+
+// resolveSymlinks returns path with every symlink in it resolved, or path
+// itself if that fails, e.g. because the file isn't on this machine.
+func resolveSymlinks(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+
+	return resolved
+}
+
+// normalizeISRC returns isrc in its compact form, e.g. "USRC17607839" for
+// "US-RC1-76-07839", so the two spellings compare equal.
+func normalizeISRC(isrc string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(isrc), "-", ""))
+}
+
+// matchContentByISRC returns the ratingKey of the Plex track carrying the
+// same ISRC as track in the section with key sectionKey, or any if empty.
+// Plex only knows an ISRC when its agent found one.
+func matchContentByISRC(ctx context.Context, plex *plexClient, track *Track, sectionKey string) (string, error) {
+	if track.ISRC == "" {
+		return "", errNoPlexMatch
+	}
+
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	item, ok := index.byISRC[track.ISRC]
+	if !ok || !index.inSection(item, sectionKey) {
+		return "", fmt.Errorf("%w for ISRC %s", errNoPlexMatch, track.ISRC)
+	}
+
+	return item.RatingKey, nil
+}
+
+// metadataKey is the "artist - title" string metadata matching compares,
+// normalized with normalizeTitle.
+func metadataKey(artist, title string) string {
+	return normalizeTitle(artist + " - " + title)
+}
+
+// matchContentByMetadata searches Plex for tracks titled like content and
+// returns the candidate whose "artist - title" is most similar to the
+// rekordbox one, as long as the similarity is at least threshold (0..1).
+// Near-ties are broken by duration, see scoreTieMargin. The
+// similarity of the best candidate is returned even when it falls short, or
+// -1 if there was none. Only the section with key sectionKey is searched, or
+// all if it is empty.
+func matchContentByMetadata(ctx context.Context, plex *plexClient, track *Track, threshold float64, sectionKey string) (string, float64, error) {
+	title := track.Title
+	if title == "" {
+		return "", -1, errNoPlexMatch
+	}
+
+	artist, album := track.ArtistName, track.AlbumName
+
+	candidates, err := plex.searchTracks(ctx, title, sectionKey)
+	if err != nil {
+		return "", -1, err
+	}
+	if folded := normalizeTitle(title); len(candidates) == 0 && folded != strings.ToLower(title) {
+		// Plex compares titles as they are, so a curly apostrophe or an
+		// accent spelled differently finds nothing
+		if candidates, err = plex.searchTracks(ctx, folded, sectionKey); err != nil {
+			return "", -1, err
+		}
+	}
+
+	want := metadataKey(artist, title)
+
+	var best *plexMetadata
+	bestScore := -1.0
+	for _, candidate := range candidates {
+		score := similarity(want, metadataKey(candidate.GrandparentTitle, candidate.Title))
+		// prefer the candidate from the same album when scores tie
+		if score > bestScore || (score == bestScore && album != "" && strings.EqualFold(candidate.ParentTitle, album)) {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best == nil || bestScore < threshold {
+		return "", bestScore, fmt.Errorf("%w for %q (best score %.2f)", errNoPlexMatch, want, bestScore)
+	}
+
+	// remixes and edits share artist and title, so among candidates scoring
+	// about as well, the one as long as the rekordbox track is more likely
+	// right than whichever scored a hair higher
+	if track.DurationMs > 0 && !durationMatches(best, track.DurationMs) {
+		closest, closestDiff := (*plexMetadata)(nil), int64(0)
+		for _, candidate := range candidates {
+			score := similarity(want, metadataKey(candidate.GrandparentTitle, candidate.Title))
+			if score < threshold || score < bestScore-scoreTieMargin || !durationMatches(candidate, track.DurationMs) {
+				continue
+			}
+			if diff := absInt64(candidate.Duration - track.DurationMs); closest == nil || diff < closestDiff {
+				closest, closestDiff, bestScore = candidate, diff, score
+			}
+		}
+		if closest != nil {
+			best = closest
+		}
+	}
+
+	return best.RatingKey, bestScore, nil
+}
+
+// Duration tiebreaking of metadata matches: candidates scoring within
+// scoreTieMargin of the best count as tied, and a tied candidate within
+// durationTolerance of the rekordbox track's length wins.
+const (
+	scoreTieMargin    = 0.05
+	durationTolerance = 3 * time.Second
+)
+
+// durationMatches reports whether the Plex item is about durationMs long.
+// Items Plex hasn't read a duration for never match.
+func durationMatches(item *plexMetadata, durationMs int64) bool {
+	return item.Duration > 0 && absInt64(item.Duration-durationMs) <= durationTolerance.Milliseconds()
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// plexMatch is the outcome of matching one track.
+type plexMatch struct {
+	RatingKey string
+	Method    string
+	// Score is the confidence of the match, 0..1: 1 for a path or ISRC match
+	// and the artist/title similarity otherwise. Without a match it is the
+	// score of the best candidate, or -1 if there was none.
+	Score float64
+	// Section is the title of the library section the match is in
+	Section string
+}
+
+// matchContent finds the Plex item for content, trying the full path first,
+// then the ISRC, then artist and title, then the file name. Without a match the error wraps
+// errNoPlexMatch, and the returned plexMatch still carries the best score.
+// When the client is given several sections, all of that is tried in each
+// section in turn, so a match in an earlier one wins over any in later ones.
+func matchContent(ctx context.Context, plex *plexClient, content *rekordbox.DjmdContent, track *Track, opts SyncOptions) (*plexMatch, error) {
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sectionKeys := []string{""}
+	if len(plex.sections) > 1 && len(index.sectionKeys) > 1 {
+		sectionKeys = index.sectionKeys
+	}
+
+	best := &plexMatch{Score: -1}
+	for _, sectionKey := range sectionKeys {
+		match, err := matchContentInSection(ctx, plex, index, content, track, opts, sectionKey)
+		if err == nil {
+			match.Section = index.sectionTitles[index.sections[match.RatingKey]]
+			return match, nil
+		}
+		if !isNoMatch(err) {
+			return nil, err
+		}
+		if match.Score > best.Score {
+			best = match
+		}
+	}
+
+	return best, errNoPlexMatch
+}
+
+// matchContentInSection is matchContent restricted to the section with key
+// sectionKey, or unrestricted if it is empty.
+func matchContentInSection(ctx context.Context, plex *plexClient, index *plexTrackIndex, content *rekordbox.DjmdContent, track *Track, opts SyncOptions, sectionKey string) (*plexMatch, error) {
+	ratingKey, err := matchContentByPath(ctx, plex, content, sectionKey)
+	if err == nil {
+		return &plexMatch{RatingKey: ratingKey, Method: matchMethodPath, Score: 1}, nil
+	}
+	if !isNoMatch(err) {
+		return nil, err
+	}
+
+	// an ISRC identifies the recording, so it beats any fuzzy match
+	ratingKey, err = matchContentByISRC(ctx, plex, track, sectionKey)
+	if err == nil {
+		return &plexMatch{RatingKey: ratingKey, Method: matchMethodISRC, Score: 1}, nil
+	}
+	if !isNoMatch(err) {
+		return nil, err
+	}
+
+	ratingKey, score, err := matchContentByMetadata(ctx, plex, track, opts.MatchThreshold, sectionKey)
+	if err == nil {
+		return &plexMatch{RatingKey: ratingKey, Method: matchMethodMetadata, Score: score}, nil
+	}
+	if !isNoMatch(err) {
+		return nil, err
+	}
+
+	if candidate := index.matchByName(content, sectionKey); candidate != nil {
+		nameScore := similarity(metadataKey(track.ArtistName, track.Title), metadataKey(candidate.GrandparentTitle, candidate.Title))
+		// a file name match confirms an artist/title match that fell short,
+		// but doesn't override it
+		if nameScore >= opts.MatchThreshold {
+			return &plexMatch{RatingKey: candidate.RatingKey, Method: matchMethodName, Score: nameScore}, nil
+		}
+		if nameScore > score {
+			score = nameScore
+		}
+	}
+
+	return &plexMatch{Score: score}, errNoPlexMatch
+}
+
+// similarity returns 1 minus the Levenshtein distance between a and b,
+// normalized by the length of the longer string.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
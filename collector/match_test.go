@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+func TestPathRemapsApply(t *testing.T) {
+	remaps := pathRemaps{
+		{From: "/Users/me/Music", To: "/data/music"},
+		{From: "/Users/me/Music/Sets", To: "/data/sets"},
+		{From: "/Volumes/DJ/", To: "/data/dj/"},
+		{From: "/Volumes/DJ", To: "/data/other"},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/Users/me/Music/intro.mp3", "/data/music/intro.mp3"},
+		// the longer prefix wins whatever the order of the rules
+		{"/Users/me/Music/Sets/closer.mp3", "/data/sets/closer.mp3"},
+		// only on a path component boundary
+		{"/Users/me/Music2/intro.mp3", "/Users/me/Music2/intro.mp3"},
+		{"/Users/me/Music/Setsx/a.mp3", "/data/music/Setsx/a.mp3"},
+		// the longer of two rules for the same folder
+		{"/Volumes/DJ/a.mp3", "/data/dj/a.mp3"},
+		{"/Other/a.mp3", "/Other/a.mp3"},
+	}
+
+	for _, tt := range tests {
+		if got := remaps.apply(tt.path); got != tt.want {
+			t.Errorf("%s: remapped to %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"strobe", "strobe", 1},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"abc", "", 0},
+		{"deadmau - strobe", "deadmau5 - strobe", 1 - 1.0/17},
+	}
+
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Déjà Vu", "deja vu"},
+		// the same with combining accents
+		{"De\u0301ja\u0300 Vu", "deja vu"},
+		{"Don’t Stop", "don't stop"},
+		{"“Live” – Edit…", "\"live\" - edit..."},
+		{"Straße", "strasse"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.in); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeISRC(t *testing.T) {
+	for _, isrc := range []string{"USRC17607839", "US-RC1-76-07839", " usrc17607839 "} {
+		if got := normalizeISRC(isrc); got != "USRC17607839" {
+			t.Errorf("normalizeISRC(%q) = %q", isrc, got)
+		}
+	}
+}
+
+// testPlexTracks are the tracks of the test Plex server's music section.
+var testPlexTracks = []*plexMetadata{
+	testPlexTrack("101", "Artist", "Intro", "/data/music/intro.mp3", 0),
+	testPlexTrack("102", "Artist", "Closer", "/data/sets/closer.mp3", 0),
+	testPlexTrack("103", "Other", "Anthem", "/data/x/anthem.mp3", 0),
+	// an extended mix and the radio edit
+	testPlexTrack("104", "deadmau5", "Strobe", "/data/x/strobe.flac", 600000),
+	testPlexTrack("105", "deadmau5", "Strobe", "/data/x/strobe-edit.flac", 210000),
+	testPlexTrack("106", "Café", "Don't Stop", "/data/x/dont-stop.mp3", 0),
+	testPlexTrack("107", "Someone", "Mystery", "/data/x/mystery.mp3", 0),
+}
+
+func init() {
+	testPlexTracks[2].Guid = []*plexGuid{{ID: "isrc://US-RC1-76-07839"}}
+}
+
+func testPlexTrack(ratingKey, artist, title, file string, durationMs int64) *plexMetadata {
+	return &plexMetadata{
+		RatingKey:        ratingKey,
+		Type:             "track",
+		Title:            title,
+		GrandparentTitle: artist,
+		Duration:         durationMs,
+		Media:            []*plexMedia{{Part: []*plexPart{{File: file}}}},
+	}
+}
+
+// newMatchPlexServer serves a Plex server with one music section holding
+// testPlexTracks. Like Plex, a title search finds the tracks whose title
+// contains it, ignoring case.
+func newMatchPlexServer(t *testing.T) *plexClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mc := &plexMediaContainer{}
+		switch r.URL.Path {
+		case "/library/sections":
+			mc.Directory = []*plexSection{{Key: "1", Title: "Music", Type: "artist"}}
+		case "/library/sections/1/all":
+			title := strings.ToLower(r.URL.Query().Get("title"))
+			for _, track := range testPlexTracks {
+				if strings.Contains(strings.ToLower(track.Title), title) {
+					mc.Metadata = append(mc.Metadata, track)
+				}
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]*plexMediaContainer{"MediaContainer": mc})
+	}))
+	t.Cleanup(server.Close)
+
+	plex := newPlexClient(server.URL, "token")
+	plex.pathRemaps = pathRemaps{
+		{From: "/Users/me/Music", To: "/data/music"},
+		{From: "/Users/me/Music/Sets", To: "/data/sets"},
+	}
+
+	return plex
+}
+
+func TestMatchContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		artist    string
+		title     string
+		isrc      string
+		duration  int64
+		threshold float64
+		// key is the rating key matched, empty for none
+		key    string
+		method string
+	}{
+		{name: "path", path: "/Users/me/Music/intro.mp3", title: "Whatever", threshold: 0.8, key: "101", method: matchMethodPath},
+		{name: "longest remap", path: "/Users/me/Music/Sets/closer.mp3", threshold: 0.8, key: "102", method: matchMethodPath},
+		{name: "isrc", path: "/Users/me/Music/moved.mp3", title: "Intro", artist: "Artist", isrc: "USRC17607839", threshold: 0.8, key: "103", method: matchMethodISRC},
+		{name: "metadata", artist: "Artist", title: "Intro", threshold: 0.8, key: "101", method: matchMethodMetadata},
+		// "deadmau - strobe" is 1 - 1/17 ≈ 0.941 similar to "deadmau5 - strobe"
+		{name: "fuzzy at threshold", artist: "deadmau", title: "Strobe", threshold: 0.94, key: "104", method: matchMethodMetadata},
+		{name: "fuzzy below threshold", artist: "deadmau", title: "Strobe", threshold: 0.95},
+		{name: "duration tiebreak", artist: "deadmau5", title: "Strobe", duration: 211000, threshold: 0.8, key: "105", method: matchMethodMetadata},
+		{name: "duration out of tolerance", artist: "deadmau5", title: "Strobe", duration: 300000, threshold: 0.8, key: "104", method: matchMethodMetadata},
+		// a curly apostrophe Plex doesn't find, and a decomposed accent
+		{name: "normalized", artist: "Cafe\u0301", title: "Don’t Stop", threshold: 1, key: "106", method: matchMethodMetadata},
+		// the title search finds nothing, the file name does; the
+		// similarity is 1 - 15/32 ≈ 0.53
+		{name: "name at threshold", path: "/Users/me/Music/mystery.mp3", artist: "Someone", title: "Mystery (Original Mix)", threshold: 0.5, key: "107", method: matchMethodName},
+		{name: "name below threshold", path: "/Users/me/Music/mystery.mp3", artist: "Someone", title: "Mystery (Original Mix)", threshold: 0.6},
+		{name: "nothing", path: "/Users/me/Music/none.mp3", artist: "Nobody", title: "Nothing", threshold: 0.8},
+	}
+
+	for _, tt := range tests {
+		plex := newMatchPlexServer(t)
+		content := &rekordbox.DjmdContent{
+			FolderPath: nulltype.NullStringOf(tt.path),
+			Title:      nulltype.NullStringOf(tt.title),
+		}
+		if tt.path != "" {
+			content.FileNameL = nulltype.NullStringOf(tt.path[strings.LastIndex(tt.path, "/")+1:])
+		}
+		track := &Track{ArtistName: tt.artist, Title: tt.title, ISRC: tt.isrc, DurationMs: tt.duration}
+
+		match, err := matchContent(context.Background(), plex, content, track, SyncOptions{MatchThreshold: tt.threshold})
+		if tt.key == "" {
+			if !isNoMatch(err) {
+				t.Errorf("%s: matched %+v, error %v, want no match", tt.name, match, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if match.RatingKey != tt.key || match.Method != tt.method || match.Section != "Music" {
+			t.Errorf("%s: matched %s by %s in %q, want %s by %s in Music", tt.name, match.RatingKey, match.Method, match.Section, tt.key, tt.method)
+		}
+	}
+}
+
+func TestMatchContentBestScore(t *testing.T) {
+	plex := newMatchPlexServer(t)
+	content := &rekordbox.DjmdContent{Title: nulltype.NullStringOf("Strobe")}
+	track := &Track{ArtistName: "deadmau", Title: "Strobe"}
+
+	match, err := matchContent(context.Background(), plex, content, track, SyncOptions{MatchThreshold: 0.95})
+	if !isNoMatch(err) {
+		t.Fatalf("error %v, want no match", err)
+	}
+	if want := 1 - 1.0/17; math.Abs(match.Score-want) > 1e-9 {
+		t.Errorf("score %v, want that of the best candidate, %v", match.Score, want)
+	}
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// matchCacheFile holds the Plex match of every rekordbox track matched
+// before, by server machine identifier and then content ID, so a sync only
+// has to match the tracks that are new or changed. Rating keys are local to
+// a server, hence one set per server.
+type matchCacheFile struct {
+	Servers map[string]map[string]*cachedMatch `json:"servers"`
+}
+
+// cachedMatch is the Plex match a track was given and what it was made for.
+type cachedMatch struct {
+	// Path is the rekordbox file path the match was made for; a track
+	// whose file moved is matched again
+	Path      string  `json:"path"`
+	RatingKey string  `json:"rating_key"`
+	Method    string  `json:"method"`
+	Score     float64 `json:"score"`
+	// PlexFile is the file of the Plex item when it matched. The item
+	// holding another file, say after the album was replaced, means it may
+	// no longer be the same recording.
+	PlexFile  string    `json:"plex_file"`
+	MatchedAt time.Time `json:"matched_at"`
+}
+
+// matchCache is the entries of one server, shared by the matching workers.
+// A nil *matchCache caches nothing.
+type matchCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedMatch
+	hits    int
+}
+
+func defaultMatchCachePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".config", "rekordbox-plexamp-sync", "match-cache.json"), nil
+}
+
+// loadMatchCache reads the match cache file at path. A missing file yields
+// an empty one.
+func loadMatchCache(path string) (*matchCacheFile, error) {
+	file := &matchCacheFile{}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if file.Servers == nil {
+		file.Servers = map[string]map[string]*cachedMatch{}
+	}
+
+	return file, nil
+}
+
+func saveMatchCache(path string, file *matchCacheFile) error {
+	b, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
+
+// server returns the cache of the server with machineID, backed by file, so
+// what is recorded in it is saved with file.
+func (f *matchCacheFile) server(machineID string) *matchCache {
+	entries, ok := f.Servers[machineID]
+	if !ok {
+		entries = map[string]*cachedMatch{}
+		f.Servers[machineID] = entries
+	}
+
+	return &matchCache{entries: entries}
+}
+
+// lookup returns the cached match of content, unless the track's file has
+// moved, the Plex item is gone from index or now holds another file, or the
+// match scored below threshold.
+func (c *matchCache) lookup(content *rekordbox.DjmdContent, index *plexTrackIndex, threshold float64) (*plexMatch, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[content.ID.String()]
+	if !ok || cached.Path != content.FolderPath.String() || cached.Score < threshold {
+		return nil, false
+	}
+	item, ok := index.byKey[cached.RatingKey]
+	if !ok || plexFile(item) != cached.PlexFile {
+		return nil, false
+	}
+
+	c.hits++
+	return &plexMatch{
+		RatingKey: cached.RatingKey,
+		Method:    cached.Method,
+		Score:     cached.Score,
+		Section:   index.sectionTitles[index.sections[cached.RatingKey]],
+	}, true
+}
+
+// record caches match as the match of content.
+func (c *matchCache) record(content *rekordbox.DjmdContent, index *plexTrackIndex, match *plexMatch) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[content.ID.String()] = &cachedMatch{
+		Path:      content.FolderPath.String(),
+		RatingKey: match.RatingKey,
+		Method:    match.Method,
+		Score:     match.Score,
+		PlexFile:  plexFile(index.byKey[match.RatingKey]),
+		MatchedAt: time.Now().UTC(),
+	}
+}
+
+// plexFile returns the first media file of item, or "" if it has none.
+func plexFile(item *plexMetadata) string {
+	if item == nil || len(item.Media) == 0 || len(item.Media[0].Part) == 0 {
+		return ""
+	}
+
+	return item.Media[0].Part[0].File
+}
+
+// cachedMatchContent is matchContent answered from opts.matches where it
+// holds a match that is still good, recording new matches in it otherwise.
+func cachedMatchContent(ctx context.Context, plex *plexClient, content *rekordbox.DjmdContent, track *Track, opts SyncOptions) (*plexMatch, error) {
+	if opts.matches == nil {
+		return matchContent(ctx, plex, content, track, opts)
+	}
+
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if match, ok := opts.matches.lookup(content, index, opts.MatchThreshold); ok {
+		return match, nil
+	}
+
+	match, err := matchContent(ctx, plex, content, track, opts)
+	if err == nil {
+		opts.matches.record(content, index, match)
+	}
+
+	return match, err
+}
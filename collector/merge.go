@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Orders of the tracks of a merged playlist, see SyncOptions.MergeOrder.
+const (
+	mergeOrderAppearance = "appearance"
+	mergeOrderArtist     = "artist"
+	mergeOrderTitle      = "title"
+)
+
+func (opts SyncOptions) mergeOrder() (string, error) {
+	switch opts.MergeOrder {
+	case "":
+		return mergeOrderAppearance, nil
+	case mergeOrderAppearance, mergeOrderArtist, mergeOrderTitle:
+		return opts.MergeOrder, nil
+	}
+
+	return "", fmt.Errorf("unknown merge order %q, expected appearance, artist or title", opts.MergeOrder)
+}
+
+// mergePlaylists plans the playlist named name holding every matched track of
+// playlists once. It has no rekordbox ID, so it is found in Plex by title.
+func mergePlaylists(name string, playlists []*playlistPlan, order string) *playlistPlan {
+	merged := &playlistPlan{
+		Name:        name,
+		Tracks:      []*plannedTrack{},
+		Unmatched:   []*plannedTrack{},
+		Unsupported: []*plannedTrack{},
+		merged:      true,
+	}
+
+	seen := map[string]bool{}
+	for _, pp := range playlists {
+		for _, track := range pp.Tracks {
+			if seen[track.RatingKey] {
+				continue
+			}
+			seen[track.RatingKey] = true
+			merged.Tracks = append(merged.Tracks, track)
+		}
+	}
+
+	sort.SliceStable(merged.Tracks, func(i, j int) bool {
+		a, b := merged.Tracks[i], merged.Tracks[j]
+		switch order {
+		case mergeOrderArtist:
+			if c := strings.Compare(strings.ToLower(a.Artist), strings.ToLower(b.Artist)); c != 0 {
+				return c < 0
+			}
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case mergeOrderTitle:
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+
+		// first appearance, which the merge already produced
+		return false
+	})
+
+	return merged
+}
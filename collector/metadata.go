@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// plexTag is a tag of a Plex item, such as one of its genres.
+type plexTag struct {
+	Tag string `json:"tag"`
+}
+
+// Placeholders Plex shows for tracks whose file has no tags.
+const (
+	plexUnknownArtist = "[Unknown Artist]"
+	plexUnknownAlbum  = "[Unknown Album]"
+)
+
+// trackTags are the rekordbox fields pushed to the Plex item of a track.
+type trackTags struct {
+	Title  string
+	Artist string
+	Album  string
+	Genre  string
+}
+
+// applyMetadata copies the title, artist, album and genre of every matched
+// track in plan to its Plex item, for files whose only tags are the ones in
+// rekordbox. Unless force is set, only the fields Plex has nothing for are
+// written, which takes reading each item first. Fields rekordbox has nothing
+// for are never written. Each item is edited at most once and failures are
+// logged without stopping the others.
+func applyMetadata(ctx context.Context, plex *plexClient, plan *syncPlan, force bool) (int, error) {
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	done := map[string]bool{}
+	set := 0
+	for _, pp := range plan.Playlists {
+		for _, track := range pp.Tracks {
+			if done[track.RatingKey] {
+				continue
+			}
+			done[track.RatingKey] = true
+
+			section, ok := index.sections[track.RatingKey]
+			if !ok {
+				continue
+			}
+
+			var item *plexMetadata
+			if !force {
+				if item, err = plex.metadata(ctx, track.RatingKey); err != nil {
+					slog.Warn("failed to read Plex track", "title", track.Title, "error", err)
+					continue
+				}
+			}
+
+			fields := metadataFields(item, track)
+			if len(fields) == 0 {
+				continue
+			}
+			if err := plex.editTrack(ctx, section, track.RatingKey, fields); err != nil {
+				slog.Warn("failed to set metadata", "title", track.Title, "error", err)
+				continue
+			}
+			set++
+		}
+	}
+
+	return set, nil
+}
+
+// metadataFields returns the edit fields that set the tags of track on item,
+// locked so Plex's agents keep them. With a nil item every field rekordbox
+// has a value for is set, otherwise only those empty on item.
+func metadataFields(item *plexMetadata, track *plannedTrack) map[string]string {
+	fields := map[string]string{}
+	set := func(name, field, value string, empty bool) {
+		if value == "" || !empty {
+			return
+		}
+		fields[field] = value
+		fields[name+".locked"] = "1"
+	}
+
+	tags := track.tags
+	if item == nil {
+		item = &plexMetadata{}
+	}
+	set("title", "title.value", tags.Title, item.Title == "" || isFilenameTitle(item, track.Path))
+	// originalTitle is the track artist, which Plexamp shows over the album
+	// artist
+	set("originalTitle", "originalTitle.value", tags.Artist, item.OriginalTitle == "" && (item.GrandparentTitle == "" || item.GrandparentTitle == plexUnknownArtist))
+	set("parentTitle", "parentTitle.value", tags.Album, item.ParentTitle == "" || item.ParentTitle == plexUnknownAlbum)
+	set("genre", "genre[0].tag.tag", tags.Genre, len(item.Genre) == 0)
+
+	return fields
+}
+
+// isFilenameTitle reports whether item is titled after its file name, as
+// Plex does for files without a title tag.
+func isFilenameTitle(item *plexMetadata, path string) bool {
+	name := filepath.Base(path)
+	return item.Title == strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// colorMood returns the Plex mood that moods maps the color of track to,
+// comparing color names case-insensitively. Tracks without a color, or with
+// an unmapped one, get "".
+func colorMood(moods map[string]string, track *Track) string {
+	if track.Color == nil {
+		return ""
+	}
+
+	for name, mood := range moods {
+		if strings.EqualFold(name, track.Color.Name) {
+			return mood
+		}
+	}
+
+	return ""
+}
+
+// applyMoods sets the mood of every matched track in plan that has one. Each
+// Plex item is only edited once, even if it appears in several playlists.
+// Failures are logged and don't stop the others.
+func applyMoods(ctx context.Context, plex *plexClient, plan *syncPlan) int {
+	done := map[string]bool{}
+	set := 0
+	for _, pp := range plan.Playlists {
+		for _, track := range pp.Tracks {
+			if track.Mood == "" || done[track.RatingKey] {
+				continue
+			}
+			done[track.RatingKey] = true
+
+			if err := plex.setMood(ctx, track.RatingKey, track.Mood); err != nil {
+				slog.Warn("failed to set mood", "title", track.Title, "mood", track.Mood, "error", err)
+				continue
+			}
+			set++
+		}
+	}
+
+	return set
+}
+
+// setMood replaces the moods of the track with ratingKey by mood.
+func (p *plexClient) setMood(ctx context.Context, ratingKey, mood string) error {
+	index, err := p.trackIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	section, ok := index.sections[ratingKey]
+	if !ok {
+		return fmt.Errorf("plex track %s is in no music section", ratingKey)
+	}
+
+	return p.editTrack(ctx, section, ratingKey, map[string]string{
+		"mood[0].tag.tag": mood,
+		// keep Plex from replacing it with what its agents find
+		"mood.locked": "1",
+	})
+}
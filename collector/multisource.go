@@ -0,0 +1,197 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandOptionsPaths resolves the --options paths to options.json files,
+// replacing each directory by the .json files directly inside it.
+func expandOptionsPaths(paths []string) ([]string, error) {
+	expanded := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("options path %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("options directory %s holds no .json files", path)
+		}
+		sort.Strings(matches)
+		expanded = append(expanded, matches...)
+	}
+
+	return expanded, nil
+}
+
+// libraryName names the library of the options.json at path after the file,
+// or after its directory for files called options.json as rekordbox does.
+func libraryName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if strings.EqualFold(name, "options") {
+		name = filepath.Base(filepath.Dir(path))
+	}
+
+	return name
+}
+
+// namedSource is one of the libraries of a multiSource.
+type namedSource struct {
+	name string
+	src  playlistSource
+	// analysisDir replaces CollectOptions.AnalysisDir for this library
+	analysisDir string
+	// err is why the library could not be opened
+	err error
+}
+
+// multiSource reads several rekordbox libraries at once, prefixing the
+// names of each library's playlists with the library's name so they can't
+// collide. A library that fails is logged and left out; only if all of them
+// fail is the collection an error.
+type multiSource struct {
+	libraries []*namedSource
+	closers   []func() error
+}
+
+// openLibraries opens the library of every options.json in paths.
+func openLibraries(paths []string, opts CollectOptions) *multiSource {
+	m := &multiSource{}
+	names := uniqueNames{}
+	for _, path := range paths {
+		lib := &namedSource{name: names.next(libraryName(path))}
+		m.libraries = append(m.libraries, lib)
+
+		client, err := openClient(path, opts.Snapshot)
+		if err != nil {
+			lib.err = err
+			continue
+		}
+		m.closers = append(m.closers, client.Close)
+
+		lib.src = &dbSource{client: opts.retrying(client)}
+		if dir, err := analysisDir(path); err == nil {
+			lib.analysisDir = dir
+		} else {
+			slog.Warn("analysis files not found, beat grids will be missing", "library", lib.name, "error", err)
+		}
+	}
+
+	return m
+}
+
+func (m *multiSource) Close() error {
+	errs := []error{}
+	for _, closeLibrary := range m.closers {
+		errs = append(errs, closeLibrary())
+	}
+
+	return errors.Join(errs...)
+}
+
+// each calls collect for every library that opened, with the options for
+// that library.
+func (m *multiSource) each(opts CollectOptions, collect func(lib *namedSource, opts CollectOptions) error) error {
+	errs := []error{}
+	for _, lib := range m.libraries {
+		err := lib.err
+		if err == nil {
+			libOpts := opts
+			if libOpts.AnalysisDir == "" {
+				libOpts.AnalysisDir = lib.analysisDir
+			}
+			err = collect(lib, libOpts)
+		}
+		if err != nil {
+			slog.Error("skipping library", "library", lib.name, "error", err)
+			errs = append(errs, fmt.Errorf("library %s: %w", lib.name, err))
+		}
+	}
+
+	if len(errs) == len(m.libraries) {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+func (m *multiSource) playlists(ctx context.Context, opts CollectOptions) ([]*Playlist, error) {
+	all := []*Playlist{}
+	err := m.each(opts, func(lib *namedSource, opts CollectOptions) error {
+		playlists, err := lib.src.playlists(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, pl := range playlists {
+			pl.CombinedName = lib.name + opts.nameSeparator() + pl.CombinedName
+			if pl.Path != nil {
+				pl.Path = append([]string{lib.name}, pl.Path...)
+			}
+		}
+		all = append(all, playlists...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+// tree nests each library's tree in a folder named after the library. Node
+// IDs are prefixed with the library name too, as each database numbers its
+// playlists on its own.
+func (m *multiSource) tree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error) {
+	roots := []*PlaylistNode{}
+	err := m.each(opts, func(lib *namedSource, opts CollectOptions) error {
+		tree, err := lib.src.tree(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		prefixNodes(tree, lib.name, opts.nameSeparator())
+		for _, node := range tree {
+			node.ParentID = lib.name
+		}
+		roots = append(roots, &PlaylistNode{
+			ID:       lib.name,
+			ParentID: "root",
+			Name:     lib.name,
+			Kind:     nodeKindFolder,
+			Children: tree,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return roots, nil
+}
+
+func prefixNodes(nodes []*PlaylistNode, name, separator string) {
+	for _, node := range nodes {
+		node.ID = name + ":" + node.ID
+		node.ParentID = name + ":" + node.ParentID
+		if node.CombinedName != "" {
+			node.CombinedName = name + separator + node.CombinedName
+		}
+		prefixNodes(node.Children, name, separator)
+	}
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// MyTag is a My Tag assigned to a track. My Tags are a two-level tree:
+// categories such as "Energy" at the top, and the tags themselves below.
+type MyTag struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+}
+
+// loadMyTags reads the My Tag tree and every assignment in two queries and
+// indexes the tags by content ID.
+func (r *resolver) loadMyTags(ctx context.Context) error {
+	nodes, err := r.client.AllDjmdMyTag(ctx)
+	if err != nil {
+		return fmt.Errorf("loading my tags: %w", err)
+	}
+
+	names := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		names[node.ID.String()] = node.Name.String()
+	}
+
+	tags := make(map[string]*MyTag, len(nodes))
+	for _, node := range nodes {
+		// the category is the parent node; top-level nodes have none
+		tags[node.ID.String()] = &MyTag{
+			Category: names[node.ParentID.String()],
+			Name:     node.Name.String(),
+		}
+	}
+
+	assignments, err := r.client.AllDjmdSongMyTag(ctx)
+	if err != nil {
+		return fmt.Errorf("loading my tag assignments: %w", err)
+	}
+
+	r.myTags = map[string][]*MyTag{}
+	for _, assignment := range assignments {
+		if assignment.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		tag, ok := tags[assignment.MyTagID.String()]
+		if !ok {
+			continue
+		}
+
+		contentID := assignment.ContentID.String()
+		r.myTags[contentID] = append(r.myTags[contentID], tag)
+	}
+
+	for _, contentTags := range r.myTags {
+		sort.Slice(contentTags, func(i, j int) bool {
+			if contentTags[i].Category != contentTags[j].Category {
+				return contentTags[i].Category < contentTags[j].Category
+			}
+			return contentTags[i].Name < contentTags[j].Name
+		})
+	}
+
+	return nil
+}
+
+// exportedMyTags is contentMyTags, or none if My Tags aren't exported.
+func (r *resolver) exportedMyTags(contentID string) []*MyTag {
+	if !r.fields.has(fieldMyTags) {
+		return []*MyTag{}
+	}
+
+	return r.contentMyTags(contentID)
+}
+
+// contentMyTags returns the My Tags of content, never nil.
+func (r *resolver) contentMyTags(contentID string) []*MyTag {
+	if tags, ok := r.myTags[contentID]; ok {
+		return tags
+	}
+
+	return []*MyTag{}
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldedRunes maps precomposed Latin letters to their base letters, and
+// typographic punctuation to the ASCII that tags usually spell it with.
+var foldedRunes = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l", 'ĺ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'ŕ': "r", 'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ș': "s", 'ß': "ss",
+	'ť': "t", 'ţ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+	'æ': "ae", 'œ': "oe", 'þ': "th",
+
+	'‘': "'", '’': "'", '‚': "'", '′': "'", '`': "'", '´': "'",
+	'“': "\"", '”': "\"", '„': "\"", '″': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...",
+	' ': " ",
+}
+
+// normalizeTitle lowercases s and folds away the differences that tags of
+// the same track commonly have: accents, whether they are precomposed or
+// combining marks, curly quotes and typographic dashes. "Déjà Vu" and
+// "Deja Vu", or "Don’t" and "Don't", normalize the same.
+func normalizeTitle(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		if unicode.Is(unicode.Mn, r) {
+			// combining accents of decomposed letters
+			continue
+		}
+		if folded, ok := foldedRunes[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,295 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// optionsFileCandidates lists where rekordbox keeps its agent options.json on
+// the current platform, most likely location first.
+func optionsFileCandidates() ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	storage := filepath.Join("Pioneer", "rekordboxAgent", "storage", "options.json")
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{filepath.Join(homeDir, "Library", "Application Support", storage)}, nil
+	case "windows":
+		candidates := []string{}
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			candidates = append(candidates, filepath.Join(appData, storage))
+		}
+		return append(candidates, filepath.Join(homeDir, "AppData", "Roaming", storage)), nil
+	default:
+		// rekordbox has no native Linux build, so look where a Wine prefix or
+		// a copied-over config directory would put it
+		candidates := []string{}
+		if configDir, err := os.UserConfigDir(); err == nil {
+			candidates = append(candidates, filepath.Join(configDir, storage))
+		}
+		user := os.Getenv("USER")
+		return append(candidates, filepath.Join(homeDir, ".wine", "drive_c", "users", user, "AppData", "Roaming", storage)), nil
+	}
+}
+
+// optionsPathEnv names the environment variable that points at options.json
+// when no path is given explicitly.
+const optionsPathEnv = "REKORDBOX_OPTIONS_PATH"
+
+// resolveOptionsPath returns explicit if set, then the path in
+// $REKORDBOX_OPTIONS_PATH, and otherwise the detected location. A path that is
+// given but doesn't exist is an error rather than falling back.
+func resolveOptionsPath(explicit string) (string, error) {
+	path, source := explicit, "options path"
+	if path == "" {
+		path, source = os.Getenv(optionsPathEnv), "$"+optionsPathEnv
+	}
+	if path == "" {
+		return locateOptionsFile()
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%s %s: %w", source, path, err)
+	}
+
+	return path, nil
+}
+
+// locateOptionsFile returns the path of rekordbox's options.json for the
+// current platform, or an error if it cannot be found in any known location.
+func locateOptionsFile() (string, error) {
+	candidates, err := optionsFileCandidates()
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("rekordbox options.json not found, looked in %v", candidates)
+}
+
+// readOptions returns the values of the [name, value] pairs that make up the
+// options.json at optionsFilePath. Values that aren't strings are skipped.
+func readOptions(optionsFilePath string) (map[string]string, error) {
+	b, err := os.ReadFile(optionsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var options struct {
+		Options [][]interface{} `json:"options"`
+	}
+	if err := json.Unmarshal(b, &options); err != nil {
+		return nil, fmt.Errorf("options.json is malformed: %w", err)
+	}
+
+	values := map[string]string{}
+	for _, option := range options.Options {
+		if len(option) != 2 {
+			continue
+		}
+		name, _ := option[0].(string)
+		if value, ok := option[1].(string); ok && name != "" {
+			values[name] = value
+		}
+	}
+
+	return values, nil
+}
+
+// validateOptions checks that the options.json at optionsFilePath names a
+// database that exists and the key to decrypt it, and returns the database's
+// path. rekordbox.NewClient panics on files it can't make sense of, which
+// happens when an update of rekordbox leaves the file half written.
+func validateOptions(optionsFilePath string) (string, error) {
+	options, err := readOptions(optionsFilePath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", optionsFilePath, err)
+	}
+
+	for _, name := range []string{"db-path", "dp"} {
+		if options[name] == "" {
+			return "", fmt.Errorf("%s: options.json is malformed: missing %s", optionsFilePath, name)
+		}
+	}
+
+	dbPath := options["db-path"]
+	if _, err := os.Stat(dbPath); err != nil {
+		return "", fmt.Errorf("%s: database named by db-path: %w", optionsFilePath, err)
+	}
+
+	return dbPath, nil
+}
+
+// pingDatabase runs a trivial query against the freshly opened client.
+// NewClient doesn't touch the file, so without this a database that can't be
+// read, say with the wrong key or on a drive that went away, only fails deep
+// inside the first real query with an obscure SQLite error.
+func pingDatabase(client *rekordbox.Client, dbPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	// a lock is temporary, and retried by the queries that follow
+	_, err := client.AllDjmdProperty(ctx)
+	if err != nil && isKeyFailure(err.Error()) {
+		slog.Debug("decrypting database failed", "db_path", dbPath, "error", err)
+		return fmt.Errorf("%s: %w", dbPath, errUnsupportedKey)
+	}
+	if err != nil && !isLocked(err) {
+		return fmt.Errorf("cannot read rekordbox database at %s: %w", dbPath, err)
+	}
+
+	return nil
+}
+
+// errUnsupportedKey is returned when the key options.json holds doesn't
+// decrypt the database. That is what a rekordbox update changing the format
+// of the key looks like, so that's what it says, in place of SQLite's "file
+// is not a database".
+var errUnsupportedKey = errors.New("unable to decrypt the rekordbox database; your rekordbox version may use an unsupported key format")
+
+// isKeyFailure reports whether msg, of an error or a panic opening or first
+// reading the database, comes from decoding the key or from SQLCipher
+// finding the database can't be decrypted with it.
+func isKeyFailure(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, s := range []string{"file is not a database", "file is encrypted", "sqlite_notadb", "blowfish", "illegal base64", "input not full blocks"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pingTimeout bounds pingDatabase, so a database on a hung network drive
+// fails instead of blocking.
+const pingTimeout = 10 * time.Second
+
+// analysisDir returns the directory that the AnalysisDataPath of each content
+// row is relative to: the "share" folder next to the master.db named in the
+// options.json at optionsFilePath, resolved as by resolveOptionsPath.
+func analysisDir(optionsFilePath string) (string, error) {
+	optionsFilePath, err := resolveOptionsPath(optionsFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	options, err := readOptions(optionsFilePath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", optionsFilePath, err)
+	}
+
+	dbPath := options["db-path"]
+	if dbPath == "" {
+		return "", fmt.Errorf("no db-path in %s", optionsFilePath)
+	}
+
+	return filepath.Join(filepath.Dir(dbPath), "share"), nil
+}
+
+// detectAnalysisDir fills in AnalysisDir from the options.json unless it was
+// given. Without it beat grids are left out, so failures are only logged.
+func (opts *CollectOptions) detectAnalysisDir(optionsFilePath string) {
+	if opts.AnalysisDir != "" {
+		return
+	}
+
+	dir, err := analysisDir(optionsFilePath)
+	if err != nil {
+		slog.Warn("analysis files not found, beat grids will be missing", "error", err)
+		return
+	}
+
+	opts.AnalysisDir = dir
+}
+
+// optionsKeyName is the option holding the database's decryption key, which
+// is never reported.
+const optionsKeyName = "dp"
+
+// optionsReport describes a resolved options.json for debugging which
+// library is read, without the decryption key.
+type optionsReport struct {
+	OptionsPath string `json:"options_path"`
+	// Source says where OptionsPath came from: "argument",
+	// "$REKORDBOX_OPTIONS_PATH" or "detected"
+	Source   string `json:"source"`
+	DBPath   string `json:"db_path"`
+	DBExists bool   `json:"db_exists"`
+	// KeyFound is whether the file holds a decryption key at all, which is
+	// not checked against the database
+	KeyFound    bool   `json:"key_found"`
+	AnalysisDir string `json:"analysis_dir,omitempty"`
+	// Options are all other string options of the file, such as the agent's
+	// version, by name
+	Options map[string]string `json:"options"`
+	Errors  []string          `json:"errors"`
+}
+
+// describeOptions reports on the options.json at optionsPath, located as by
+// resolveOptionsPath if empty. Problems are listed in the report's errors
+// rather than returned, so it says as much as it can.
+func describeOptions(optionsPath string) *optionsReport {
+	report := &optionsReport{Source: "argument", Options: map[string]string{}, Errors: []string{}}
+	switch {
+	case optionsPath != "":
+	case os.Getenv(optionsPathEnv) != "":
+		report.Source = "$" + optionsPathEnv
+	default:
+		report.Source = "detected"
+	}
+
+	path, err := resolveOptionsPath(optionsPath)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	report.OptionsPath = path
+
+	options, err := readOptions(path)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	report.DBPath = options["db-path"]
+	report.KeyFound = options[optionsKeyName] != ""
+	for name, value := range options {
+		if name != "db-path" && name != optionsKeyName {
+			report.Options[name] = value
+		}
+	}
+
+	if report.DBPath == "" {
+		report.Errors = append(report.Errors, "no db-path in options.json")
+	} else if _, err := os.Stat(report.DBPath); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else {
+		report.DBExists = true
+		report.AnalysisDir = filepath.Join(filepath.Dir(report.DBPath), "share")
+	}
+	if !report.KeyFound {
+		report.Errors = append(report.Errors, "no decryption key in options.json")
+	}
+
+	return report
+}
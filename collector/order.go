@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Orders of the exported playlists, see CollectOptions.Sort.
+const (
+	sortName = "name"
+	sortSeq  = "seq"
+)
+
+func (opts CollectOptions) playlistSort() (string, error) {
+	switch opts.Sort {
+	case "":
+		return sortName, nil
+	case sortName, sortSeq:
+		return opts.Sort, nil
+	}
+
+	return "", fmt.Errorf("unknown sort %q, expected name or seq", opts.Sort)
+}
+
+// addSeqs sets the Seq and SeqPath of playlists. nodes holds every playlist
+// row by ID, for looking up the folders of each playlist.
+func addSeqs(playlists []*Playlist, nodes map[string]*rekordbox.DjmdPlaylist) {
+	for _, pl := range playlists {
+		pl.Seq = pl.DJMdPlaylist.Seq.Int64Value()
+		pl.SeqPath = seqPath(nodes, pl.DJMdPlaylist)
+	}
+}
+
+// sortPlaylists orders playlists as selected by opts, by SeqPath for the seq
+// sort. Ties are broken by ID, so the order is the same on every run however
+// the database lists the rows.
+func sortPlaylists(playlists []*Playlist, opts CollectOptions) {
+	mode, _ := opts.playlistSort()
+
+	sort.SliceStable(playlists, func(i, j int) bool {
+		a, b := playlists[i], playlists[j]
+		switch mode {
+		case sortSeq:
+			if c := compareSeqs(a.SeqPath, b.SeqPath); c != 0 {
+				return c < 0
+			}
+		default:
+			if a.CombinedName != b.CombinedName {
+				return a.CombinedName < b.CombinedName
+			}
+		}
+
+		return lessID(a.DJMdPlaylist.ID.String(), b.DJMdPlaylist.ID.String())
+	})
+}
+
+// seqPath returns the Seq of each folder above playlist and of playlist
+// itself, outermost first, which orders playlists the way rekordbox's sidebar
+// shows them.
+func seqPath(nodes map[string]*rekordbox.DjmdPlaylist, playlist *rekordbox.DjmdPlaylist) []int64 {
+	path := []int64{}
+	for row := playlist; row != nil && len(path) < maxPlaylistDepth; row = nodes[row.ParentID.String()] {
+		path = append([]int64{row.Seq.Int64Value()}, path...)
+	}
+
+	return path
+}
+
+func compareSeqs(a, b []int64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// orphanTracks returns the tracks of the collection that no playlist lists,
+// resolved like the tracks of a playlist and passed through the same track
+// filters. Only entries of ordinary playlists count: a track that only an
+// intelligent playlist's rules select is still an orphan to organize.
+func orphanTracks(ctx context.Context, client libraryClient, opts CollectOptions) ([]*Track, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	r, err := opts.newResolver(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := client.AllDjmdSongPlaylist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing playlist entries: %w", err)
+	}
+
+	listed := map[string]bool{}
+	for _, song := range songs {
+		if song.RbLocalDeleted.Int64Value() == 0 {
+			listed[song.ContentID.String()] = true
+		}
+	}
+
+	tracks := []*Track{}
+	for id, content := range r.contents {
+		if listed[id] || content.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+		if !opts.keepsTrack(r.contentMyTags(id)) || !opts.keepsContent(content) || !opts.keepsAnalyzed(content) {
+			continue
+		}
+
+		tracks = append(tracks, r.track(ctx, content))
+	}
+	sortTracks(tracks)
+
+	return tracks, nil
+}
+
+// sortTracks orders tracks by content ID, which follows the order they were
+// added to the collection in.
+func sortTracks(tracks []*Track) {
+	sort.Slice(tracks, func(i, j int) bool {
+		return lessID(tracks[i].ContentID, tracks[j].ContentID)
+	})
+}
+
+func (s *dbSource) orphans(ctx context.Context, opts CollectOptions) ([]*Track, error) {
+	return orphanTracks(ctx, s.client, opts)
+}
+
+func (s *xmlSource) orphans(ctx context.Context, opts CollectOptions) ([]*Track, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	// validated above
+	fields, _ := opts.fields()
+
+	lib, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	listed := map[string]bool{}
+	for _, keys := range lib.entries {
+		for _, key := range keys {
+			listed[key] = true
+		}
+	}
+
+	tracks := []*Track{}
+	// the XML has no My Tags, so no track passes a tag filter
+	if !opts.keepsTrack(nil) {
+		return tracks, nil
+	}
+	for key, track := range lib.tracks {
+		if listed[key] {
+			continue
+		}
+
+		resolved := track.track()
+		fields.clear(resolved)
+		if !opts.keepsBPM(resolved.BPM) || !opts.keepsAdded(resolved.DateAdded) || !opts.keepsRating(resolved.Rating) {
+			continue
+		}
+		if opts.AnalyzedOnly && len(track.Tempos) == 0 {
+			continue
+		}
+		tracks = append(tracks, resolved)
+	}
+	sortTracks(tracks)
+
+	return tracks, nil
+}
+
+// orphans prefixes content IDs with the library name, as each database
+// numbers its tracks on its own.
+func (m *multiSource) orphans(ctx context.Context, opts CollectOptions) ([]*Track, error) {
+	all := []*Track{}
+	err := m.each(opts, func(lib *namedSource, opts CollectOptions) error {
+		tracks, err := lib.src.orphans(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, track := range tracks {
+			track.ContentID = lib.name + ":" + track.ContentID
+		}
+		all = append(all, tracks...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (s *changedSource) orphans(ctx context.Context, opts CollectOptions) ([]*Track, error) {
+	return s.src.orphans(ctx, opts)
+}
@@ -0,0 +1,547 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// plexClient is a minimal client for the Plex Media Server HTTP API, covering
+// only what is needed to read music libraries and manage playlists.
+type plexClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	// userAgent identifies us in the server's logs
+	userAgent string
+
+	// pathRemaps rewrite rekordbox file paths into the paths Plex sees
+	pathRemaps pathRemaps
+	// limiter spaces out requests, nil for no limit
+	limiter *rateLimiter
+	// sections restricts matching to the music sections with these titles
+	// or keys, tried in this order; empty for all of them
+	sections []string
+
+	machineID string
+	// tracksMu guards tracks, which tracks are matched against concurrently
+	tracksMu sync.Mutex
+	tracks   *plexTrackIndex
+}
+
+type plexMediaContainer struct {
+	MachineIdentifier string          `json:"machineIdentifier"`
+	Directory         []*plexSection  `json:"Directory"`
+	Metadata          []*plexMetadata `json:"Metadata"`
+}
+
+type plexSection struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+type plexMetadata struct {
+	RatingKey        string       `json:"ratingKey"`
+	Type             string       `json:"type"`
+	Title            string       `json:"title"`
+	Summary          string       `json:"summary"`
+	ParentTitle      string       `json:"parentTitle"`
+	GrandparentTitle string       `json:"grandparentTitle"`
+	Duration         int64        `json:"duration"`
+	Smart            bool         `json:"smart"`
+	LeafCount        int          `json:"leafCount"`
+	LibrarySectionID int64        `json:"librarySectionID"`
+	UserRating       float64      `json:"userRating"`
+	Media            []*plexMedia `json:"Media"`
+	Guid             []*plexGuid  `json:"Guid"`
+
+	// OriginalTitle is the track artist where it differs from the album
+	// artist in GrandparentTitle
+	OriginalTitle string     `json:"originalTitle"`
+	Genre         []*plexTag `json:"Genre"`
+}
+
+// plexGuid is one of the external IDs of an item, such as "mbid://..." or,
+// for tracks tagged with one, "isrc://...".
+type plexGuid struct {
+	ID string `json:"id"`
+}
+
+type plexMedia struct {
+	Part []*plexPart `json:"Part"`
+}
+
+type plexPart struct {
+	File string `json:"file"`
+}
+
+// plexItemBatchSize is how many items are added per request. All of them go
+// into the URI query parameter, so it is kept well below common URL length
+// limits.
+const plexItemBatchSize = 200
+
+// plexMaxThrottled is how often a request answered with 429 Too Many Requests
+// is retried before giving up.
+const plexMaxThrottled = 5
+
+func newPlexClient(baseURL, token string) *plexClient {
+	return &plexClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		userAgent:  "rekordbox-plexamp-sync/" + version,
+	}
+}
+
+// configureHTTP sets the user agent sent to Plex, unless empty, and makes the
+// client trust the certificates in the PEM bundle at caFile as well as the
+// system's or, with insecure, any certificate at all. Self-hosted servers are
+// often behind a reverse proxy with a self-signed certificate.
+func (p *plexClient) configureHTTP(insecure bool, caFile, userAgent string) error {
+	if userAgent != "" {
+		p.userAgent = userAgent
+	}
+	if !insecure && caFile == "" {
+		return nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if insecure {
+		slog.Warn("not verifying the Plex server's TLS certificate")
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("reading Plex CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	p.httpClient.Transport = transport
+
+	return nil
+}
+
+func (p *plexClient) do(ctx context.Context, method, path string, query url.Values) (*plexMediaContainer, error) {
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	for throttled := 0; ; throttled++ {
+		if err := p.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Plex-Token", p.token)
+		req.Header.Set("User-Agent", p.userAgent)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && throttled < plexMaxThrottled {
+			resp.Body.Close()
+			delay := retryAfter(resp, time.Second<<throttled)
+			slog.Debug("throttled by plex, retrying", "path", path, "delay", delay)
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return decodePlexResponse(resp, method, path)
+	}
+}
+
+func decodePlexResponse(resp *http.Response, method, path string) (*plexMediaContainer, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("plex %s %s: %s", method, path, resp.Status)
+	}
+
+	var body struct {
+		MediaContainer plexMediaContainer `json:"MediaContainer"`
+	}
+	// some endpoints (e.g. clearing a playlist) reply with an empty body
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("plex %s %s: decoding response: %w", method, path, err)
+	}
+
+	return &body.MediaContainer, nil
+}
+
+// machineIdentifier returns the server's machine identifier, which is needed
+// to build library URIs when adding items to a playlist.
+func (p *plexClient) machineIdentifier(ctx context.Context) (string, error) {
+	if p.machineID != "" {
+		return p.machineID, nil
+	}
+
+	mc, err := p.do(ctx, http.MethodGet, "/identity", nil)
+	if err != nil {
+		return "", err
+	}
+	if mc.MachineIdentifier == "" {
+		return "", fmt.Errorf("plex /identity returned no machine identifier")
+	}
+
+	p.machineID = mc.MachineIdentifier
+	return p.machineID, nil
+}
+
+// musicSections returns the music sections of the server, or only those
+// selected by p.sections, in that order. A section selected more than once,
+// by title and by key say, is returned once.
+func (p *plexClient) musicSections(ctx context.Context) ([]*plexSection, error) {
+	mc, err := p.do(ctx, http.MethodGet, "/library/sections", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := []*plexSection{}
+	for _, section := range mc.Directory {
+		if section.Type == "artist" {
+			sections = append(sections, section)
+		}
+	}
+
+	if len(p.sections) == 0 {
+		return sections, nil
+	}
+
+	selected := make([]*plexSection, 0, len(p.sections))
+	seen := map[string]bool{}
+	for _, want := range p.sections {
+		section := findSection(sections, want)
+		if section == nil {
+			available := make([]string, 0, len(sections))
+			for _, section := range sections {
+				available = append(available, fmt.Sprintf("%q (key %s)", section.Title, section.Key))
+			}
+			return nil, fmt.Errorf("no Plex music section %q, available: %s", want, strings.Join(available, ", "))
+		}
+		if seen[section.Key] {
+			continue
+		}
+		seen[section.Key] = true
+		selected = append(selected, section)
+	}
+
+	return selected, nil
+}
+
+// findSection returns the section whose key or title is want, or nil.
+func findSection(sections []*plexSection, want string) *plexSection {
+	for _, section := range sections {
+		if section.Key == want || strings.EqualFold(section.Title, want) {
+			return section
+		}
+	}
+
+	return nil
+}
+
+func (p *plexClient) sectionTracks(ctx context.Context, sectionKey string) ([]*plexMetadata, error) {
+	// type=10 restricts the listing to tracks
+	mc, err := p.do(ctx, http.MethodGet, "/library/sections/"+sectionKey+"/all", url.Values{
+		"type":         {"10"},
+		"includeGuids": {"1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.Metadata, nil
+}
+
+// searchTracks returns the tracks whose title contains title, of the music
+// section with key sectionKey or, if empty, of all those indexed.
+func (p *plexClient) searchTracks(ctx context.Context, title, sectionKey string) ([]*plexMetadata, error) {
+	index, err := p.trackIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []*plexMetadata{}
+	for _, key := range index.sectionKeys {
+		if sectionKey != "" && key != sectionKey {
+			continue
+		}
+
+		mc, err := p.do(ctx, http.MethodGet, "/library/sections/"+key+"/all", url.Values{
+			"type":  {"10"},
+			"title": {title},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, mc.Metadata...)
+	}
+
+	return tracks, nil
+}
+
+func (p *plexClient) playlists(ctx context.Context) ([]*plexMetadata, error) {
+	mc, err := p.do(ctx, http.MethodGet, "/playlists", url.Values{"playlistType": {"audio"}})
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.Metadata, nil
+}
+
+func (p *plexClient) playlistItems(ctx context.Context, playlistID string) ([]*plexMetadata, error) {
+	mc, err := p.do(ctx, http.MethodGet, "/playlists/"+playlistID+"/items", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.Metadata, nil
+}
+
+func (p *plexClient) itemsURI(ctx context.Context, ratingKeys []string) (string, error) {
+	machineID, err := p.machineIdentifier(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("server://%s/com.plexapp.plugins.library/library/metadata/%s", machineID, strings.Join(ratingKeys, ",")), nil
+}
+
+// batches splits ratingKeys into runs of at most plexItemBatchSize.
+func batches(ratingKeys []string) [][]string {
+	out := [][]string{}
+	for len(ratingKeys) > plexItemBatchSize {
+		out = append(out, ratingKeys[:plexItemBatchSize])
+		ratingKeys = ratingKeys[plexItemBatchSize:]
+	}
+
+	return append(out, ratingKeys)
+}
+
+// addItems appends ratingKeys in order to the playlist or collection at path,
+// one batch per request.
+func (p *plexClient) addItems(ctx context.Context, path string, ratingKeys []string) error {
+	for _, batch := range batches(ratingKeys) {
+		if len(batch) == 0 {
+			continue
+		}
+
+		uri, err := p.itemsURI(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		if _, err := p.do(ctx, http.MethodPut, path, url.Values{"uri": {uri}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createPlaylist creates a new audio playlist containing ratingKeys in order.
+func (p *plexClient) createPlaylist(ctx context.Context, title string, ratingKeys []string) (*plexMetadata, error) {
+	first := batches(ratingKeys)[0]
+	uri, err := p.itemsURI(ctx, first)
+	if err != nil {
+		return nil, err
+	}
+
+	mc, err := p.do(ctx, http.MethodPost, "/playlists", url.Values{
+		"type":  {"audio"},
+		"title": {title},
+		"smart": {"0"},
+		"uri":   {uri},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(mc.Metadata) == 0 {
+		return nil, fmt.Errorf("plex did not return the created playlist %q", title)
+	}
+
+	created := mc.Metadata[0]
+	if err := p.addItems(ctx, "/playlists/"+created.RatingKey+"/items", ratingKeys[len(first):]); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// replacePlaylistItems clears the playlist and re-adds ratingKeys in order.
+func (p *plexClient) replacePlaylistItems(ctx context.Context, playlistID string, ratingKeys []string) error {
+	if _, err := p.do(ctx, http.MethodDelete, "/playlists/"+playlistID+"/items", nil); err != nil {
+		return err
+	}
+
+	return p.addItems(ctx, "/playlists/"+playlistID+"/items", ratingKeys)
+}
+
+// editPlaylist updates playlist attributes such as title and summary.
+func (p *plexClient) editPlaylist(ctx context.Context, playlistID string, attrs url.Values) error {
+	_, err := p.do(ctx, http.MethodPut, "/playlists/"+playlistID, attrs)
+	return err
+}
+
+func (p *plexClient) deletePlaylist(ctx context.Context, playlistID string) error {
+	_, err := p.do(ctx, http.MethodDelete, "/playlists/"+playlistID, nil)
+	return err
+}
+
+// metadata returns the library item with the given rating key.
+func (p *plexClient) metadata(ctx context.Context, ratingKey string) (*plexMetadata, error) {
+	mc, err := p.do(ctx, http.MethodGet, "/library/metadata/"+ratingKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(mc.Metadata) == 0 {
+		return nil, fmt.Errorf("plex has no item %s", ratingKey)
+	}
+
+	return mc.Metadata[0], nil
+}
+
+// collections returns the collections of all music sections.
+func (p *plexClient) collections(ctx context.Context) ([]*plexMetadata, error) {
+	sections, err := p.musicSections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := []*plexMetadata{}
+	for _, section := range sections {
+		mc, err := p.do(ctx, http.MethodGet, "/library/sections/"+section.Key+"/collections", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, mc.Metadata...)
+	}
+
+	return collections, nil
+}
+
+func (p *plexClient) collectionItems(ctx context.Context, collectionID string) ([]*plexMetadata, error) {
+	mc, err := p.do(ctx, http.MethodGet, "/library/collections/"+collectionID+"/children", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.Metadata, nil
+}
+
+// createCollection creates a track collection in the given library section.
+func (p *plexClient) createCollection(ctx context.Context, sectionID int64, title string, ratingKeys []string) (*plexMetadata, error) {
+	first := batches(ratingKeys)[0]
+	uri, err := p.itemsURI(ctx, first)
+	if err != nil {
+		return nil, err
+	}
+
+	mc, err := p.do(ctx, http.MethodPost, "/library/collections", url.Values{
+		// type=10 makes it a collection of tracks
+		"type":      {"10"},
+		"title":     {title},
+		"smart":     {"0"},
+		"sectionId": {strconv.FormatInt(sectionID, 10)},
+		"uri":       {uri},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(mc.Metadata) == 0 {
+		return nil, fmt.Errorf("plex did not return the created collection %q", title)
+	}
+
+	created := mc.Metadata[0]
+	if err := p.addItems(ctx, "/library/collections/"+created.RatingKey+"/items", ratingKeys[len(first):]); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// replaceCollectionItems removes every item of the collection and adds
+// ratingKeys. Collections have no endpoint to clear them in one go.
+func (p *plexClient) replaceCollectionItems(ctx context.Context, collectionID string, ratingKeys []string) error {
+	items, err := p.collectionItems(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if _, err := p.do(ctx, http.MethodDelete, "/library/collections/"+collectionID+"/items/"+item.RatingKey, nil); err != nil {
+			return err
+		}
+	}
+
+	return p.addItems(ctx, "/library/collections/"+collectionID+"/items", ratingKeys)
+}
+
+// editTrack sets fields of the track with ratingKey in the given library
+// section. Field values are passed as e.g. "mood[0].tag.tag".
+func (p *plexClient) editTrack(ctx context.Context, sectionKey, ratingKey string, fields map[string]string) error {
+	query := url.Values{
+		// type=10 addresses tracks
+		"type": {"10"},
+		"id":   {ratingKey},
+	}
+	for k, v := range fields {
+		query.Set(k, v)
+	}
+
+	_, err := p.do(ctx, http.MethodPut, "/library/sections/"+sectionKey+"/all", query)
+	return err
+}
+
+// editCollection sets attributes such as the summary of a collection. Field
+// values are passed as e.g. "summary.value".
+func (p *plexClient) editCollection(ctx context.Context, sectionID int64, collectionID string, attrs url.Values) error {
+	query := url.Values{
+		// type=18 addresses collections
+		"type": {"18"},
+		"id":   {collectionID},
+	}
+	for k, v := range attrs {
+		query[k] = v
+	}
+
+	_, err := p.do(ctx, http.MethodPut, "/library/sections/"+strconv.FormatInt(sectionID, 10)+"/all", query)
+	return err
+}
+
+func (p *plexClient) deleteCollection(ctx context.Context, collectionID string) error {
+	_, err := p.do(ctx, http.MethodDelete, "/library/metadata/"+collectionID, nil)
+	return err
+}
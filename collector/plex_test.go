@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTestPlexServer serves a Plex server with the music sections Music (key
+// 1) and Archive (key 2) and a show section, all without tracks. requests
+// counts the requests by path.
+func newTestPlexServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/library/sections":
+			w.Write([]byte(`{"MediaContainer": {"Directory": [
+				{"key": "1", "title": "Music", "type": "artist"},
+				{"key": "2", "title": "Archive", "type": "artist"},
+				{"key": "3", "title": "Podcasts", "type": "show"}
+			]}}`))
+		case "/library/sections/1/all", "/library/sections/2/all":
+			w.Write([]byte(`{"MediaContainer": {"Metadata": []}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func TestTrackIndexSectionKeys(t *testing.T) {
+	tests := []struct {
+		sections []string
+		keys     []string
+	}{
+		{nil, []string{"1", "2"}},
+		{[]string{"Archive", "Music"}, []string{"2", "1"}},
+		// the same section by title, key and title again
+		{[]string{"Archive", "2", "archive", "Music"}, []string{"2", "1"}},
+	}
+
+	for _, tt := range tests {
+		server, _ := newTestPlexServer(t)
+		plex := newPlexClient(server.URL, "token")
+		plex.sections = tt.sections
+
+		index, err := plex.trackIndex(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(index.sectionKeys, tt.keys) {
+			t.Errorf("sections %v: keys %v, want %v", tt.sections, index.sectionKeys, tt.keys)
+		}
+	}
+}
+
+func TestSearchTracksListsSectionsOnce(t *testing.T) {
+	server, requests := newTestPlexServer(t)
+	plex := newPlexClient(server.URL, "token")
+	plex.sections = []string{"Archive", "Music"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := plex.searchTracks(context.Background(), "Intro", ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := plex.searchTracks(context.Background(), "Intro", "2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := requests["/library/sections"]; n != 1 {
+		t.Errorf("listed the sections %d times, want once", n)
+	}
+	// the index lists each section once, then every search of all sections
+	// queries both and every search of Archive that one
+	if n := requests["/library/sections/1/all"]; n != 1+3 {
+		t.Errorf("queried Music %d times, want 4", n)
+	}
+	if n := requests["/library/sections/2/all"]; n != 1+3+3 {
+		t.Errorf("queried Archive %d times, want 7", n)
+	}
+}
@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out calls so no more than a given number happen per
+// second. A nil *rateLimiter doesn't limit.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter returns a limiter allowing rps calls per second, or nil for
+// no limit if rps is not positive.
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// wait blocks until the next call may be made, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleep(ctx, time.Until(at))
+}
+
+// sleep waits for d, returning early with the context's error if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retryAfter returns how long the Retry-After header of resp asks to wait,
+// given in seconds or as an HTTP date, or fallback if it is missing.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+
+	return fallback
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// plexUserRating converts a rekordbox star rating to Plex's userRating. Both
+// the database's stars and the XML format's 0..255 are accepted. Unrated
+// tracks give 0.
+func plexUserRating(rating int64) float64 {
+	return starsToPlexRating(ratingStars(rating))
+}
+
+// applyRatings copies the rekordbox rating of every matched, rated track in
+// plan to its Plex item, skipping items already rated the same. Tracks unrated
+// in rekordbox are left alone, so ratings given in Plex survive. Failures are
+// logged and don't stop the others.
+func applyRatings(ctx context.Context, plex *plexClient, plan *syncPlan) (int, error) {
+	index, err := plex.trackIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	done := map[string]bool{}
+	set := 0
+	for _, pp := range plan.Playlists {
+		for _, track := range pp.Tracks {
+			if track.UserRating == nil || done[track.RatingKey] {
+				continue
+			}
+			done[track.RatingKey] = true
+
+			if item, ok := index.byKey[track.RatingKey]; ok && item.UserRating == *track.UserRating {
+				continue
+			}
+
+			if err := plex.rate(ctx, track.RatingKey, *track.UserRating); err != nil {
+				slog.Warn("failed to set rating", "title", track.Title, "rating", *track.UserRating, "error", err)
+				continue
+			}
+			set++
+		}
+	}
+
+	return set, nil
+}
+
+// rate sets the userRating (0..10) of the library item with ratingKey.
+func (p *plexClient) rate(ctx context.Context, ratingKey string, rating float64) error {
+	_, err := p.do(ctx, http.MethodPut, "/:/rate", url.Values{
+		"key":        {ratingKey},
+		"identifier": {"com.plexapp.plugins.library"},
+		"rating":     {strconv.FormatFloat(rating, 'f', -1, 64)},
+	})
+	return err
+}
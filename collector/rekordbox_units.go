@@ -0,0 +1,73 @@
+package collector
+
+import "math"
+
+// rekordbox stores several numbers in scaled units. Every conversion goes
+// through the helpers here, so each scale is written down once.
+
+// bpmScale is the factor tempos are stored multiplied by, both in the
+// database and in the beat grids of the analysis files.
+const bpmScale = 100
+
+// Star ratings run from 0 (unrated) to maxStars. The database stores the
+// number of stars, the XML format multiples of xmlStarStep.
+const (
+	maxStars    = 5
+	xmlStarStep = 51
+)
+
+// bpmFromStored converts a stored tempo, e.g. 12800, to BPM, 128.
+func bpmFromStored(stored int64) float64 {
+	return float64(stored) / bpmScale
+}
+
+// bpmToStored is the inverse of bpmFromStored, rounding to the nearest unit.
+func bpmToStored(bpm float64) int64 {
+	return int64(math.Round(bpm * bpmScale))
+}
+
+// msFromSamples converts a position or length given in samples to
+// milliseconds, or 0 if the sample rate is unknown.
+func msFromSamples(samples, sampleRate int64) float64 {
+	if sampleRate <= 0 {
+		return 0
+	}
+
+	return float64(samples) * 1000 / float64(sampleRate)
+}
+
+// starsFromXMLRating converts the XML format's 0..255 rating to stars.
+func starsFromXMLRating(rating int64) int64 {
+	return clampStars(rating / xmlStarStep)
+}
+
+// ratingStars returns the stars of a rekordbox rating given either as stars,
+// as the database stores it, or on the XML format's 0..255 scale.
+func ratingStars(rating int64) int64 {
+	if rating > maxStars {
+		return starsFromXMLRating(rating)
+	}
+
+	return rating
+}
+
+// starsToXMLRating is the inverse of starsFromXMLRating.
+func starsToXMLRating(stars int64) int64 {
+	return clampStars(stars) * xmlStarStep
+}
+
+// starsToPlexRating converts stars to Plex's userRating, which runs from 0
+// to 10 in half stars.
+func starsToPlexRating(stars int64) float64 {
+	return float64(clampStars(stars) * 2)
+}
+
+// starsToITunesRating converts stars to the iTunes 0..100 rating, 20 per
+// star.
+func starsToITunesRating(stars int64) int64 {
+	return clampStars(stars) * 20
+}
+
+func clampStars(stars int64) int64 {
+	return max(0, min(stars, maxStars))
+}
@@ -0,0 +1,171 @@
+package collector
+
+import "testing"
+
+func TestBPMStored(t *testing.T) {
+	tests := []struct {
+		stored int64
+		bpm    float64
+	}{
+		{0, 0},
+		{12800, 128},
+		{12850, 128.5},
+		{17475, 174.75},
+		{9001, 90.01},
+	}
+
+	for _, tt := range tests {
+		if got := bpmFromStored(tt.stored); got != tt.bpm {
+			t.Errorf("bpmFromStored(%d) = %v, want %v", tt.stored, got, tt.bpm)
+		}
+		if got := bpmToStored(tt.bpm); got != tt.stored {
+			t.Errorf("bpmToStored(%v) = %d, want %d", tt.bpm, got, tt.stored)
+		}
+	}
+}
+
+func TestBPMToStoredRounds(t *testing.T) {
+	tests := []struct {
+		bpm    float64
+		stored int64
+	}{
+		{128.004, 12800},
+		{128.005, 12801},
+		{127.996, 12800},
+	}
+
+	for _, tt := range tests {
+		if got := bpmToStored(tt.bpm); got != tt.stored {
+			t.Errorf("bpmToStored(%v) = %d, want %d", tt.bpm, got, tt.stored)
+		}
+	}
+}
+
+func TestMSFromSamples(t *testing.T) {
+	tests := []struct {
+		samples, sampleRate int64
+		ms                  float64
+	}{
+		{0, 44100, 0},
+		{44100, 44100, 1000},
+		{22050, 44100, 500},
+		{48000 * 90, 48000, 90000},
+		{441, 44100, 10},
+		// an unknown sample rate gives 0 rather than dividing by it
+		{44100, 0, 0},
+		{44100, -1, 0},
+	}
+
+	for _, tt := range tests {
+		if got := msFromSamples(tt.samples, tt.sampleRate); got != tt.ms {
+			t.Errorf("msFromSamples(%d, %d) = %v, want %v", tt.samples, tt.sampleRate, got, tt.ms)
+		}
+	}
+}
+
+func TestStarsFromXMLRating(t *testing.T) {
+	tests := []struct {
+		rating, stars int64
+	}{
+		{0, 0},
+		{51, 1},
+		{102, 2},
+		{153, 3},
+		{204, 4},
+		{255, 5},
+		// values between the steps round down
+		{50, 0},
+		{203, 3},
+		{-51, 0},
+		{510, 5},
+	}
+
+	for _, tt := range tests {
+		if got := starsFromXMLRating(tt.rating); got != tt.stars {
+			t.Errorf("starsFromXMLRating(%d) = %d, want %d", tt.rating, got, tt.stars)
+		}
+	}
+}
+
+func TestStarsToXMLRating(t *testing.T) {
+	tests := []struct {
+		stars, rating int64
+	}{
+		{0, 0},
+		{1, 51},
+		{3, 153},
+		{5, 255},
+		{-1, 0},
+		{6, 255},
+	}
+
+	for _, tt := range tests {
+		if got := starsToXMLRating(tt.stars); got != tt.rating {
+			t.Errorf("starsToXMLRating(%d) = %d, want %d", tt.stars, got, tt.rating)
+		}
+	}
+}
+
+func TestRatingStars(t *testing.T) {
+	tests := []struct {
+		rating, stars int64
+	}{
+		// the database's stars pass through
+		{0, 0},
+		{1, 1},
+		{4, 4},
+		{5, 5},
+		// anything larger is on the XML scale
+		{51, 1},
+		{102, 2},
+		{153, 3},
+		{204, 4},
+		{255, 5},
+		{1000, 5},
+	}
+
+	for _, tt := range tests {
+		if got := ratingStars(tt.rating); got != tt.stars {
+			t.Errorf("ratingStars(%d) = %d, want %d", tt.rating, got, tt.stars)
+		}
+	}
+}
+
+func TestStarsToPlexRating(t *testing.T) {
+	tests := []struct {
+		stars int64
+		plex  float64
+	}{
+		{0, 0},
+		{1, 2},
+		{3, 6},
+		{5, 10},
+		{-2, 0},
+		{7, 10},
+	}
+
+	for _, tt := range tests {
+		if got := starsToPlexRating(tt.stars); got != tt.plex {
+			t.Errorf("starsToPlexRating(%d) = %v, want %v", tt.stars, got, tt.plex)
+		}
+	}
+}
+
+func TestClampStars(t *testing.T) {
+	tests := []struct {
+		stars, clamped int64
+	}{
+		{-1, 0},
+		{0, 0},
+		{3, 3},
+		{5, 5},
+		{6, 5},
+		{255, 5},
+	}
+
+	for _, tt := range tests {
+		if got := clampStars(tt.stars); got != tt.clamped {
+			t.Errorf("clampStars(%d) = %d, want %d", tt.stars, got, tt.clamped)
+		}
+	}
+}
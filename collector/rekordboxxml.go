@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// The rekordbox XML format, as written by rekordbox's "Export Collection in
+// xml format" and read by most DJ software.
+type xmlDJPlaylists struct {
+	XMLName    xml.Name      `xml:"DJ_PLAYLISTS"`
+	Version    string        `xml:"Version,attr"`
+	Product    xmlProduct    `xml:"PRODUCT"`
+	Collection xmlCollection `xml:"COLLECTION"`
+	Playlists  xmlPlaylists  `xml:"PLAYLISTS"`
+}
+
+type xmlProduct struct {
+	Name    string `xml:"Name,attr"`
+	Version string `xml:"Version,attr"`
+	Company string `xml:"Company,attr,omitempty"`
+}
+
+type xmlCollection struct {
+	Entries int         `xml:"Entries,attr"`
+	Tracks  []*xmlTrack `xml:"TRACK"`
+}
+
+type xmlTrack struct {
+	TrackID    string `xml:"TrackID,attr"`
+	Name       string `xml:"Name,attr"`
+	Artist     string `xml:"Artist,attr"`
+	Album      string `xml:"Album,attr"`
+	Genre      string `xml:"Genre,attr"`
+	Kind       string `xml:"Kind,attr"`
+	TotalTime  int64  `xml:"TotalTime,attr"`
+	AverageBpm string `xml:"AverageBpm,attr"`
+	BitRate    int64  `xml:"BitRate,attr"`
+	SampleRate int64  `xml:"SampleRate,attr"`
+	Comments   string `xml:"Comments,attr"`
+	PlayCount  int    `xml:"PlayCount,attr"`
+	DateAdded  string `xml:"DateAdded,attr"`
+	// Rating is 0 to 255 in steps of xmlStarStep per star
+	Rating   int64  `xml:"Rating,attr"`
+	Location string `xml:"Location,attr"`
+	Tonality string `xml:"Tonality,attr"`
+	Label    string `xml:"Label,attr"`
+
+	Tempos        []*xmlTempo        `xml:"TEMPO"`
+	PositionMarks []*xmlPositionMark `xml:"POSITION_MARK"`
+}
+
+type xmlTempo struct {
+	// Inizio is the position in seconds
+	Inizio  string `xml:"Inizio,attr"`
+	Bpm     string `xml:"Bpm,attr"`
+	Metro   string `xml:"Metro,attr"`
+	Battito int    `xml:"Battito,attr"`
+}
+
+type xmlPositionMark struct {
+	Name  string `xml:"Name,attr"`
+	Type  int    `xml:"Type,attr"`
+	Start string `xml:"Start,attr"`
+	// Num is the hot cue pad from 0, or -1 for memory cues
+	Num int `xml:"Num,attr"`
+}
+
+type xmlPlaylists struct {
+	Root *xmlNode `xml:"NODE"`
+}
+
+// xmlNode is a folder (Type 0) or playlist (Type 1).
+type xmlNode struct {
+	Type    int           `xml:"Type,attr"`
+	Name    string        `xml:"Name,attr"`
+	Count   *int          `xml:"Count,attr"`
+	KeyType *int          `xml:"KeyType,attr"`
+	Entries *int          `xml:"Entries,attr"`
+	Nodes   []*xmlNode    `xml:"NODE"`
+	Tracks  []*xmlNodeKey `xml:"TRACK"`
+}
+
+type xmlNodeKey struct {
+	Key string `xml:"Key,attr"`
+}
+
+// writeRekordboxXML writes the playlists of tree and the tracks they contain
+// as a rekordbox XML collection. Tracks are keyed by their content ID.
+func writeRekordboxXML(w io.Writer, tree []*PlaylistNode) error {
+	doc := &xmlDJPlaylists{
+		Version: "1.0.0",
+		Product: xmlProduct{Name: "rekordbox-plexamp-sync", Version: version},
+	}
+
+	seen := map[string]bool{}
+	var addTracks func(nodes []*PlaylistNode)
+	addTracks = func(nodes []*PlaylistNode) {
+		for _, node := range nodes {
+			for _, track := range node.Tracks {
+				if !seen[track.ContentID] {
+					seen[track.ContentID] = true
+					doc.Collection.Tracks = append(doc.Collection.Tracks, newXMLTrack(track))
+				}
+			}
+			addTracks(node.Children)
+		}
+	}
+	addTracks(tree)
+	doc.Collection.Entries = len(doc.Collection.Tracks)
+
+	doc.Playlists.Root = newXMLFolder("ROOT", tree)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newXMLFolder(name string, children []*PlaylistNode) *xmlNode {
+	count := len(children)
+	folder := &xmlNode{Type: 0, Name: name, Count: &count}
+
+	for _, child := range children {
+		if child.Kind == nodeKindFolder {
+			folder.Nodes = append(folder.Nodes, newXMLFolder(child.Name, child.Children))
+			continue
+		}
+
+		entries, keyType := len(child.Tracks), 0
+		playlist := &xmlNode{Type: 1, Name: child.Name, KeyType: &keyType, Entries: &entries}
+		for _, track := range child.Tracks {
+			playlist.Tracks = append(playlist.Tracks, &xmlNodeKey{Key: track.ContentID})
+		}
+		folder.Nodes = append(folder.Nodes, playlist)
+	}
+
+	return folder
+}
+
+func newXMLTrack(track *Track) *xmlTrack {
+	t := &xmlTrack{
+		TrackID:    track.ContentID,
+		Name:       track.Title,
+		Artist:     track.ArtistName,
+		Album:      track.AlbumName,
+		Genre:      track.GenreName,
+		Kind:       track.FileType + " File",
+		TotalTime:  track.Length,
+		AverageBpm: fmt.Sprintf("%.2f", track.BPM),
+		BitRate:    track.BitRate,
+		SampleRate: track.SampleRate,
+		Comments:   track.Comment,
+		PlayCount:  track.PlayCount,
+		Rating:     starsToXMLRating(track.Rating),
+		Location:   fileURL(track.FolderPath),
+		Tonality:   track.KeyName,
+	}
+	if track.DateAdded != nil {
+		t.DateAdded = track.DateAdded.Format("2006-01-02")
+	}
+	if track.LabelName != nil {
+		t.Label = *track.LabelName
+	}
+
+	for _, entry := range track.BeatGrid {
+		t.Tempos = append(t.Tempos, &xmlTempo{
+			Inizio:  fmt.Sprintf("%.3f", entry.PositionMs/1000),
+			Bpm:     fmt.Sprintf("%.2f", entry.BPM),
+			Metro:   "4/4",
+			Battito: entry.Beat,
+		})
+	}
+
+	for _, cue := range track.Cues {
+		mark := &xmlPositionMark{
+			Name:  cue.Comment,
+			Start: fmt.Sprintf("%.3f", cue.PositionMs/1000),
+			Num:   -1,
+		}
+		if cue.Type == cueTypeHot {
+			mark.Num = strings.Index("ABCDEFGH", cue.Slot)
+		}
+		t.PositionMarks = append(t.PositionMarks, mark)
+	}
+
+	return t
+}
+
+// fileURL returns path as the file://localhost/ URL the XML format uses for
+// track locations. Windows paths become file://localhost/C:/...
+func fileURL(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return (&url.URL{Scheme: "file", Host: "localhost", Path: path}).String()
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// RelatedTrack is another track rekordbox lists as related, i.e. in the same
+// Related Tracks list.
+type RelatedTrack struct {
+	ContentID  string `json:"content_id"`
+	ArtistName string `json:"artist_name"`
+	Title      string `json:"title"`
+}
+
+// loadRelated reads every Related Tracks entry in one query and indexes, for
+// each content ID, the other tracks that share a list with it. Lists defined
+// only by criteria have no entries, so they relate nothing.
+func (r *resolver) loadRelated(ctx context.Context) error {
+	entries, err := r.client.AllDjmdSongRelatedTracks(ctx)
+	if err != nil {
+		return fmt.Errorf("loading related tracks: %w", err)
+	}
+
+	lists := map[string][]string{}
+	for _, entry := range entries {
+		if entry.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		listID := entry.RelatedTracksID.String()
+		lists[listID] = append(lists[listID], entry.ContentID.String())
+	}
+
+	r.related = map[string][]string{}
+	for _, contentIDs := range lists {
+		for _, contentID := range contentIDs {
+			for _, other := range contentIDs {
+				if other != contentID {
+					r.related[contentID] = append(r.related[contentID], other)
+				}
+			}
+		}
+	}
+
+	for contentID, others := range r.related {
+		sort.Slice(others, func(i, j int) bool { return lessID(others[i], others[j]) })
+		r.related[contentID] = dedupeSorted(others)
+	}
+
+	return nil
+}
+
+// dedupeSorted drops repeated elements from the sorted ids.
+func dedupeSorted(ids []string) []string {
+	out := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			out = append(out, id)
+		}
+	}
+
+	return out
+}
+
+// relatedTracks returns the tracks related to content, never nil. Related
+// content that is missing or deleted is left out.
+func (r *resolver) relatedTracks(ctx context.Context, content *rekordbox.DjmdContent) []*RelatedTrack {
+	tracks := []*RelatedTrack{}
+	for _, id := range r.related[content.ID.String()] {
+		other, ok := r.contents[id]
+		if !ok || other.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		tracks = append(tracks, &RelatedTrack{
+			ContentID:  id,
+			ArtistName: r.artistName(ctx, other),
+			Title:      other.Title.String(),
+		})
+	}
+
+	return tracks
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+const (
+	reasonContentMissing = "content row missing"
+	reasonFileMissing    = "file missing on disk"
+)
+
+// unresolvedTrack is a playlist entry that could not be resolved to a playable
+// file, reported so users can fix their library.
+type unresolvedTrack struct {
+	Playlist   string `json:"playlist"`
+	TrackNo    int64  `json:"track_no"`
+	ContentID  string `json:"content_id"`
+	Artist     string `json:"artist"`
+	Title      string `json:"title"`
+	FolderPath string `json:"folder_path"`
+	Reason     string `json:"reason"`
+}
+
+// checkContentFile reports the content as unresolved when the file rekordbox
+// points at no longer exists, and otherwise returns the file's info. With
+// stat or check files enabled, the file is looked for at its remapped path,
+// and with check files at the target of any symlinks on it.
+func checkContentFile(ctx context.Context, r *resolver, playlistName string, trackNo int64, content *rekordbox.DjmdContent) (os.FileInfo, *unresolvedTrack) {
+	path := content.FolderPath.String()
+	if r.statFiles || r.checkFiles {
+		path = r.fileRemaps.apply(path)
+	}
+	if r.checkFiles {
+		// a dangling link fails here and is reported missing below
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info, nil
+	}
+
+	return nil, &unresolvedTrack{
+		Playlist:   playlistName,
+		TrackNo:    trackNo,
+		ContentID:  content.ID.String(),
+		Artist:     r.artistName(ctx, content),
+		Title:      content.Title.String(),
+		FolderPath: content.FolderPath.String(),
+		Reason:     reasonFileMissing,
+	}
+}
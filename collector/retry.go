@@ -0,0 +1,199 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+// Defaults for CollectOptions.DBRetries and DBRetryDelaySeconds.
+const (
+	defaultDBRetries    = 3
+	defaultDBRetryDelay = 100 * time.Millisecond
+)
+
+func (opts CollectOptions) dbRetries() int {
+	if opts.DBRetries < 0 {
+		return 0
+	}
+	if opts.DBRetries == 0 {
+		return defaultDBRetries
+	}
+
+	return opts.DBRetries
+}
+
+func (opts CollectOptions) dbRetryDelay() time.Duration {
+	if opts.DBRetryDelaySeconds > 0 {
+		return time.Duration(opts.DBRetryDelaySeconds * float64(time.Second))
+	}
+
+	return defaultDBRetryDelay
+}
+
+func (opts CollectOptions) queryTimeout() time.Duration {
+	return time.Duration(opts.QueryTimeoutSeconds * float64(time.Second))
+}
+
+// retrying wraps client so queries failing because the database is locked are
+// retried, and each query is cut off after the query timeout, as configured
+// by opts.
+func (opts CollectOptions) retrying(client libraryClient) libraryClient {
+	if opts.dbRetries() == 0 && opts.queryTimeout() <= 0 {
+		return client
+	}
+
+	return &retryClient{client: client, retries: opts.dbRetries(), delay: opts.dbRetryDelay(), timeout: opts.queryTimeout()}
+}
+
+// errQueryTimeout is returned by queries cut off by the query timeout.
+var errQueryTimeout = errors.New("query timed out")
+
+// isLocked reports whether err is SQLite giving up on a lock held by someone
+// else, typically rekordbox itself.
+func isLocked(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "sqlite_busy")
+}
+
+// retryClient is a libraryClient that retries locked queries with exponential
+// backoff: the first retry waits delay, each following one twice as long.
+// With a timeout, every attempt gets that long before it is abandoned with
+// errQueryTimeout.
+type retryClient struct {
+	client  libraryClient
+	retries int
+	delay   time.Duration
+	timeout time.Duration
+}
+
+func retry[T any](ctx context.Context, c *retryClient, query func(ctx context.Context) (T, error)) (T, error) {
+	delay := c.delay
+	for attempt := 0; ; attempt++ {
+		v, err := runQuery(ctx, c.timeout, query)
+		if attempt >= c.retries || !isLocked(err) {
+			return v, err
+		}
+
+		slog.Debug("database is locked, retrying", "attempt", attempt+1, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return v, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// runQuery runs query once, cut off after timeout unless that is zero.
+func runQuery[T any](ctx context.Context, timeout time.Duration, query func(ctx context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return query(ctx)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	v, err := query(queryCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+		return v, fmt.Errorf("%w after %s", errQueryTimeout, timeout)
+	}
+
+	return v, err
+}
+
+func (c *retryClient) AllDjmdPlaylist(ctx context.Context) ([]*rekordbox.DjmdPlaylist, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdPlaylist, error) { return c.client.AllDjmdPlaylist(ctx) })
+}
+
+func (c *retryClient) DjmdPlaylistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdPlaylist, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdPlaylist, error) { return c.client.DjmdPlaylistByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdSongPlaylistByPlaylistID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdSongPlaylist, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdSongPlaylist, error) {
+		return c.client.DjmdSongPlaylistByPlaylistID(ctx, id)
+	})
+}
+
+func (c *retryClient) AllDjmdSongPlaylist(ctx context.Context) ([]*rekordbox.DjmdSongPlaylist, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdSongPlaylist, error) {
+		return c.client.AllDjmdSongPlaylist(ctx)
+	})
+}
+
+func (c *retryClient) AllDjmdContent(ctx context.Context) ([]*rekordbox.DjmdContent, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdContent, error) { return c.client.AllDjmdContent(ctx) })
+}
+
+func (c *retryClient) DjmdContentByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdContent, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdContent, error) { return c.client.DjmdContentByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdArtistByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdArtist, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdArtist, error) { return c.client.DjmdArtistByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdAlbumByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdAlbum, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdAlbum, error) { return c.client.DjmdAlbumByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdGenreByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdGenre, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdGenre, error) { return c.client.DjmdGenreByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdKeyByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdKey, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdKey, error) { return c.client.DjmdKeyByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdColorByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdColor, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdColor, error) { return c.client.DjmdColorByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdLabelByID(ctx context.Context, id nulltype.NullString) (*rekordbox.DjmdLabel, error) {
+	return retry(ctx, c, func(ctx context.Context) (*rekordbox.DjmdLabel, error) { return c.client.DjmdLabelByID(ctx, id) })
+}
+
+func (c *retryClient) DjmdCueByContentID(ctx context.Context, id nulltype.NullString) ([]*rekordbox.DjmdCue, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdCue, error) { return c.client.DjmdCueByContentID(ctx, id) })
+}
+
+func (c *retryClient) AllDjmdMyTag(ctx context.Context) ([]*rekordbox.DjmdMyTag, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdMyTag, error) { return c.client.AllDjmdMyTag(ctx) })
+}
+
+func (c *retryClient) AllDjmdSongMyTag(ctx context.Context) ([]*rekordbox.DjmdSongMyTag, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdSongMyTag, error) { return c.client.AllDjmdSongMyTag(ctx) })
+}
+
+func (c *retryClient) AllDjmdHistory(ctx context.Context) ([]*rekordbox.DjmdHistory, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdHistory, error) { return c.client.AllDjmdHistory(ctx) })
+}
+
+func (c *retryClient) AllDjmdSongHistory(ctx context.Context) ([]*rekordbox.DjmdSongHistory, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdSongHistory, error) {
+		return c.client.AllDjmdSongHistory(ctx)
+	})
+}
+
+func (c *retryClient) AllDjmdSongRelatedTracks(ctx context.Context) ([]*rekordbox.DjmdSongRelatedTracks, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdSongRelatedTracks, error) {
+		return c.client.AllDjmdSongRelatedTracks(ctx)
+	})
+}
+
+func (c *retryClient) AllDjmdProperty(ctx context.Context) ([]*rekordbox.DjmdProperty, error) {
+	return retry(ctx, c, func(ctx context.Context) ([]*rekordbox.DjmdProperty, error) { return c.client.AllDjmdProperty(ctx) })
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/mattn/go-nulltype"
+)
+
+// isSchemaMismatch reports whether err is SQLite rejecting a query because
+// the table or column it reads doesn't exist in this version of rekordbox's
+// schema.
+func isSchemaMismatch(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "no such table") || strings.Contains(msg, "no such column")
+}
+
+// loadOptional loads what the resolver reads from tables that not every
+// rekordbox schema has. A feature whose table or columns are missing is
+// disabled rather than failing the collection, with a single warning naming
+// every feature left out; other errors are returned.
+func (r *resolver) loadOptional(ctx context.Context) error {
+	features := []struct {
+		name string
+		load func(ctx context.Context) error
+		// wanted is false for features no selected field needs
+		wanted bool
+	}{
+		{"My Tags", r.loadMyTags, r.fields.has(fieldMyTags) || r.tagFilter},
+		{"play history", r.loadHistory, r.fields.has(fieldHistory)},
+		{"related tracks", r.loadRelated, r.fields.has(fieldRelated)},
+		{"cues", r.probeCues, r.fields.has(fieldCues)},
+	}
+
+	disabled := []string{}
+	for _, feature := range features {
+		if !feature.wanted {
+			continue
+		}
+
+		err := feature.load(ctx)
+		if isSchemaMismatch(err) {
+			slog.Debug("feature unavailable", "feature", feature.name, "error", err)
+			disabled = append(disabled, feature.name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(disabled) > 0 {
+		slog.Warn("some features are unavailable on this rekordbox schema", "disabled", strings.Join(disabled, ", "), "db_version", r.dbVersion(ctx))
+	}
+
+	return nil
+}
+
+// probeCues queries the cues of a content ID no row has, to find out whether
+// the cue table fits the schema. Without it, every track would log the same
+// failure. Other errors are left to the per-track lookups, which only warn.
+func (r *resolver) probeCues(ctx context.Context) error {
+	_, err := r.client.DjmdCueByContentID(ctx, nulltype.NullStringOf(""))
+	if !isSchemaMismatch(err) {
+		return nil
+	}
+
+	r.noCues = true
+	return err
+}
+
+// dbVersion returns the schema version rekordbox recorded in the database,
+// empty if it can't be read.
+func (r *resolver) dbVersion(ctx context.Context) string {
+	properties, err := r.client.AllDjmdProperty(ctx)
+	if err != nil || len(properties) == 0 {
+		return ""
+	}
+
+	return properties[0].DBVersion.String()
+}
@@ -0,0 +1,229 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+)
+
+// The serve command answers ListPlaylists requests on a Unix socket, for
+// hosts that would rather read the playlists one at a time than take the
+// whole export as one string across the shared library's boundary.
+//
+// Every message is a frame: a 4-byte big-endian length, then that many
+// bytes, the first of which is the frame type and the rest its body. The
+// request body is JSON, the answers are the protobuf messages of
+// serve.proto. A client sends a frameListPlaylists with the options
+// getPlaylists takes and reads back a frameHeader, a framePlaylist per
+// playlist as soon as it is collected and a frameEnd. A frameError ends the
+// answer in place of the next frame if the options are invalid or
+// collecting fails. A connection may send requests one after another; each
+// is answered in full before the next is read.
+const (
+	// frameListPlaylists carries a CollectOptions object, or nothing for
+	// the defaults
+	frameListPlaylists byte = 1
+	// frameHeader carries a Header
+	frameHeader byte = 2
+	// framePlaylist carries a Playlist
+	framePlaylist byte = 3
+	// frameEnd carries a Trailer and ends the answer
+	frameEnd byte = 4
+	// frameError carries an Error and ends the answer
+	frameError byte = 5
+)
+
+// maxRequestFrame is the largest request frame read, well above any options
+// object, so a client speaking another protocol isn't buffered forever.
+const maxRequestFrame = 1 << 20
+
+// serveError is the body of a frameError. Status is what getLastStatus would
+// report for it.
+type serveError struct {
+	Status int
+	Error  string
+}
+
+// libraryOpener opens the library at opts.OptionsPath and calls fn with it,
+// as withLibrary does.
+type libraryOpener func(opts *CollectOptions, fn func(client libraryClient) error) error
+
+// listenSocket listens on the Unix socket at path, readable by the current
+// user only. A socket left behind by a server that didn't stop cleanly is
+// replaced; any other file at path is an error.
+func listenSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// servePlaylists answers the connections accepted on l until ctx is done,
+// each in its own goroutine, reading the libraries through open. Requests
+// that leave options_path empty read the library at defaults.OptionsPath.
+func servePlaylists(ctx context.Context, l net.Listener, defaults CollectOptions, open libraryOpener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, conn, defaults, open)
+		}()
+	}
+}
+
+// serveConn answers the requests on conn until the client hangs up or ctx is
+// done.
+func serveConn(ctx context.Context, conn net.Conn, defaults CollectOptions, open libraryOpener) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		typ, body, err := readFrame(r, maxRequestFrame)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.Warn("dropping connection", "error", err)
+			}
+			return
+		}
+
+		if typ != frameListPlaylists {
+			writeServeError(w, fmt.Errorf("unknown request frame type %d", typ))
+			return
+		}
+		if err := answerListPlaylists(ctx, w, body, defaults, open); err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("dropping connection", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// answerListPlaylists collects the playlists the options in body select and
+// writes each to w as soon as it is collected. Only failing to write is
+// returned; failing to collect is answered with a frameError.
+func answerListPlaylists(ctx context.Context, w *bufio.Writer, body []byte, defaults CollectOptions, open libraryOpener) error {
+	opts, err := ParseCollectOptions(string(body))
+	if err != nil {
+		return writeServeError(w, err)
+	}
+	if opts.OptionsPath == "" {
+		opts.OptionsPath = defaults.OptionsPath
+	}
+
+	if err := writeFrame(w, frameHeader, protoHeader(newEnvelopeHead())); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// a failed write is kept apart from the collection failing, which is
+	// still answered
+	var writeErr error
+	count := 0
+	opts.emit = func(pl *Playlist) error {
+		if writeErr = writeFrame(w, framePlaylist, protoPlaylist(pl)); writeErr == nil {
+			writeErr = w.Flush()
+		}
+		count++
+		return writeErr
+	}
+
+	opts.stats = newRunStats()
+	stopCapture := captureWarnings()
+	err = open(&opts, func(client libraryClient) error {
+		_, err := collect(ctx, client, opts)
+		return err
+	})
+	warnings := stopCapture()
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return writeServeError(w, err)
+	}
+
+	trailer := newEnvelopeTrailer(opts.stats)
+	trailer.Warnings = warnings
+	if err := writeFrame(w, frameEnd, protoTrailer(count, trailer)); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func writeServeError(w *bufio.Writer, err error) error {
+	if err := writeFrame(w, frameError, protoServeError(&serveError{Status: ErrorStatus(err), Error: err.Error()})); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func writeFrame(w io.Writer, typ byte, body []byte) error {
+	head := make([]byte, 5)
+	binary.BigEndian.PutUint32(head, uint32(len(body)+1))
+	head[4] = typ
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+
+	return err
+}
+
+// readFrame reads a frame of at most limit bytes from r.
+func readFrame(r io.Reader, limit uint32) (byte, []byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(head)
+	if n == 0 || n > limit {
+		return 0, nil, fmt.Errorf("frame of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, fmt.Errorf("reading frame: %w", err)
+	}
+
+	return b[0], b[1:], nil
+}
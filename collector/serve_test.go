@@ -0,0 +1,222 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// protoField is a field read by decodeProto, holding the value of a varint
+// or fixed64 field in n and that of a length-delimited one in b.
+type protoField struct {
+	num int
+	n   uint64
+	b   []byte
+}
+
+func decodeProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(b) > 0 {
+		key, k := binary.Uvarint(b)
+		if k <= 0 {
+			t.Fatalf("malformed tag in %x", b)
+		}
+		b = b[k:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case protoVarint:
+			f.n, k = binary.Uvarint(b)
+			if k <= 0 {
+				t.Fatalf("malformed varint in %x", b)
+			}
+			b = b[k:]
+		case protoFixed64:
+			f.n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoBytes:
+			n, k := binary.Uvarint(b)
+			if k <= 0 || uint64(len(b)-k) < n {
+				t.Fatalf("malformed length in %x", b)
+			}
+			f.b, b = b[k:k+int(n)], b[k+int(n):]
+		default:
+			t.Fatalf("wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// protoGet returns the fields numbered num.
+func protoGet(fields []protoField, num int) []protoField {
+	var found []protoField
+	for _, f := range fields {
+		if f.num == num {
+			found = append(found, f)
+		}
+	}
+
+	return found
+}
+
+func TestProtoMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(m *protoMessage)
+		want  []byte
+	}{
+		{"varint", func(m *protoMessage) { m.int(1, 150) }, []byte{0x08, 0x96, 0x01}},
+		{"zero varint", func(m *protoMessage) { m.int(1, 0) }, nil},
+		{"explicit zero", func(m *protoMessage) { m.optInt(1, 0) }, []byte{0x08, 0x00}},
+		{"negative", func(m *protoMessage) { m.int(1, -1) }, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"string", func(m *protoMessage) { m.string(2, "testing") }, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"empty string", func(m *protoMessage) { m.string(2, "") }, nil},
+		{"double", func(m *protoMessage) { m.double(7, 1) }, []byte{0x39, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{"packed", func(m *protoMessage) { m.packedInts(6, []int64{3, 270}) }, []byte{0x32, 0x03, 0x03, 0x8e, 0x02}},
+		{"empty message", func(m *protoMessage) { m.message(3, nil) }, []byte{0x1a, 0x00}},
+	}
+
+	for _, tt := range tests {
+		var m protoMessage
+		tt.write(&m)
+		if !bytes.Equal(m, tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, []byte(m), tt.want)
+		}
+	}
+}
+
+// fakeOpener opens f for every request.
+func fakeOpener(f *fakeLibrary) libraryOpener {
+	return func(opts *CollectOptions, fn func(client libraryClient) error) error {
+		if err := opts.compileNameFilters(); err != nil {
+			return err
+		}
+		return fn(f)
+	}
+}
+
+// dialTestServer serves newTestLibrary on a socket and returns a connection
+// to it.
+func dialTestServer(t *testing.T) net.Conn {
+	path := filepath.Join(t.TempDir(), "serve.sock")
+	l, err := listenSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- servePlaylists(ctx, l, CollectOptions{}, fakeOpener(newTestLibrary())) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestServeListPlaylists(t *testing.T) {
+	conn := dialTestServer(t)
+	r := bufio.NewReader(conn)
+
+	// the second request is answered on the same connection after the first
+	tests := []struct {
+		request string
+		names   []string
+	}{
+		{`{"include_prefixes": ["Sets"]}`, []string{"Sets - Peak", "Sets - Warmup"}},
+		{`{"include_prefixes": ["Sets"], "sort": "seq"}`, []string{"Sets - Warmup", "Sets - Peak"}},
+	}
+	for _, tt := range tests {
+		request := tt.request
+		if err := writeFrame(conn, frameListPlaylists, []byte(request)); err != nil {
+			t.Fatal(err)
+		}
+
+		typ, body, err := readFrame(r, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := decodeProto(t, body)
+		if typ != frameHeader || len(protoGet(header, 1)) != 1 || protoGet(header, 1)[0].n != schemaVersion {
+			t.Fatalf("%s: first frame of type %d with schema version %v, want a header", request, typ, protoGet(header, 1))
+		}
+
+		var names []string
+		tracks := map[string][]string{}
+		for {
+			typ, body, err = readFrame(r, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != framePlaylist {
+				break
+			}
+			pl := decodeProto(t, body)
+			name := string(protoGet(pl, 1)[0].b)
+			names = append(names, name)
+			for _, track := range protoGet(pl, 11) {
+				tracks[name] = append(tracks[name], string(protoGet(decodeProto(t, track.b), 1)[0].b))
+			}
+		}
+
+		if typ != frameEnd {
+			t.Fatalf("%s: frame of type %d after the playlists, want the trailer", request, typ)
+		}
+		trailer := decodeProto(t, body)
+		if count := protoGet(trailer, 1); len(count) != 1 || count[0].n != 2 {
+			t.Errorf("%s: playlist count %v, want 2", request, count)
+		}
+		stats := decodeProto(t, protoGet(trailer, 2)[0].b)
+		if processed := protoGet(stats, 1); len(processed) != 1 || processed[0].n != 2 {
+			t.Errorf("%s: %v playlists processed, want 2", request, processed)
+		}
+
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: playlists %v, want %v", request, names, tt.names)
+		}
+		if want := []string{"t3", "t1"}; !reflect.DeepEqual(tracks["Sets - Peak"], want) {
+			t.Errorf("%s: Sets - Peak holds %v, want %v", request, tracks["Sets - Peak"], want)
+		}
+	}
+}
+
+func TestServeInvalidOptions(t *testing.T) {
+	conn := dialTestServer(t)
+	r := bufio.NewReader(conn)
+
+	if err := writeFrame(conn, frameListPlaylists, []byte(`{"include_regex": ["("]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, body, err := readFrame(r, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the options are rejected before the answer starts, so there is no
+	// header
+	if typ != frameError {
+		t.Fatalf("frame of type %d, want an error", typ)
+	}
+	e := decodeProto(t, body)
+	if status := protoGet(e, 1); len(status) != 1 || status[0].n == 0 {
+		t.Errorf("status %v, want a nonzero one", status)
+	}
+	if msg := protoGet(e, 2); len(msg) != 1 || len(msg[0].b) == 0 {
+		t.Errorf("no error message")
+	}
+}
@@ -0,0 +1,285 @@
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// The answers of the serve command are protobuf messages, as described by
+// serve.proto. The module has no protobuf runtime, so they are encoded here
+// by hand; only the parts of the wire format those messages use are covered.
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// protoMessage is an encoded protobuf message, appended to a field at a time.
+// Fields holding their zero value are left out as proto3 does, except by the
+// opt methods, which write the fields of explicit presence.
+type protoMessage []byte
+
+func (m *protoMessage) tag(field, wireType int) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|uint64(wireType))
+}
+
+func (m *protoMessage) optInt(field int, v int64) {
+	m.tag(field, protoVarint)
+	*m = binary.AppendUvarint(*m, uint64(v))
+}
+
+func (m *protoMessage) int(field int, v int64) {
+	if v != 0 {
+		m.optInt(field, v)
+	}
+}
+
+func (m *protoMessage) optBool(field int, v bool) {
+	if v {
+		m.optInt(field, 1)
+	} else {
+		m.optInt(field, 0)
+	}
+}
+
+func (m *protoMessage) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, protoFixed64)
+	*m = binary.LittleEndian.AppendUint64(*m, math.Float64bits(v))
+}
+
+func (m *protoMessage) optString(field int, s string) {
+	m.tag(field, protoBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(s)))
+	*m = append(*m, s...)
+}
+
+func (m *protoMessage) string(field int, s string) {
+	if s != "" {
+		m.optString(field, s)
+	}
+}
+
+func (m *protoMessage) strings(field int, ss []string) {
+	for _, s := range ss {
+		m.optString(field, s)
+	}
+}
+
+// message writes sub as field, even if empty, so an element of a repeated
+// field or a set message field is never lost.
+func (m *protoMessage) message(field int, sub protoMessage) {
+	m.optString(field, string(sub))
+}
+
+// packedInts writes vs as a packed repeated field.
+func (m *protoMessage) packedInts(field int, vs []int64) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	m.optString(field, string(packed))
+}
+
+// optTime writes t, if set, as milliseconds since the Unix epoch, the
+// encoding of the _ms timestamp fields.
+func (m *protoMessage) optTime(field int, t *time.Time) {
+	if t != nil {
+		m.optInt(field, t.UnixMilli())
+	}
+}
+
+func protoHeader(head envelopeHead) protoMessage {
+	var m protoMessage
+	m.int(1, int64(head.SchemaVersion))
+	m.string(2, head.Version)
+	m.int(3, head.GeneratedAt.UnixMilli())
+
+	return m
+}
+
+func protoPlaylist(pl *Playlist) protoMessage {
+	var m protoMessage
+	m.string(1, pl.CombinedName)
+	m.strings(2, pl.Path)
+	if pl.DJMdPlaylist != nil {
+		m.string(3, pl.DJMdPlaylist.ID.String())
+	}
+	m.string(4, pl.UUID)
+	m.int(5, pl.Seq)
+	m.packedInts(6, pl.SeqPath)
+	m.string(7, pl.ContentHash)
+	m.int(8, int64(pl.TrackCount))
+	m.int(9, pl.TotalDurationMs)
+	m.int(10, int64(pl.DuplicatesRemoved))
+	for _, track := range pl.Tracks {
+		m.message(11, protoTrack(track))
+	}
+
+	return m
+}
+
+func protoTrack(t *Track) protoMessage {
+	var m protoMessage
+	m.string(1, t.ContentID)
+	m.string(2, t.Title)
+	m.string(3, t.FolderPath)
+	m.string(4, t.ArtistName)
+	m.string(5, t.AlbumName)
+	m.string(6, t.GenreName)
+	m.double(7, t.BPM)
+	m.strings(8, t.GenrePath)
+	m.int(9, t.Length)
+	m.int(10, t.DurationMs)
+	m.string(11, t.KeyName)
+	m.string(12, t.CamelotKey)
+	if t.Energy != nil {
+		m.optInt(13, int64(*t.Energy))
+	}
+	m.int(14, t.Rating)
+	for _, cue := range t.Cues {
+		var c protoMessage
+		c.string(1, cue.Type)
+		c.string(2, cue.Slot)
+		c.double(3, cue.PositionMs)
+		c.int(4, cue.ColorIndex)
+		c.string(5, cue.Comment)
+		m.message(15, c)
+	}
+	for _, entry := range t.BeatGrid {
+		var e protoMessage
+		e.double(1, entry.PositionMs)
+		e.double(2, entry.BPM)
+		e.int(3, int64(entry.Beat))
+		m.message(16, e)
+	}
+	for _, tag := range t.MyTags {
+		var g protoMessage
+		g.string(1, tag.Category)
+		g.string(2, tag.Name)
+		m.message(17, g)
+	}
+	if t.Color != nil {
+		var c protoMessage
+		c.string(1, t.Color.Name)
+		c.string(2, t.Color.Hex)
+		m.message(18, c)
+	}
+	m.int(19, int64(t.PlayCount))
+	m.optTime(20, t.LastPlayed)
+	m.optTime(21, t.DateAdded)
+	m.optTime(22, t.FileModifiedAt)
+	if t.FileExists != nil {
+		m.optBool(23, *t.FileExists)
+	}
+	m.optTime(24, t.AddedToPlaylistAt)
+	m.int(25, t.BitRate)
+	m.int(26, t.SampleRate)
+	m.string(27, t.FileType)
+	m.string(28, t.Comment)
+	m.string(29, t.ISRC)
+	if t.LabelName != nil {
+		m.optString(30, *t.LabelName)
+	}
+	if t.ArtworkPath != nil {
+		m.optString(31, *t.ArtworkPath)
+	}
+	for _, related := range t.RelatedTracks {
+		var r protoMessage
+		r.string(1, related.ContentID)
+		r.string(2, related.ArtistName)
+		r.string(3, related.Title)
+		m.message(32, r)
+	}
+
+	return m
+}
+
+func protoTrailer(count int, trailer envelopeTrailer) protoMessage {
+	var m protoMessage
+	m.int(1, int64(count))
+	if s := trailer.Stats; s != nil {
+		var st protoMessage
+		st.int(1, int64(s.PlaylistsProcessed))
+		st.int(2, int64(s.PlaylistsFailed))
+		st.int(3, int64(s.PlaylistsSkippedSmall))
+		st.int(4, int64(s.PlaylistsSkippedEmpty))
+		st.int(5, int64(s.Tracks))
+		if s.TracksMatched != nil {
+			st.optInt(6, int64(*s.TracksMatched))
+		}
+		if s.TracksUnmatched != nil {
+			st.optInt(7, int64(*s.TracksUnmatched))
+		}
+		st.int(8, int64(s.DuplicatesRemoved))
+		st.double(9, s.ElapsedSeconds)
+		st.int(10, int64(s.TracksUnanalyzed))
+		m.message(2, st)
+	}
+	for _, failure := range trailer.Errors {
+		var e protoMessage
+		e.string(1, failure.Playlist)
+		e.string(2, failure.Error)
+		m.message(3, e)
+	}
+	for _, missing := range trailer.MissingFiles {
+		var u protoMessage
+		u.string(1, missing.Playlist)
+		u.int(2, missing.TrackNo)
+		u.string(3, missing.ContentID)
+		u.string(4, missing.Artist)
+		u.string(5, missing.Title)
+		u.string(6, missing.FolderPath)
+		u.string(7, missing.Reason)
+		m.message(4, u)
+	}
+	for _, warning := range trailer.Warnings {
+		m.message(5, protoWarning(warning))
+	}
+
+	return m
+}
+
+func protoWarning(w *logWarning) protoMessage {
+	var m protoMessage
+	m.string(1, w.Level)
+	m.string(2, w.Message)
+	m.string(3, w.Playlist)
+	m.string(4, w.ContentID)
+	if w.TrackNo != nil {
+		m.optInt(5, *w.TrackNo)
+	}
+	m.string(6, w.Reason)
+
+	// map entries in key order, so the same warning encodes the same
+	keys := make([]string, 0, len(w.Details))
+	for key := range w.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry protoMessage
+		entry.optString(1, key)
+		entry.optString(2, fmt.Sprint(w.Details[key]))
+		m.message(7, entry)
+	}
+
+	return m
+}
+
+func protoServeError(e *serveError) protoMessage {
+	var m protoMessage
+	m.int(1, int64(e.Status))
+	m.string(2, e.Error)
+
+	return m
+}
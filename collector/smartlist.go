@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// smartList is the rule set rekordbox stores as XML in DjmdPlaylist.SmartList
+// for smart (intelligent) playlists, e.g.
+//
+//	<NODE Id="1" LogicalOperator="1" AutomaticUpdate="0">
+//	  <CONDITION PropertyName="genre" Operator="1" ValueUnit="" ValueLeft="House" ValueRight=""/>
+//	</NODE>
+type smartList struct {
+	LogicalOperator int               `xml:"LogicalOperator,attr"`
+	Conditions      []*smartCondition `xml:"CONDITION"`
+}
+
+type smartCondition struct {
+	PropertyName string `xml:"PropertyName,attr"`
+	Operator     int    `xml:"Operator,attr"`
+	ValueLeft    string `xml:"ValueLeft,attr"`
+	ValueRight   string `xml:"ValueRight,attr"`
+}
+
+// smartList.LogicalOperator values
+const smartMatchAny = 2
+
+// smartCondition.Operator values
+const (
+	smartOpEqual      = 1
+	smartOpNotEqual   = 2
+	smartOpGreater    = 3
+	smartOpLess       = 4
+	smartOpInRange    = 5
+	smartOpContains   = 8
+	smartOpNotContain = 9
+	smartOpStartsWith = 10
+	smartOpEndsWith   = 11
+)
+
+func parseSmartList(s string) (*smartList, error) {
+	list := &smartList{}
+	if err := xml.Unmarshal([]byte(s), list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// matches reports whether track satisfies the list's conditions. Conditions
+// that cannot be evaluated never match, so in an all-of list they exclude
+// every track rather than silently widening the playlist.
+func (list *smartList) matches(track *Track) bool {
+	if len(list.Conditions) == 0 {
+		return false
+	}
+
+	anyOf := list.LogicalOperator == smartMatchAny
+	for _, cond := range list.Conditions {
+		ok, _ := cond.matches(track)
+		if anyOf && ok {
+			return true
+		}
+		if !anyOf && !ok {
+			return false
+		}
+	}
+
+	return !anyOf
+}
+
+// matches evaluates the condition against track. The second result is false
+// when the property or operator isn't supported.
+func (cond *smartCondition) matches(track *Track) (bool, bool) {
+	switch cond.PropertyName {
+	case "bpm":
+		return cond.matchNumber(track.BPM)
+	case "rating":
+		return cond.matchNumber(float64(track.Rating))
+	case "title":
+		return cond.matchText(track.Title)
+	case "artist":
+		return cond.matchText(track.ArtistName)
+	case "album":
+		return cond.matchText(track.AlbumName)
+	case "genre":
+		return cond.matchText(track.GenreName)
+	case "key":
+		return cond.matchText(track.KeyName)
+	}
+
+	return false, false
+}
+
+func (cond *smartCondition) matchNumber(value float64) (bool, bool) {
+	left, err := strconv.ParseFloat(cond.ValueLeft, 64)
+	if err != nil {
+		return false, false
+	}
+
+	switch cond.Operator {
+	case smartOpEqual:
+		return value == left, true
+	case smartOpNotEqual:
+		return value != left, true
+	case smartOpGreater:
+		return value > left, true
+	case smartOpLess:
+		return value < left, true
+	case smartOpInRange:
+		right, err := strconv.ParseFloat(cond.ValueRight, 64)
+		if err != nil {
+			return false, false
+		}
+		return value >= left && value <= right, true
+	}
+
+	return false, false
+}
+
+func (cond *smartCondition) matchText(value string) (bool, bool) {
+	value, want := strings.ToLower(value), strings.ToLower(cond.ValueLeft)
+
+	switch cond.Operator {
+	case smartOpEqual:
+		return value == want, true
+	case smartOpNotEqual:
+		return value != want, true
+	case smartOpContains:
+		return strings.Contains(value, want), true
+	case smartOpNotContain:
+		return !strings.Contains(value, want), true
+	case smartOpStartsWith:
+		return strings.HasPrefix(value, want), true
+	case smartOpEndsWith:
+		return strings.HasSuffix(value, want), true
+	}
+
+	return false, false
+}
+
+// evaluateSmartPlaylist computes the members of a smart playlist, whose
+// contents rekordbox doesn't store, by applying its rules to every track in
+// the library. Members are returned in content ID order.
+func evaluateSmartPlaylist(ctx context.Context, r *resolver, playlist *rekordbox.DjmdPlaylist) ([]*rekordbox.DjmdContent, error) {
+	list, err := parseSmartList(playlist.SmartList.String())
+	if err != nil {
+		return nil, fmt.Errorf("parsing smart list: %w", err)
+	}
+
+	for _, cond := range list.Conditions {
+		if _, ok := cond.matches(&Track{}); !ok {
+			slog.Warn("unsupported smart playlist condition", "playlist", playlist.Name.String(), "property", cond.PropertyName, "operator", cond.Operator)
+		}
+	}
+
+	members := []*rekordbox.DjmdContent{}
+	for _, content := range r.contents {
+		if content.RbLocalDeleted.Int64Value() != 0 {
+			continue
+		}
+
+		if list.matches(r.trackMetadata(ctx, content)) {
+			members = append(members, content)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return lessID(members[i].ID.String(), members[j].ID.String())
+	})
+
+	return members, nil
+}
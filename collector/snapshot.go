@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// library is an open rekordbox database, possibly a snapshot of the real one
+// that is removed again on Close.
+type library struct {
+	*rekordbox.Client
+	snapshotDir string
+}
+
+func (l *library) Close() error {
+	err := l.Client.Close()
+	if l.snapshotDir != "" {
+		os.RemoveAll(l.snapshotDir)
+	}
+
+	return err
+}
+
+// snapshotLibrary copies the database named in the options.json at
+// optionsFilePath, together with its WAL files, into a new temporary
+// directory, and writes an options.json there that points at the copy.
+//
+// go-rekordbox builds the SQLite DSN itself, so the database can't be opened
+// with mode=ro or a busy_timeout; retrying locked queries, see retrying,
+// stands in for the busy timeout. Reading a copy instead means we never touch
+// the file rekordbox holds locked; the copy only misses writes made while it is
+// taken, see copyDatabase.
+func snapshotLibrary(optionsFilePath string) (dir, snapshotOptions string, err error) {
+	b, err := os.ReadFile(optionsFilePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	// keep every other option as it is, only db-path changes
+	var options map[string]interface{}
+	if err := json.Unmarshal(b, &options); err != nil {
+		return "", "", fmt.Errorf("parsing %s: %w", optionsFilePath, err)
+	}
+	entries, _ := options["options"].([]interface{})
+
+	dbPath := ""
+	for _, entry := range entries {
+		pair, ok := entry.([]interface{})
+		if ok && len(pair) == 2 && pair[0] == "db-path" {
+			dbPath, _ = pair[1].(string)
+		}
+	}
+	if dbPath == "" {
+		return "", "", fmt.Errorf("no db-path in %s", optionsFilePath)
+	}
+
+	dir, err = os.MkdirTemp("", "rekordbox-snapshot-")
+	if err != nil {
+		return "", "", err
+	}
+
+	copyPath := filepath.Join(dir, filepath.Base(dbPath))
+	if err := copyDatabase(dbPath, copyPath); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("copying database: %w", err)
+	}
+
+	for _, entry := range entries {
+		if pair, ok := entry.([]interface{}); ok && len(pair) == 2 && pair[0] == "db-path" {
+			pair[1] = copyPath
+		}
+	}
+
+	b, err = json.Marshal(options)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	snapshotOptions = filepath.Join(dir, "options.json")
+	if err := os.WriteFile(snapshotOptions, b, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	return dir, snapshotOptions, nil
+}
+
+// snapshotAttempts is how often copyDatabase tries for a consistent copy of a
+// database that keeps changing, waiting snapshotRetryDelay in between.
+const (
+	snapshotAttempts   = 5
+	snapshotRetryDelay = 200 * time.Millisecond
+)
+
+// databaseFiles are the suffixes of the files making up a database in WAL
+// mode, in the order copyDatabase copies them. The WAL comes first: once
+// rekordbox checkpoints it into the database, a database copied before it
+// would miss what the WAL no longer holds.
+var databaseFiles = []string{"-wal", "", "-shm"}
+
+// fileStamp is the size and modification time of a file, zero if it
+// doesn't exist.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func stampFiles(path string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(databaseFiles))
+	for i, suffix := range databaseFiles {
+		info, err := os.Stat(path + suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	return stamps, nil
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	for i := range a {
+		if a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyDatabase copies the database at src and its WAL files to dst. Plain
+// file copies aren't atomic, and rekordbox may write or checkpoint while
+// they run, pairing a database with a WAL from another moment. So the sizes
+// and modification times of the files are compared before and after, and
+// the copy is taken again if any changed.
+func copyDatabase(src, dst string) error {
+	for attempt := 1; ; attempt++ {
+		before, err := stampFiles(src)
+		if err != nil {
+			return err
+		}
+
+		for _, suffix := range databaseFiles {
+			// a WAL gone since the last attempt mustn't be left behind
+			os.Remove(dst + suffix)
+
+			err := copyFile(src+suffix, dst+suffix)
+			// only the database itself has to exist
+			if err != nil && (suffix == "" || !errors.Is(err, os.ErrNotExist)) {
+				return err
+			}
+		}
+
+		after, err := stampFiles(src)
+		if err != nil {
+			return err
+		}
+		if sameStamps(before, after) {
+			return nil
+		}
+
+		if attempt == snapshotAttempts {
+			return fmt.Errorf("database kept changing during %d attempts to copy it", snapshotAttempts)
+		}
+		slog.Debug("database changed while copying it, copying again", "attempt", attempt)
+		time.Sleep(snapshotRetryDelay)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
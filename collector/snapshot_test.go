@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyDatabase(t *testing.T) {
+	tests := []struct {
+		name string
+		// files maps the suffixes of the source files to their contents
+		files map[string]string
+	}{
+		{"database only", map[string]string{"": "db"}},
+		{"with WAL", map[string]string{"": "db", "-wal": "wal", "-shm": "shm"}},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		src, dst := filepath.Join(dir, "master.db"), filepath.Join(dir, "copy.db")
+		for suffix, content := range tt.files {
+			if err := os.WriteFile(src+suffix, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// left over from an earlier copy, when the source still had a WAL
+		if err := os.WriteFile(dst+"-wal", []byte("stale"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := copyDatabase(src, dst); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for _, suffix := range databaseFiles {
+			b, err := os.ReadFile(dst + suffix)
+			want, ok := tt.files[suffix]
+			switch {
+			case !ok && !errors.Is(err, os.ErrNotExist):
+				t.Errorf("%s: %s copied, but the source has none", tt.name, "copy.db"+suffix)
+			case ok && err != nil:
+				t.Errorf("%s: %v", tt.name, err)
+			case ok && string(b) != want:
+				t.Errorf("%s: %s holds %q, want %q", tt.name, "copy.db"+suffix, b, want)
+			}
+		}
+	}
+}
+
+func TestCopyDatabaseMissing(t *testing.T) {
+	dir := t.TempDir()
+
+	err := copyDatabase(filepath.Join(dir, "master.db"), filepath.Join(dir, "copy.db"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v, want one for the missing database", err)
+	}
+}
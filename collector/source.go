@@ -0,0 +1,36 @@
+package collector
+
+import "context"
+
+// Where playlists can be read from.
+const (
+	sourceDB  = "db"
+	sourceXML = "xml"
+)
+
+// playlistSource produces the collected playlists, so exporting and syncing
+// work the same whichever way the library is read.
+type playlistSource interface {
+	playlists(ctx context.Context, opts CollectOptions) ([]*Playlist, error)
+	tree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error)
+	list(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error)
+	// orphans returns the tracks no playlist lists
+	orphans(ctx context.Context, opts CollectOptions) ([]*Track, error)
+}
+
+// dbSource reads the live rekordbox database.
+type dbSource struct {
+	client libraryClient
+}
+
+func (s *dbSource) playlists(ctx context.Context, opts CollectOptions) ([]*Playlist, error) {
+	return collectPlaylists(ctx, s.client, opts)
+}
+
+func (s *dbSource) tree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error) {
+	return collectTree(ctx, s.client, opts)
+}
+
+func (s *dbSource) list(ctx context.Context, opts CollectOptions) ([]*PlaylistListing, error) {
+	return listPlaylists(ctx, s.client, opts)
+}
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// splitIndexName is the file a split export lists its playlist files in.
+const splitIndexName = "index.json"
+
+// splitIndex is the index.json of a split export: the metadata of the
+// playlists envelope, with the playlists replaced by the files holding them.
+type splitIndex struct {
+	SchemaVersion int              `json:"schema_version"`
+	Version       string           `json:"version"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+	Stats         *runStats        `json:"stats"`
+	Errors        []*playlistError `json:"errors"`
+	Playlists     []*splitEntry    `json:"playlists"`
+}
+
+// splitEntry is a playlist in the index, with File relative to the index.
+type splitEntry struct {
+	File         string `json:"file"`
+	ID           string `json:"id"`
+	UUID         string `json:"uuid,omitempty"`
+	CombinedName string `json:"combined_name"`
+	Tracks       int    `json:"tracks"`
+}
+
+// writeSplitPlaylists writes every playlist as a JSON object of its own into
+// dir, named after its combined name, and lists them in dir's index.json.
+// The playlist files hold nothing that changes between runs unless the
+// playlist does, so diffing two exports shows only the playlists that
+// changed. Files listed in the previous index that no playlist was written
+// to this time are removed.
+func writeSplitPlaylists(dir string, playlists []*Playlist, stats *runStats, pretty bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	indexPath := filepath.Join(dir, splitIndexName)
+	previous, err := readSplitIndex(indexPath)
+	if err != nil {
+		slog.Warn("previous index unreadable, leaving old playlist files", "path", indexPath, "error", err)
+	}
+
+	index := &splitIndex{
+		SchemaVersion: schemaVersion,
+		Version:       version,
+		GeneratedAt:   time.Now().UTC(),
+		Stats:         stats.finish(),
+		Errors:        stats.errors(),
+		Playlists:     []*splitEntry{},
+	}
+	// index.json is taken, whatever a playlist is called
+	names := uniqueNames{"index": 1}
+	written := map[string]bool{}
+	for _, pl := range playlists {
+		entry := &splitEntry{
+			File:         names.next(sanitizeFilename(pl.CombinedName, runtime.GOOS)) + ".json",
+			UUID:         pl.UUID,
+			CombinedName: pl.CombinedName,
+			Tracks:       len(pl.Tracks),
+		}
+		if pl.DJMdPlaylist != nil {
+			entry.ID = pl.DJMdPlaylist.ID.String()
+		}
+
+		if err := writeJSON(filepath.Join(dir, entry.File), pretty, pl); err != nil {
+			return fmt.Errorf("writing playlist %s: %w", pl.CombinedName, err)
+		}
+		written[entry.File] = true
+		index.Playlists = append(index.Playlists, entry)
+	}
+
+	if err := writeJSON(indexPath, pretty, index); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		for _, entry := range previous.Playlists {
+			// only files of our own naming, never anything outside dir
+			if written[entry.File] || entry.File == splitIndexName || entry.File != filepath.Base(entry.File) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("failed to remove stale playlist file", "file", entry.File, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readSplitIndex reads the index at path, or returns nil if there is none.
+func readSplitIndex(path string) (*splitIndex, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := &splitIndex{}
+	if err := json.Unmarshal(b, index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
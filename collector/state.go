@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// syncState is persisted between CLI runs to support incremental collection.
+type syncState struct {
+	LastRun time.Time `json:"last_run"`
+	// Hashes maps playlist keys to the ContentHash they had when last
+	// exported with --changed-only
+	Hashes map[string]string `json:"hashes,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file yields the zero
+// state, so the first run collects everything.
+func loadState(path string) (*syncState, error) {
+	state := &syncState{}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func saveState(path string, state *syncState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so an interrupted run can't leave a
+	// truncated state behind
+	return writeFileAtomic(path, b)
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+)
+
+// runStats is the scoreboard of a run: how many playlists and tracks were
+// collected and, for a Plex sync, how many tracks matched. The match counts
+// are null for plain exports.
+type runStats struct {
+	PlaylistsProcessed int `json:"playlists_processed"`
+	// PlaylistsFailed counts playlists left out because collecting them
+	// failed; the envelope's errors say why
+	PlaylistsFailed int `json:"playlists_failed"`
+	// PlaylistsSkippedSmall counts playlists left out for having fewer
+	// tracks, or when syncing matched tracks, than min_tracks
+	PlaylistsSkippedSmall int `json:"playlists_skipped_small"`
+	// PlaylistsSkippedEmpty counts playlists left out because filtering
+	// their tracks left none, or, when syncing, none of them matched
+	PlaylistsSkippedEmpty int     `json:"playlists_skipped_empty"`
+	Tracks                int     `json:"tracks"`
+	TracksMatched         *int    `json:"tracks_matched"`
+	TracksUnmatched       *int    `json:"tracks_unmatched"`
+	DuplicatesRemoved     int     `json:"duplicates_removed"`
+	ElapsedSeconds        float64 `json:"elapsed_seconds"`
+	// TracksUnanalyzed counts the playlist entries left out by
+	// analyzed_only because rekordbox hasn't analyzed their track
+	TracksUnanalyzed int `json:"tracks_unanalyzed"`
+
+	started  time.Time
+	failures []*playlistError
+	// missingFiles are the entries whose file CollectOptions.CheckFiles
+	// found missing
+	missingFiles []*unresolvedTrack
+}
+
+// playlistError is a playlist that was left out of the output because
+// collecting it failed.
+type playlistError struct {
+	Playlist string `json:"playlist"`
+	Error    string `json:"error"`
+}
+
+func newRunStats() *runStats {
+	return &runStats{started: time.Now()}
+}
+
+// addPlaylists counts the collected playlists, plus skippedEmpty ones that
+// were dropped for having no tracks left and skippedSmall ones for having too
+// few. A nil s counts nothing.
+func (s *runStats) addPlaylists(playlists []*Playlist, skippedEmpty, skippedSmall int) {
+	if s == nil {
+		return
+	}
+
+	s.PlaylistsProcessed += len(playlists) + skippedEmpty + skippedSmall
+	s.PlaylistsSkippedEmpty += skippedEmpty
+	s.PlaylistsSkippedSmall += skippedSmall
+	for _, pl := range playlists {
+		s.Tracks += len(pl.Tracks)
+		s.DuplicatesRemoved += pl.DuplicatesRemoved
+	}
+}
+
+// addUnanalyzed counts n entries dropped as unanalyzed. A nil s counts
+// nothing.
+func (s *runStats) addUnanalyzed(n int) {
+	if s == nil {
+		return
+	}
+
+	s.TracksUnanalyzed += n
+}
+
+// addFailure records that collecting playlist failed with err. A nil s
+// records nothing.
+func (s *runStats) addFailure(playlist string, err error) {
+	if s == nil {
+		return
+	}
+
+	s.PlaylistsFailed++
+	s.failures = append(s.failures, &playlistError{Playlist: playlist, Error: err.Error()})
+}
+
+// addMissingFiles records the entries of unresolved whose file is missing. A
+// nil s records nothing.
+func (s *runStats) addMissingFiles(unresolved []*unresolvedTrack) {
+	if s == nil {
+		return
+	}
+
+	for _, entry := range unresolved {
+		if entry.Reason == reasonFileMissing {
+			s.missingFiles = append(s.missingFiles, entry)
+		}
+	}
+}
+
+// missing lists the recorded missing files, or nil if none were checked.
+func (s *runStats) missing() []*unresolvedTrack {
+	if s == nil {
+		return nil
+	}
+
+	return s.missingFiles
+}
+
+// errors lists the recorded failures, empty rather than nil.
+func (s *runStats) errors() []*playlistError {
+	if s == nil || s.failures == nil {
+		return []*playlistError{}
+	}
+
+	return s.failures
+}
+
+// addPlan counts the matched and unmatched tracks of a sync plan.
+func (s *runStats) addPlan(plan *syncPlan) {
+	if s == nil {
+		return
+	}
+
+	matched, unmatched := 0, 0
+	for _, pp := range plan.Playlists {
+		if pp.merged {
+			continue
+		}
+		matched += len(pp.Tracks)
+		unmatched += len(pp.Unmatched)
+		if pp.Action == actionSkip && pp.SkipReason == skipReasonTooSmall {
+			s.PlaylistsSkippedSmall++
+		} else if pp.Action == actionSkip {
+			s.PlaylistsSkippedEmpty++
+		}
+	}
+	s.TracksMatched, s.TracksUnmatched = &matched, &unmatched
+}
+
+// finish records the time elapsed since s was created and returns s.
+func (s *runStats) finish() *runStats {
+	if s == nil {
+		return nil
+	}
+
+	s.ElapsedSeconds = time.Since(s.started).Seconds()
+	return s
+}
+
+func (s *runStats) String() string {
+	str := fmt.Sprintf("%d playlists (%d skipped as empty, %d as too small), %d tracks", s.PlaylistsProcessed, s.PlaylistsSkippedEmpty, s.PlaylistsSkippedSmall, s.Tracks)
+	if s.PlaylistsFailed > 0 {
+		str += fmt.Sprintf(", %d playlists failed", s.PlaylistsFailed)
+	}
+	if s.TracksUnanalyzed > 0 {
+		str += fmt.Sprintf(", %d unanalyzed left out", s.TracksUnanalyzed)
+	}
+	if s.TracksMatched != nil {
+		str += fmt.Sprintf(", %d matched, %d unmatched", *s.TracksMatched, *s.TracksUnmatched)
+	}
+
+	return str + fmt.Sprintf(", %d duplicates removed in %.1fs", s.DuplicatesRemoved, s.ElapsedSeconds)
+}
@@ -0,0 +1,13 @@
+package collector
+
+// Status codes of a host call, telling success from the ways it can fail
+// without parsing the JSON result.
+const (
+	StatusOK = 0
+	// StatusInvalidOptions means the options argument could not be parsed
+	StatusInvalidOptions = 1
+	// StatusUnavailable means the rekordbox database could not be opened
+	StatusUnavailable = 2
+	// StatusFailed means collecting or syncing the playlists failed
+	StatusFailed = 3
+)
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// streamPlaylists collects the playlists selected by opts and writes their
+// envelope to w as they are collected, so only the playlist being encoded
+// is ever held in memory.
+func streamPlaylists(ctx context.Context, client libraryClient, opts CollectOptions, w io.Writer, pretty bool) error {
+	if opts.stats == nil {
+		opts.stats = newRunStats()
+	}
+
+	enc, err := newPlaylistsEncoder(w, newEnvelopeHead(), pretty)
+	if err != nil {
+		return err
+	}
+	opts.emit = enc.encode
+	if _, err := collect(ctx, client, opts); err != nil {
+		return err
+	}
+
+	return enc.close(newEnvelopeTrailer(opts.stats))
+}
+
+// encodePlaylists writes env as JSON to w, encoding one playlist at a time so
+// the encoded form of only a single playlist is ever held in memory. The
+// output is the same as json.Marshal (or json.MarshalIndent when pretty) of
+// env.
+func encodePlaylists(w io.Writer, env *playlistsEnvelope, pretty bool) error {
+	enc, err := newPlaylistsEncoder(w, env.envelopeHead, pretty)
+	if err != nil {
+		return err
+	}
+	for _, pl := range env.Playlists {
+		if err := enc.encode(pl); err != nil {
+			return err
+		}
+	}
+
+	return enc.close(env.envelopeTrailer)
+}
+
+// playlistsEncoder writes a playlistsEnvelope to w a part at a time: the
+// head when created, then each playlist given to encode, then the trailer
+// given to close.
+type playlistsEncoder struct {
+	w      *bufio.Writer
+	pretty bool
+	n      int
+}
+
+func newPlaylistsEncoder(w io.Writer, head envelopeHead, pretty bool) (*playlistsEncoder, error) {
+	e := &playlistsEncoder{w: bufio.NewWriter(w), pretty: pretty}
+
+	// encode the head, then leave the object open for the playlists
+	b, err := marshal(&head, "", pretty)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSuffix(b, []byte("}"))
+	if pretty {
+		b = bytes.TrimRight(b, "\n")
+		b = append(b, ",\n  \"playlists\": "...)
+	} else {
+		b = append(b, ",\"playlists\":"...)
+	}
+	_, err = e.w.Write(b)
+
+	return e, err
+}
+
+func (e *playlistsEncoder) encode(pl *Playlist) error {
+	switch {
+	case e.n > 0 && e.pretty:
+		e.w.WriteString(",\n    ")
+	case e.n > 0:
+		e.w.WriteString(",")
+	case e.pretty:
+		e.w.WriteString("[\n    ")
+	default:
+		e.w.WriteString("[")
+	}
+	e.n++
+
+	b, err := marshal(pl, "    ", e.pretty)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+
+	return err
+}
+
+// close ends the playlists, writes trailer and flushes w.
+func (e *playlistsEncoder) close(trailer envelopeTrailer) error {
+	switch {
+	case e.n == 0:
+		e.w.WriteString("[]")
+	case e.pretty:
+		e.w.WriteString("\n  ]")
+	default:
+		e.w.WriteString("]")
+	}
+
+	// the trailer's fields continue the object the head opened
+	b, err := marshal(&trailer, "", e.pretty)
+	if err != nil {
+		return err
+	}
+	e.w.WriteString(",")
+	e.w.Write(bytes.TrimPrefix(b, []byte("{")))
+	e.w.WriteString("\n")
+
+	return e.w.Flush()
+}
+
+func marshal(v interface{}, prefix string, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(v, prefix, "  ")
+	}
+
+	return json.Marshal(v)
+}
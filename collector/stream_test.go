@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodePlaylists(t *testing.T) {
+	playlists, err := collectPlaylists(context.Background(), newTestLibrary(), CollectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []*playlistsEnvelope{
+		newPlaylistsEnvelope(playlists, newRunStats()),
+		newPlaylistsEnvelope([]*Playlist{}, nil),
+	} {
+		for _, pretty := range []bool{false, true} {
+			var b bytes.Buffer
+			if err := encodePlaylists(&b, env, pretty); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := marshal(env, "", pretty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != string(want)+"\n" {
+				t.Errorf("%d playlists, pretty %v:\n%s\nwant:\n%s", len(env.Playlists), pretty, got, want)
+			}
+		}
+	}
+}
+
+func TestStreamPlaylists(t *testing.T) {
+	var b bytes.Buffer
+	if err := streamPlaylists(context.Background(), newTestLibrary(), CollectOptions{}, &b, true); err != nil {
+		t.Fatal(err)
+	}
+
+	env := &playlistsEnvelope{}
+	if err := json.Unmarshal(b.Bytes(), env); err != nil {
+		t.Fatalf("%v in:\n%s", err, b.String())
+	}
+	if want := []string{"Empty", "Sets - Peak", "Sets - Warmup", "Techno"}; !reflect.DeepEqual(playlistNames(env.Playlists), want) {
+		t.Errorf("playlists %v, want %v", playlistNames(env.Playlists), want)
+	}
+	if env.SchemaVersion != schemaVersion || env.Stats == nil || env.Stats.PlaylistsProcessed != 4 || env.Stats.Tracks != 6 {
+		t.Errorf("schema version %d, stats %+v; want %d, 4 playlists and 6 tracks", env.SchemaVersion, env.Stats, schemaVersion)
+	}
+}
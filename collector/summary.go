@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultSummaryTemplate describes a synced playlist when no template is set.
+const defaultSummaryTemplate = "{{.Name}} · {{.Tracks}} tracks from {{.Source}}, synced {{.GeneratedAt}}"
+
+// summaryData is what a summary template can refer to.
+type summaryData struct {
+	// Source is where the playlist comes from, "rekordbox"
+	Source string
+	// Name is the combined name of the rekordbox playlist
+	Name string
+	// Tracks is the number of tracks synced to Plex
+	Tracks int
+	// GeneratedAt is when the sync ran, as RFC 3339
+	GeneratedAt string
+}
+
+// keyMarkerPrefix starts the summary line naming the rekordbox playlist an
+// object was synced from, so it is found again without the mapping file,
+// e.g. after a crash between creating it and recording it there.
+const keyMarkerPrefix = "rekordbox playlist: "
+
+// syncedKey returns the playlist key marked in summary, or "" if none is.
+func syncedKey(summary string) string {
+	for _, line := range strings.Split(summary, "\n") {
+		if key, ok := strings.CutPrefix(strings.TrimSpace(line), keyMarkerPrefix); ok {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// parseSummaryTemplate parses text, the default template if empty.
+func parseSummaryTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultSummaryTemplate
+	}
+
+	tmpl, err := template.New("summary").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing summary template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// playlistSummary renders the summary of pp. syncMarker is always part of it,
+// so pruning recognizes the playlist whatever the template says, followed by
+// the key of pp.
+func playlistSummary(tmpl *template.Template, pp *playlistPlan, generatedAt time.Time) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, summaryData{
+		Source:      "rekordbox",
+		Name:        pp.Name,
+		Tracks:      len(pp.Tracks),
+		GeneratedAt: generatedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", fmt.Errorf("rendering summary of %s: %w", pp.Name, err)
+	}
+
+	summary := strings.TrimSpace(b.String())
+	if !strings.Contains(summary, syncMarker) {
+		summary = strings.TrimSpace(summary + "\n\n" + syncMarker)
+	}
+	if pp.Key != "" {
+		summary += "\n" + keyMarkerPrefix + pp.Key
+	}
+
+	return summary, nil
+}
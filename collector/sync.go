@@ -0,0 +1,835 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Actions a sync takes, or would take in a dry run, on a Plex playlist.
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionUnchanged = "unchanged"
+	actionSkip      = "skip"
+	// actionResumed is a playlist an interrupted sync already wrote
+	actionResumed = "resumed"
+)
+
+// Why a playlist is planned as actionSkip.
+const (
+	skipReasonEmpty    = "no matched tracks"
+	skipReasonTooSmall = "too few matched tracks"
+)
+
+// syncMarker is written into the summary of every playlist we create, so
+// pruning only ever deletes playlists this tool made.
+const syncMarker = "Synced from rekordbox by rekordbox-plexamp-sync"
+
+type syncSummary struct {
+	Playlists         []*playlistSyncResult `json:"playlists"`
+	Created           int                   `json:"created"`
+	Updated           int                   `json:"updated"`
+	Unchanged         int                   `json:"unchanged"`
+	Resumed           int                   `json:"resumed"`
+	Failed            int                   `json:"failed"`
+	Matched           int                   `json:"matched"`
+	MatchedByPath     int                   `json:"matched_by_path"`
+	MatchedByISRC     int                   `json:"matched_by_isrc"`
+	MatchedByMetadata int                   `json:"matched_by_metadata"`
+	MatchedByName     int                   `json:"matched_by_name"`
+	Skipped           int                   `json:"skipped"`
+	Unsupported       int                   `json:"unsupported"`
+	VerifyFailed      int                   `json:"verify_failed"`
+	MoodsSet          int                   `json:"moods_set"`
+	RatingsSet        int                   `json:"ratings_set"`
+	MetadataSet       int                   `json:"metadata_set"`
+	// Interrupted is set if the sync was cancelled before every playlist
+	// was written; the checkpoint file records those that were
+	Interrupted bool              `json:"interrupted"`
+	Deleted     []*prunedPlaylist `json:"deleted,omitempty"`
+	Stats       *runStats         `json:"stats"`
+	// Warnings is as in playlistsEnvelope
+	Warnings []*logWarning `json:"warnings,omitempty"`
+}
+
+type playlistSyncResult struct {
+	Name              string `json:"name"`
+	Action            string `json:"action"`
+	PlexID            string `json:"plex_id,omitempty"`
+	Matched           int    `json:"matched"`
+	MatchedByPath     int    `json:"matched_by_path"`
+	MatchedByISRC     int    `json:"matched_by_isrc"`
+	MatchedByMetadata int    `json:"matched_by_metadata"`
+	MatchedByName     int    `json:"matched_by_name"`
+	Skipped           int    `json:"skipped"`
+	Unsupported       int    `json:"unsupported"`
+	Error             string `json:"error,omitempty"`
+	// Verification is set when the sync is verified
+	Verification *playlistVerification `json:"verification,omitempty"`
+	// Tracks and Unmatched list every track with how it matched, or why it
+	// didn't, when SyncOptions.TrackReport is set
+	Tracks    []*plannedTrack `json:"tracks,omitempty"`
+	Unmatched []*plannedTrack `json:"unmatched,omitempty"`
+}
+
+// SyncOptions tune how rekordbox tracks are matched and written to Plex.
+type SyncOptions struct {
+	// MatchThreshold is the minimum similarity (0..1) a metadata match needs
+	MatchThreshold float64 `json:"match_threshold"`
+	// Prune deletes Plex playlists we created whose rekordbox playlist is gone
+	Prune bool `json:"prune"`
+	// Target is what each playlist is synced to: "playlist" (the default) or
+	// "collection"
+	Target string `json:"target"`
+	// MappingFile remembers which Plex object each rekordbox playlist was
+	// synced to, so renamed playlists are renamed in Plex rather than created
+	// anew. Empty disables it.
+	MappingFile string `json:"mapping_file"`
+	// CheckpointFile records each playlist as soon as it is synced and is
+	// removed once the sync finishes without failures. A sync finding one
+	// skips the playlists it lists. Empty disables it.
+	CheckpointFile string `json:"checkpoint_file"`
+	// Force ignores the checkpoint and syncs every playlist again
+	Force bool `json:"force"`
+	// MatchCacheFile keeps the Plex match of every track between runs, so
+	// only tracks that are new, whose file moved or whose Plex item
+	// changed are matched again. Empty disables it.
+	MatchCacheFile string `json:"match_cache_file"`
+	// UnmatchedOut, if set, is a CSV file that every track without a Plex
+	// match is written to
+	UnmatchedOut string `json:"unmatched_out"`
+	// ColorMoods maps rekordbox color names to the Plex mood that matched
+	// tracks of that color are given. Unmapped colors are left alone.
+	ColorMoods map[string]string `json:"color_moods"`
+	// SyncRatings copies the star rating of matched tracks to Plex
+	SyncRatings bool `json:"sync_ratings"`
+	// PushMetadata writes the title, artist, album and genre of matched
+	// tracks to the fields of their Plex items that are empty, for files
+	// without tags; ForceMetadata overwrites the fields Plex has as well
+	PushMetadata  bool `json:"push_metadata"`
+	ForceMetadata bool `json:"force_metadata"`
+	// Verify reads every synced playlist back from Plex and reports those
+	// missing planned tracks or holding others
+	Verify bool `json:"verify"`
+	// TrackReport adds every track, with its rating key, match method and
+	// score, to the result of its playlist, for auditing the matches
+	TrackReport bool `json:"track_report"`
+	// Extensions are the file extensions of the tracks that are synced, such
+	// as ".mp3", the audio formats Plex plays when empty. Tracks of other
+	// types are reported as unsupported instead of being matched.
+	Extensions []string `json:"extensions"`
+	// ExcludeExtensions are left out even if listed in Extensions
+	ExcludeExtensions []string `json:"exclude_extensions"`
+	// SummaryTemplate is the text/template the description of each synced
+	// playlist is rendered from. It can use {{.Source}}, {{.Name}},
+	// {{.Tracks}} and {{.GeneratedAt}}; the sync marker is appended if the
+	// result doesn't contain it.
+	SummaryTemplate string `json:"summary_template"`
+	// MergeInto, if set, additionally syncs every matched track of the
+	// selected playlists, each once, to a playlist of this name
+	MergeInto string `json:"merge_into"`
+	// MergeOrder orders the tracks of the merged playlist: "appearance" (the
+	// default) keeps the order they are first found in, "artist" and "title"
+	// sort them
+	MergeOrder string `json:"merge_order"`
+
+	// concurrency is how many tracks are matched at once, taken from
+	// CollectOptions.Concurrency
+	concurrency int
+	// minTracks is CollectOptions.MinTracks, applied to the matched tracks
+	minTracks int
+	// summary is the parsed SummaryTemplate
+	summary *template.Template
+	// matches is the server's part of MatchCacheFile, nil without one
+	matches *matchCache
+}
+
+func defaultSyncOptions() SyncOptions {
+	return SyncOptions{MatchThreshold: 0.8}
+}
+
+// syncPlan is the outcome of matching: what a sync would write to Plex. It is
+// also the output of a dry run.
+type syncPlan struct {
+	Playlists []*playlistPlan `json:"playlists"`
+	// Prune lists the Plex playlists that would be deleted
+	Prune []*prunedPlaylist `json:"prune,omitempty"`
+	Stats *runStats         `json:"stats"`
+	// Warnings is as in playlistsEnvelope
+	Warnings []*logWarning `json:"warnings,omitempty"`
+}
+
+type prunedPlaylist struct {
+	Name   string `json:"name"`
+	PlexID string `json:"plex_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+type playlistPlan struct {
+	Name        string `json:"name"`
+	RekordboxID string `json:"rekordbox_id"`
+	// Key is what the mapping and checkpoint files know the playlist by,
+	// see Playlist.key. Empty for a merged playlist.
+	Key    string `json:"key,omitempty"`
+	Action string `json:"action"`
+	// SkipReason says why the action is skip
+	SkipReason string `json:"skip_reason,omitempty"`
+	PlexID     string `json:"plex_id,omitempty"`
+	// RenameFrom is the current title of the Plex object if it differs
+	RenameFrom string `json:"rename_from,omitempty"`
+	// Summary is the description written to the Plex object
+	Summary   string          `json:"summary,omitempty"`
+	Tracks    []*plannedTrack `json:"tracks"`
+	Unmatched []*plannedTrack `json:"unmatched"`
+	// Unsupported are the tracks left unmatched for their file type
+	Unsupported []*plannedTrack `json:"unsupported"`
+
+	// merged is set on the playlist of --merge-into, whose tracks are
+	// already counted with the playlists they came from
+	merged bool
+}
+
+type plannedTrack struct {
+	ContentID string `json:"content_id"`
+	Artist    string `json:"artist"`
+	Title     string `json:"title"`
+	Path      string `json:"path"`
+	RatingKey string `json:"rating_key,omitempty"`
+	Method    string `json:"method,omitempty"`
+	// Section is the title of the Plex library section the match is in
+	Section string `json:"section,omitempty"`
+	// Score is the match confidence, 0..1. For unmatched tracks it is the
+	// score of the best candidate, left out if there was none.
+	Score *float64 `json:"score,omitempty"`
+	// Reason says why an unmatched track found no match
+	Reason string `json:"reason,omitempty"`
+	// Mood is the Plex mood the track's color maps to, if any
+	Mood string `json:"mood,omitempty"`
+	// UserRating is the Plex rating (0..10) the track's stars map to, set
+	// only when ratings are synced and the track is rated
+	UserRating *float64 `json:"user_rating,omitempty"`
+
+	// tags are written to the Plex item when SyncOptions.PushMetadata is
+	// set
+	tags trackTags
+}
+
+func (plan *playlistPlan) ratingKeys() []string {
+	keys := make([]string, 0, len(plan.Tracks))
+	for _, track := range plan.Tracks {
+		keys = append(keys, track.RatingKey)
+	}
+
+	return keys
+}
+
+// syncToPlex collects the playlists selected by collectOpts from src and syncs
+// them to Plex. With dryRun it returns the *syncPlan instead of applying it,
+// otherwise the *syncSummary of what was written. When ctx is cancelled while
+// writing, the playlist being written is finished and the summary so far is
+// returned along with the error.
+func syncToPlex(ctx context.Context, src playlistSource, plex *plexClient, collectOpts CollectOptions, opts SyncOptions, dryRun bool) (interface{}, error) {
+	if opts.MatchThreshold < 0 || opts.MatchThreshold > 1 {
+		return nil, fmt.Errorf("match threshold %v is not between 0 and 1", opts.MatchThreshold)
+	}
+	if opts.Prune && !collectOpts.Since.IsZero() {
+		// unchanged playlists are left out, so they would all look deleted
+		return nil, fmt.Errorf("pruning cannot be combined with incremental collection")
+	}
+	if opts.Prune && collectOpts.filtersTracks() {
+		// playlists left empty by the filter are dropped, so they would too
+		return nil, fmt.Errorf("pruning cannot be combined with filtering tracks")
+	}
+	if opts.Prune && collectOpts.PlaylistID != "" {
+		// every playlist but the selected one would look deleted
+		return nil, fmt.Errorf("pruning cannot be combined with selecting a playlist by ID")
+	}
+	if collectOpts.stats == nil {
+		// planPrune keeps the playlists whose failures it records
+		collectOpts.stats = newRunStats()
+	}
+
+	target, err := newSyncTarget(plex, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.summary, err = parseSummaryTemplate(opts.SummaryTemplate); err != nil {
+		return nil, err
+	}
+	if _, err := opts.mergeOrder(); err != nil {
+		return nil, err
+	}
+
+	mapping := &plexIDMapping{Targets: map[string]map[string]string{}}
+	if opts.MappingFile != "" {
+		if mapping, err = loadMapping(opts.MappingFile); err != nil {
+			return nil, fmt.Errorf("reading mapping file: %w", err)
+		}
+	}
+
+	checkpoint := &syncCheckpoint{Target: opts.Target, Done: map[string]string{}}
+	// resumed playlists aren't matched, so their tracks would be missing
+	// from the merged playlist
+	if opts.CheckpointFile != "" && !opts.Force && opts.MergeInto == "" {
+		if checkpoint, err = loadCheckpoint(opts.CheckpointFile, opts.Target); err != nil {
+			return nil, fmt.Errorf("reading checkpoint file: %w", err)
+		}
+		if len(checkpoint.Done) > 0 {
+			slog.Info("resuming interrupted sync", "done", len(checkpoint.Done))
+		}
+	}
+
+	// the matched tracks are what counts, and playlists left out already
+	// when collecting would look deleted to pruning
+	opts.minTracks, collectOpts.MinTracks = collectOpts.MinTracks, 0
+
+	playlists, err := src.playlists(ctx, collectOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var matchCache *matchCacheFile
+	if opts.MatchCacheFile != "" {
+		if matchCache, err = loadMatchCache(opts.MatchCacheFile); err != nil {
+			return nil, fmt.Errorf("reading match cache: %w", err)
+		}
+		machineID, err := plex.machineIdentifier(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.matches = matchCache.server(machineID)
+	}
+
+	opts.concurrency = collectOpts.concurrency()
+	plan, err := planSync(ctx, plex, target, mapping.ids(opts.Target), checkpoint.Done, playlists, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if matchCache != nil {
+		slog.Info("reused cached Plex matches", "tracks", opts.matches.hits)
+		// matches don't depend on what is written, so a dry run keeps them
+		if err := saveMatchCache(opts.MatchCacheFile, matchCache); err != nil {
+			slog.Warn("failed to write match cache", "error", err)
+		}
+	}
+
+	collectOpts.stats.addPlan(plan)
+
+	if opts.UnmatchedOut != "" {
+		if err := saveUnmatchedCSV(opts.UnmatchedOut, plan); err != nil {
+			return nil, fmt.Errorf("writing unmatched tracks: %w", err)
+		}
+	}
+
+	if opts.Prune {
+		if plan.Prune, err = planPrune(ctx, target, plan, collectOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		plan.Stats = collectOpts.stats.finish()
+		return plan, nil
+	}
+
+	summary := applySync(ctx, target, plan, opts.TrackReport, func(pp *playlistPlan) {
+		if opts.CheckpointFile == "" || pp.Key == "" {
+			return
+		}
+
+		checkpoint.Done[pp.Key] = pp.PlexID
+		if err := saveCheckpoint(opts.CheckpointFile, checkpoint); err != nil {
+			slog.Warn("failed to write checkpoint", "error", err)
+		}
+	})
+	// after an interrupt only what is needed to resume is done
+	interrupted := summary.Interrupted
+	if opts.Verify && !interrupted {
+		summary.VerifyFailed = verifySync(ctx, target, plan, summary)
+	}
+	if len(opts.ColorMoods) > 0 && !interrupted {
+		summary.MoodsSet = applyMoods(ctx, plex, plan)
+	}
+	if opts.SyncRatings && !interrupted {
+		if summary.RatingsSet, err = applyRatings(ctx, plex, plan); err != nil {
+			slog.Warn("failed to sync ratings", "error", err)
+		}
+	}
+	if opts.PushMetadata && !interrupted {
+		if summary.MetadataSet, err = applyMetadata(ctx, plex, plan, opts.ForceMetadata); err != nil {
+			slog.Warn("failed to push metadata", "error", err)
+		}
+	}
+	summary.Stats = collectOpts.stats.finish()
+
+	if opts.MappingFile != "" {
+		mapping.record(opts.Target, plan)
+		if err := saveMapping(opts.MappingFile, mapping); err != nil {
+			return nil, fmt.Errorf("writing mapping file: %w", err)
+		}
+	}
+
+	if interrupted {
+		return summary, fmt.Errorf("sync interrupted: %w", ctx.Err())
+	}
+
+	if opts.CheckpointFile != "" && summary.Failed == 0 {
+		if err := removeCheckpoint(opts.CheckpointFile); err != nil {
+			return nil, fmt.Errorf("removing checkpoint file: %w", err)
+		}
+	}
+
+	return summary, nil
+}
+
+// planPrune finds the Plex playlists created by us that no longer correspond
+// to any planned playlist. Only names that collectOpts would have selected
+// are considered, so filtering a sync doesn't delete everything else, and
+// playlists that failed to collect are kept.
+func planPrune(ctx context.Context, target syncTarget, plan *syncPlan, collectOpts CollectOptions) ([]*prunedPlaylist, error) {
+	current := map[string]bool{}
+	for _, failure := range collectOpts.stats.errors() {
+		current[failure.Playlist] = true
+	}
+	kept := map[string]bool{}
+	for _, pp := range plan.Playlists {
+		current[pp.Name] = true
+		// playlists about to be renamed still carry their old title
+		if pp.PlexID != "" {
+			kept[pp.PlexID] = true
+		}
+	}
+
+	existing, err := target.existing(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []*prunedPlaylist{}
+	for _, playlist := range existing {
+		if playlist.Smart || !strings.Contains(playlist.Summary, syncMarker) {
+			continue
+		}
+		if current[playlist.Title] || kept[playlist.RatingKey] || !collectOpts.includes(playlist.Title) {
+			continue
+		}
+
+		pruned = append(pruned, &prunedPlaylist{Name: playlist.Title, PlexID: playlist.RatingKey})
+	}
+
+	return pruned, nil
+}
+
+// planSync matches every track to a Plex item and decides what would happen to
+// each playlist on target, without modifying anything on the server. Plex
+// objects are found through ids, which maps rekordbox playlist IDs to what they
+// were synced to before, and otherwise by title. Playlists in done, those an
+// interrupted sync already wrote, are planned as resumed without matching.
+func planSync(ctx context.Context, plex *plexClient, target syncTarget, ids, done map[string]string, playlists []*Playlist, opts SyncOptions) (*syncPlan, error) {
+	existing, err := target.existing(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existingByTitle := map[string]*plexMetadata{}
+	existingByKey := map[string]*plexMetadata{}
+	existingBySynced := map[string]*plexMetadata{}
+	for _, playlist := range existing {
+		if !playlist.Smart {
+			existingByTitle[playlist.Title] = playlist
+			existingByKey[playlist.RatingKey] = playlist
+			if key := syncedKey(playlist.Summary); key != "" {
+				existingBySynced[key] = playlist
+			}
+		}
+	}
+
+	generatedAt := time.Now()
+	plan := &syncPlan{Playlists: []*playlistPlan{}}
+	for _, pl := range playlists {
+		if plexID, ok := done[pl.key()]; ok {
+			plan.Playlists = append(plan.Playlists, &playlistPlan{
+				Name:        pl.CombinedName,
+				RekordboxID: pl.DJMdPlaylist.ID.String(),
+				Key:         pl.key(),
+				Action:      actionResumed,
+				PlexID:      plexID,
+				Tracks:      []*plannedTrack{},
+				Unmatched:   []*plannedTrack{},
+				Unsupported: []*plannedTrack{},
+			})
+			continue
+		}
+
+		pp, err := matchPlaylist(ctx, plex, pl, opts)
+		if err != nil {
+			return nil, err
+		}
+		if pp.Summary, err = playlistSummary(opts.summary, pp, generatedAt); err != nil {
+			return nil, err
+		}
+		plan.Playlists = append(plan.Playlists, pp)
+
+		plexID, ok := ids[pp.Key]
+		if !ok {
+			// mapping files from before UUIDs were used are keyed by ID
+			plexID = ids[pp.RekordboxID]
+		}
+		// an object we made but didn't get to record in the mapping is
+		// adopted rather than created again
+		match, ok := existingByKey[plexID]
+		if !ok {
+			match, ok = existingBySynced[pp.Key]
+		}
+		if !ok {
+			match = existingByTitle[pl.CombinedName]
+		}
+
+		if err := planAction(ctx, target, match, pp, opts.minTracks); err != nil {
+			return nil, fmt.Errorf("planning playlist %s: %w", pl.CombinedName, err)
+		}
+	}
+
+	if opts.MergeInto != "" {
+		for _, pp := range plan.Playlists {
+			if pp.Name == opts.MergeInto {
+				return nil, fmt.Errorf("merged playlist %s has the name of a rekordbox playlist", opts.MergeInto)
+			}
+		}
+
+		order, _ := opts.mergeOrder()
+		merged := mergePlaylists(opts.MergeInto, plan.Playlists, order)
+		if merged.Summary, err = playlistSummary(opts.summary, merged, generatedAt); err != nil {
+			return nil, err
+		}
+		if err := planAction(ctx, target, existingByTitle[opts.MergeInto], merged, opts.minTracks); err != nil {
+			return nil, fmt.Errorf("planning merged playlist %s: %w", opts.MergeInto, err)
+		}
+		plan.Playlists = append(plan.Playlists, merged)
+	}
+
+	return plan, nil
+}
+
+// matchPlaylist matches the tracks of pl to Plex items, in playlist order.
+// Lookups run on opts.concurrency workers sharing the client's rate limiter.
+// A lookup that fails leaves its track unmatched rather than failing the
+// playlist.
+func matchPlaylist(ctx context.Context, plex *plexClient, pl *Playlist, opts SyncOptions) (*playlistPlan, error) {
+	pp := &playlistPlan{
+		Name:        pl.CombinedName,
+		RekordboxID: pl.DJMdPlaylist.ID.String(),
+		Key:         pl.key(),
+		Tracks:      []*plannedTrack{},
+		Unmatched:   []*plannedTrack{},
+		Unsupported: []*plannedTrack{},
+	}
+
+	tracks := make([]*plannedTrack, len(pl.DJMdContents))
+	matched := make([]bool, len(pl.DJMdContents))
+	unsupported := make([]bool, len(pl.DJMdContents))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(opts.concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				tracks[i], matched[i] = matchTrack(ctx, plex, pl, i, opts)
+			}
+		}()
+	}
+	for i, content := range pl.DJMdContents {
+		if path := content.FolderPath.String(); !opts.supportsFile(path) {
+			tracks[i] = &plannedTrack{
+				ContentID: content.ID.String(),
+				Artist:    pl.Tracks[i].ArtistName,
+				Title:     content.Title.String(),
+				Path:      path,
+				Reason:    "unsupported format " + filepath.Ext(path),
+			}
+			unsupported[i] = true
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, track := range tracks {
+		if unsupported[i] {
+			pp.Unsupported = append(pp.Unsupported, track)
+		} else if matched[i] {
+			pp.Tracks = append(pp.Tracks, track)
+		} else {
+			pp.Unmatched = append(pp.Unmatched, track)
+		}
+	}
+
+	return pp, nil
+}
+
+// matchTrack matches the i-th track of pl, reporting whether a Plex item was
+// found. Unmatched tracks carry the reason.
+func matchTrack(ctx context.Context, plex *plexClient, pl *Playlist, i int, opts SyncOptions) (*plannedTrack, bool) {
+	content := pl.DJMdContents[i]
+	track := &plannedTrack{
+		ContentID: content.ID.String(),
+		Artist:    pl.Tracks[i].ArtistName,
+		Title:     content.Title.String(),
+		Path:      content.FolderPath.String(),
+		Mood:      colorMood(opts.ColorMoods, pl.Tracks[i]),
+	}
+	if opts.PushMetadata {
+		track.tags = trackTags{
+			Title:  pl.Tracks[i].Title,
+			Artist: pl.Tracks[i].ArtistName,
+			Album:  pl.Tracks[i].AlbumName,
+			Genre:  pl.Tracks[i].GenreName,
+		}
+	}
+	if rating := plexUserRating(pl.Tracks[i].Rating); opts.SyncRatings && rating > 0 {
+		track.UserRating = &rating
+	}
+
+	match, err := cachedMatchContent(ctx, plex, content, pl.Tracks[i], opts)
+	if match != nil && match.Score >= 0 {
+		score := match.Score
+		track.Score = &score
+	}
+	if err != nil && !isNoMatch(err) {
+		slog.Warn("Plex lookup failed", "playlist", pl.CombinedName, "content_id", content.ID.String(), "title", content.Title.String(), "error", err)
+		track.Reason = "lookup failed: " + err.Error()
+		return track, false
+	}
+	if err != nil {
+		slog.Warn("no Plex match", "playlist", pl.CombinedName, "content_id", content.ID.String(), "title", content.Title.String(), "file", content.FileNameL.String(), "best_score", match.Score)
+		track.Reason = unmatchedReason(match.Score, opts.MatchThreshold)
+		return track, false
+	}
+
+	track.RatingKey, track.Method, track.Section = match.RatingKey, match.Method, match.Section
+	return track, true
+}
+
+// planAction decides whether pp has to be created or updated on target, given
+// the existing object of the same name, if any. Playlists with fewer than
+// minTracks matched tracks are skipped.
+func planAction(ctx context.Context, target syncTarget, existing *plexMetadata, pp *playlistPlan, minTracks int) error {
+	if len(pp.Tracks) == 0 {
+		// Plex cannot create a playlist without items
+		pp.Action, pp.SkipReason = actionSkip, skipReasonEmpty
+		return nil
+	}
+	if len(pp.Tracks) < minTracks {
+		pp.Action, pp.SkipReason = actionSkip, skipReasonTooSmall
+		return nil
+	}
+
+	if existing == nil {
+		pp.Action = actionCreate
+		return nil
+	}
+
+	pp.PlexID = existing.RatingKey
+	if existing.Title != pp.Name {
+		pp.RenameFrom = existing.Title
+	}
+
+	unchanged, err := target.unchanged(ctx, existing.RatingKey, pp.ratingKeys())
+	if err != nil {
+		return err
+	}
+
+	if unchanged && pp.RenameFrom == "" {
+		pp.Action = actionUnchanged
+	} else {
+		pp.Action = actionUpdate
+	}
+
+	return nil
+}
+
+// applySync writes plan to Plex. Failures are recorded per playlist, so one
+// bad playlist doesn't stop the others from syncing. synced is called after
+// each playlist that was written or found up to date. With trackReport, the
+// result of each playlist lists its tracks.
+func applySync(ctx context.Context, target syncTarget, plan *syncPlan, trackReport bool, synced func(pp *playlistPlan)) *syncSummary {
+	summary := &syncSummary{Playlists: []*playlistSyncResult{}}
+	// ctx only decides whether another playlist is started; one that was is
+	// written in full, so it can be checkpointed
+	writeCtx := context.WithoutCancel(ctx)
+	for _, pp := range plan.Playlists {
+		if ctx.Err() != nil {
+			summary.Interrupted = true
+			break
+		}
+
+		result := &playlistSyncResult{
+			Name:        pp.Name,
+			PlexID:      pp.PlexID,
+			Matched:     len(pp.Tracks),
+			Skipped:     len(pp.Unmatched),
+			Unsupported: len(pp.Unsupported),
+		}
+		if trackReport {
+			result.Tracks = pp.Tracks
+			result.Unmatched = append(append([]*plannedTrack{}, pp.Unmatched...), pp.Unsupported...)
+		}
+		for _, track := range pp.Tracks {
+			switch track.Method {
+			case matchMethodPath:
+				result.MatchedByPath++
+			case matchMethodISRC:
+				result.MatchedByISRC++
+			case matchMethodMetadata:
+				result.MatchedByMetadata++
+			case matchMethodName:
+				result.MatchedByName++
+			}
+		}
+		summary.Playlists = append(summary.Playlists, result)
+
+		if !pp.merged {
+			summary.Matched += result.Matched
+			summary.MatchedByPath += result.MatchedByPath
+			summary.MatchedByISRC += result.MatchedByISRC
+			summary.MatchedByMetadata += result.MatchedByMetadata
+			summary.MatchedByName += result.MatchedByName
+			summary.Skipped += result.Skipped
+			summary.Unsupported += result.Unsupported
+		}
+
+		var err error
+		switch pp.Action {
+		case actionCreate:
+			var created *plexMetadata
+			if created, err = target.create(writeCtx, pp.Name, pp.Summary, pp.ratingKeys()); created == nil {
+				created, err = adoptCreated(writeCtx, target, pp, err)
+			}
+			if created != nil {
+				result.Action = "created"
+				result.PlexID = created.RatingKey
+				pp.PlexID = created.RatingKey
+				summary.Created++
+
+				if err != nil {
+					slog.Warn("failed to mark playlist as synced, it won't be pruned", "playlist", pp.Name, "error", err)
+					err = nil
+				}
+			}
+		case actionUpdate:
+			if pp.RenameFrom != "" {
+				err = target.rename(writeCtx, pp.PlexID, pp.Name)
+			}
+			if err == nil {
+				err = target.replace(writeCtx, pp.PlexID, pp.ratingKeys())
+			}
+			if err == nil {
+				result.Action = "updated"
+				summary.Updated++
+
+				if err := target.describe(writeCtx, pp.PlexID, pp.Summary); err != nil {
+					slog.Warn("failed to update playlist summary", "playlist", pp.Name, "error", err)
+				}
+			}
+		case actionUnchanged:
+			result.Action = "unchanged"
+			summary.Unchanged++
+		case actionResumed:
+			result.Action = "resumed"
+			summary.Resumed++
+			continue
+		default:
+			result.Action = "skipped"
+			continue
+		}
+
+		if err != nil {
+			result.Action = "failed"
+			result.Error = err.Error()
+			summary.Failed++
+			slog.Warn("failed to sync playlist", "playlist", pp.Name, "error", err)
+			continue
+		}
+
+		synced(pp)
+	}
+
+	for _, pruned := range plan.Prune {
+		if summary.Interrupted {
+			break
+		}
+		if err := target.remove(ctx, pruned.PlexID); err != nil {
+			pruned.Error = err.Error()
+			slog.Warn("failed to delete playlist", "playlist", pruned.Name, "error", err)
+		}
+		summary.Deleted = append(summary.Deleted, pruned)
+	}
+
+	return summary
+}
+
+// adoptCreated looks for the object a failed create of pp may have made
+// anyway, e.g. when the connection dropped before Plex answered, and fills
+// it with the tracks of pp, so retrying doesn't leave a duplicate. The plan
+// found no object of that name, so one there now is taken to be ours. If
+// there is none, createErr is returned.
+func adoptCreated(ctx context.Context, target syncTarget, pp *playlistPlan, createErr error) (*plexMetadata, error) {
+	existing, err := target.existing(ctx)
+	if err != nil {
+		return nil, createErr
+	}
+
+	var adopted *plexMetadata
+	for _, item := range existing {
+		if item.Smart {
+			continue
+		}
+		if pp.Key != "" && syncedKey(item.Summary) == pp.Key {
+			adopted = item
+			break
+		}
+		if item.Title == pp.Name && adopted == nil {
+			adopted = item
+		}
+	}
+	if adopted == nil {
+		return nil, createErr
+	}
+
+	slog.Warn("creating playlist failed, adopting the one Plex made anyway", "playlist", pp.Name, "plex_id", adopted.RatingKey, "error", createErr)
+	if err := target.replace(ctx, adopted.RatingKey, pp.ratingKeys()); err != nil {
+		return nil, err
+	}
+
+	// with the summary missing it would evade pruning, as on a failed mark
+	return adopted, target.describe(ctx, adopted.RatingKey, pp.Summary)
+}
+
+func sameRatingKeys(items []*plexMetadata, ratingKeys []string) bool {
+	if len(items) != len(ratingKeys) {
+		return false
+	}
+
+	for i, item := range items {
+		if item.RatingKey != ratingKeys[i] {
+			return false
+		}
+	}
+
+	return true
+}
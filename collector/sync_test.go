@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncToPlexPruneGuards(t *testing.T) {
+	tests := []struct {
+		name        string
+		collectOpts CollectOptions
+		err         string
+	}{
+		{"incremental", CollectOptions{Since: time.Now()}, "incremental collection"},
+		{"track filter", CollectOptions{MinRating: 3}, "filtering tracks"},
+		{"playlist ID", CollectOptions{PlaylistID: "3"}, "selecting a playlist by ID"},
+	}
+
+	for _, tt := range tests {
+		// the guards run before src or plex is touched
+		_, err := syncToPlex(context.Background(), nil, nil, tt.collectOpts, SyncOptions{Prune: true}, true)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want one about %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+// fakeTarget is a syncTarget holding existing, which only lists them.
+type fakeTarget struct {
+	syncTarget
+	existingItems []*plexMetadata
+}
+
+func (f *fakeTarget) existing(ctx context.Context) ([]*plexMetadata, error) {
+	return f.existingItems, nil
+}
+
+func TestPlanPruneKeepsFailed(t *testing.T) {
+	f := newTestLibrary()
+	// a second Techno, renamed "Techno (2)", that fails to collect
+	f.addPlaylist("7", "root", "Techno", 4, playlistAttributePlaylist)
+	f.failing = map[string]bool{"7": true}
+
+	collectOpts := CollectOptions{stats: newRunStats()}
+	playlists, err := collectPlaylists(context.Background(), f, collectOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, failure := range collectOpts.stats.errors() {
+		failed = append(failed, failure.Playlist)
+	}
+	if want := []string{"Techno (2)"}; !reflect.DeepEqual(failed, want) {
+		t.Fatalf("failed %v, want %v", failed, want)
+	}
+
+	plan := &syncPlan{}
+	for _, pl := range playlists {
+		plan.Playlists = append(plan.Playlists, &playlistPlan{Name: pl.CombinedName})
+	}
+	target := &fakeTarget{existingItems: []*plexMetadata{
+		{RatingKey: "1", Title: "Techno", Summary: syncMarker},
+		{RatingKey: "2", Title: "Techno (2)", Summary: syncMarker},
+		{RatingKey: "3", Title: "Deleted", Summary: syncMarker},
+	}}
+
+	pruned, err := planPrune(context.Background(), target, plan, collectOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pp := range pruned {
+		names = append(names, pp.Name)
+	}
+	if want := []string{"Deleted"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("pruned %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// Kinds of Plex objects playlists can be synced to.
+const (
+	targetPlaylist   = "playlist"
+	targetCollection = "collection"
+)
+
+// syncTarget is the write path of a sync: the kind of Plex object each
+// rekordbox playlist becomes. Matching is the same for all of them.
+type syncTarget interface {
+	// existing lists the objects of this kind on the server
+	existing(ctx context.Context) ([]*plexMetadata, error)
+	// items lists what the object with id holds
+	items(ctx context.Context, id string) ([]*plexMetadata, error)
+	// unchanged reports whether the object with id already holds ratingKeys
+	unchanged(ctx context.Context, id string, ratingKeys []string) (bool, error)
+	// create makes a new object holding ratingKeys, marked as ours by
+	// summary, which has to contain syncMarker
+	create(ctx context.Context, title, summary string, ratingKeys []string) (*plexMetadata, error)
+	replace(ctx context.Context, id string, ratingKeys []string) error
+	rename(ctx context.Context, id, title string) error
+	// describe sets the summary of the object with id
+	describe(ctx context.Context, id, summary string) error
+	remove(ctx context.Context, id string) error
+}
+
+func newSyncTarget(plex *plexClient, target string) (syncTarget, error) {
+	switch target {
+	case "", targetPlaylist:
+		return &playlistTarget{plex: plex}, nil
+	case targetCollection:
+		return &collectionTarget{plex: plex}, nil
+	}
+
+	return nil, fmt.Errorf("unknown sync target %q", target)
+}
+
+// playlistTarget syncs to ordered audio playlists.
+type playlistTarget struct {
+	plex *plexClient
+}
+
+func (t *playlistTarget) existing(ctx context.Context) ([]*plexMetadata, error) {
+	return t.plex.playlists(ctx)
+}
+
+func (t *playlistTarget) items(ctx context.Context, id string) ([]*plexMetadata, error) {
+	return t.plex.playlistItems(ctx, id)
+}
+
+func (t *playlistTarget) unchanged(ctx context.Context, id string, ratingKeys []string) (bool, error) {
+	items, err := t.items(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return sameRatingKeys(items, ratingKeys), nil
+}
+
+func (t *playlistTarget) create(ctx context.Context, title, summary string, ratingKeys []string) (*plexMetadata, error) {
+	created, err := t.plex.createPlaylist(ctx, title, ratingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.describe(ctx, created.RatingKey, summary); err != nil {
+		return created, fmt.Errorf("marking playlist as synced: %w", err)
+	}
+
+	return created, nil
+}
+
+func (t *playlistTarget) replace(ctx context.Context, id string, ratingKeys []string) error {
+	return t.plex.replacePlaylistItems(ctx, id, ratingKeys)
+}
+
+func (t *playlistTarget) rename(ctx context.Context, id, title string) error {
+	return t.plex.editPlaylist(ctx, id, url.Values{"title": {title}})
+}
+
+func (t *playlistTarget) describe(ctx context.Context, id, summary string) error {
+	return t.plex.editPlaylist(ctx, id, url.Values{"summary": {summary}})
+}
+
+func (t *playlistTarget) remove(ctx context.Context, id string) error {
+	return t.plex.deletePlaylist(ctx, id)
+}
+
+// collectionTarget syncs to track collections. Collections live in a library
+// section and are unordered, so only membership is compared.
+type collectionTarget struct {
+	plex *plexClient
+}
+
+func (t *collectionTarget) existing(ctx context.Context) ([]*plexMetadata, error) {
+	return t.plex.collections(ctx)
+}
+
+func (t *collectionTarget) items(ctx context.Context, id string) ([]*plexMetadata, error) {
+	return t.plex.collectionItems(ctx, id)
+}
+
+func (t *collectionTarget) unchanged(ctx context.Context, id string, ratingKeys []string) (bool, error) {
+	items, err := t.items(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	have := make([]string, 0, len(items))
+	for _, item := range items {
+		have = append(have, item.RatingKey)
+	}
+	want := append([]string(nil), ratingKeys...)
+	sort.Strings(have)
+	sort.Strings(want)
+
+	if len(have) != len(want) {
+		return false, nil
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (t *collectionTarget) create(ctx context.Context, title, summary string, ratingKeys []string) (*plexMetadata, error) {
+	// the collection goes into the section of its first track
+	first, err := t.plex.metadata(ctx, ratingKeys[0])
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := t.plex.createCollection(ctx, first.LibrarySectionID, title, ratingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.plex.editCollection(ctx, first.LibrarySectionID, created.RatingKey, url.Values{"summary.value": {summary}}); err != nil {
+		return created, fmt.Errorf("marking collection as synced: %w", err)
+	}
+
+	return created, nil
+}
+
+func (t *collectionTarget) replace(ctx context.Context, id string, ratingKeys []string) error {
+	return t.plex.replaceCollectionItems(ctx, id, ratingKeys)
+}
+
+func (t *collectionTarget) rename(ctx context.Context, id, title string) error {
+	collection, err := t.plex.metadata(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return t.plex.editCollection(ctx, collection.LibrarySectionID, id, url.Values{"title.value": {title}})
+}
+
+func (t *collectionTarget) describe(ctx context.Context, id, summary string) error {
+	collection, err := t.plex.metadata(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return t.plex.editCollection(ctx, collection.LibrarySectionID, id, url.Values{"summary.value": {summary}})
+}
+
+func (t *collectionTarget) remove(ctx context.Context, id string) error {
+	return t.plex.deleteCollection(ctx, id)
+}
@@ -0,0 +1,410 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Track is the consumer-facing view of a DjmdContent row, with rekordbox's
+// internal encodings converted and referenced rows resolved to names.
+type Track struct {
+	ContentID  string  `json:"content_id"`
+	Title      string  `json:"title"`
+	FolderPath string  `json:"folder_path"`
+	ArtistName string  `json:"artist_name"`
+	AlbumName  string  `json:"album_name"`
+	GenreName  string  `json:"genre_name"`
+	BPM        float64 `json:"bpm"`
+	// GenrePath is the genre from the broadest to GenreName, empty if the
+	// track has none. rekordbox's genres don't nest, so it is GenreName
+	// alone; it is a path so tooling can group by it either way.
+	GenrePath []string `json:"genre_path"`
+	// Length is the duration in seconds
+	Length int64 `json:"length"`
+	// DurationMs is the duration in milliseconds, 0 for tracks that haven't
+	// been analyzed
+	DurationMs int64  `json:"duration_ms"`
+	KeyName    string `json:"key_name"`
+	// CamelotKey is KeyName in Camelot notation, e.g. "8A", empty if the
+	// track has no analyzed key
+	CamelotKey string `json:"camelot_key"`
+	// Energy is the mood of rekordbox's phrase analysis, 1 (low) to 3
+	// (high), null for tracks analyzed without phrases
+	Energy *int `json:"energy"`
+	// Rating is the rekordbox star rating, 0 (unrated) to 5
+	Rating   int64            `json:"rating"`
+	Cues     []*Cue           `json:"cues"`
+	BeatGrid []*BeatGridEntry `json:"beat_grid"`
+	MyTags   []*MyTag         `json:"my_tags"`
+	// Color is the track's color label, null if none is assigned
+	Color *TrackColor `json:"color"`
+	// PlayCount and LastPlayed are taken from the history playlists;
+	// LastPlayed is null for tracks never played
+	PlayCount  int        `json:"play_count"`
+	LastPlayed *time.Time `json:"last_played"`
+	// DateAdded is when the track was added to the collection, null if
+	// rekordbox doesn't know
+	DateAdded *time.Time `json:"date_added"`
+	// FileModifiedAt is when the audio file was last modified on disk, null
+	// unless CollectOptions.StatFiles is set or if the file is missing
+	FileModifiedAt *time.Time `json:"file_modified_at"`
+	// FileExists is whether the audio file is on disk, null unless
+	// CollectOptions.CheckFiles is set
+	FileExists *bool `json:"file_exists"`
+	// AddedToPlaylistAt is when the track was placed in the playlist it is
+	// listed in, as opposed to DateAdded for the collection. Null for smart
+	// playlists and libraries read from XML, which don't record it.
+	AddedToPlaylistAt *time.Time `json:"added_to_playlist_at"`
+	// BitRate is in kbit/s and SampleRate in Hz
+	BitRate    int64  `json:"bit_rate"`
+	SampleRate int64  `json:"sample_rate"`
+	FileType   string `json:"file_type"`
+	Comment    string `json:"comment"`
+	// ISRC identifies the recording, empty if the file carries none
+	ISRC string `json:"isrc"`
+	// LabelName is the record label, null if the track has none
+	LabelName *string `json:"label_name"`
+	// ArtworkPath is the cover image rekordbox extracted for the track, null
+	// if it has none
+	ArtworkPath *string `json:"artwork_path"`
+	// RelatedTracks lists the tracks sharing a Related Tracks list with this
+	// one
+	RelatedTracks []*RelatedTrack `json:"related_tracks"`
+}
+
+// fileTypeNames maps DjmdContent.FileType to the name of the format.
+var fileTypeNames = map[int64]string{
+	1:  "MP3",
+	4:  "M4A",
+	5:  "FLAC",
+	11: "WAV",
+	12: "AIFF",
+}
+
+// fileTypeName returns the name of the file type code, or the code itself if
+// it is one we don't know.
+func fileTypeName(code int64) string {
+	if name, ok := fileTypeNames[code]; ok {
+		return name
+	}
+
+	return strconv.FormatInt(code, 10)
+}
+
+// resolver turns DjmdContent rows into Tracks, caching the lookups of shared
+// rows such as artists and keys so each is queried at most once per run. It is
+// safe for concurrent use once loaded; two goroutines missing the cache at the
+// same time may both query the row.
+type resolver struct {
+	client libraryClient
+	// contents holds every DjmdContent row by ID, see loadContents
+	contents map[string]*rekordbox.DjmdContent
+	// myTags holds the My Tags of each content ID, see loadMyTags
+	myTags map[string][]*MyTag
+	// history holds the plays of each content ID, see loadHistory
+	history map[string]*playHistory
+	// related holds the related content IDs of each content ID, see
+	// loadRelated
+	related map[string][]string
+	// noCues is set when the schema has no usable cue table, see probeCues
+	noCues bool
+	// fields are the track sub-objects to resolve, see CollectOptions.Fields
+	fields fieldSet
+	// tagFilter is set when tracks are filtered by My Tag, which needs them
+	// loaded even if they aren't exported
+	tagFilter bool
+
+	// mu guards the caches below, which are filled while collecting
+	mu       sync.Mutex
+	artists  map[string]string
+	albums   map[string]string
+	genres   map[string]string
+	keys     map[string]string
+	labels   map[string]string
+	colors   map[string]*TrackColor
+	cueCache map[string][]*Cue
+	// analysisDir is where analysis files are read from, see beatGrid
+	analysisDir string
+	beatGrids   map[string][]*BeatGridEntry
+	// statFiles, checkFiles and fileRemaps are CollectOptions.StatFiles,
+	// CheckFiles and their remaps
+	statFiles  bool
+	checkFiles bool
+	fileRemaps pathRemaps
+}
+
+func newResolver(client libraryClient, analysisDir string) *resolver {
+	return &resolver{
+		client:      client,
+		analysisDir: analysisDir,
+		beatGrids:   map[string][]*BeatGridEntry{},
+		artists:     map[string]string{},
+		albums:      map[string]string{},
+		genres:      map[string]string{},
+		keys:        map[string]string{},
+		labels:      map[string]string{},
+		colors:      map[string]*TrackColor{},
+		cueCache:    map[string][]*Cue{},
+	}
+}
+
+// loadContents reads every DjmdContent row in a single query, so playlist
+// entries can be resolved without a round-trip each.
+func (r *resolver) loadContents(ctx context.Context) error {
+	all, err := r.client.AllDjmdContent(ctx)
+	if err != nil {
+		return fmt.Errorf("loading contents: %w", err)
+	}
+
+	r.contents = make(map[string]*rekordbox.DjmdContent, len(all))
+	for _, content := range all {
+		r.contents[content.ID.String()] = content
+	}
+
+	return nil
+}
+
+// track resolves everything exported about content.
+func (r *resolver) track(ctx context.Context, content *rekordbox.DjmdContent) *Track {
+	track := r.trackMetadata(ctx, content)
+	track.Cues, track.BeatGrid, track.RelatedTracks = []*Cue{}, []*BeatGridEntry{}, []*RelatedTrack{}
+	if r.fields.has(fieldCues) {
+		track.Cues = r.cues(ctx, content)
+	}
+	if r.fields.has(fieldBeatGrid) {
+		track.BeatGrid = r.beatGrid(content)
+	}
+	if r.fields.has(fieldEnergy) {
+		track.Energy = r.energy(content)
+	}
+	if r.fields.has(fieldRelated) {
+		track.RelatedTracks = r.relatedTracks(ctx, content)
+	}
+	return track
+}
+
+// trackMetadata resolves content without its cues and beat grid, which need a
+// query or file read of their own and aren't needed to evaluate smart lists.
+func (r *resolver) trackMetadata(ctx context.Context, content *rekordbox.DjmdContent) *Track {
+	playCount, lastPlayed := r.playCount(content.ID.String())
+	keyName := r.keyName(ctx, content)
+	genreName := r.genreName(ctx, content)
+
+	return &Track{
+		ContentID:  content.ID.String(),
+		Title:      content.Title.String(),
+		FolderPath: content.FolderPath.String(),
+		ArtistName: r.artistName(ctx, content),
+		AlbumName:  r.albumName(ctx, content),
+		GenreName:  genreName,
+		BPM:        contentBPM(content),
+		Length:     content.Length.Int64Value(),
+		DurationMs: contentDurationMs(content),
+		KeyName:    keyName,
+		CamelotKey: camelotKey(keyName),
+		Rating:     content.Rating.Int64Value(),
+		MyTags:     r.exportedMyTags(content.ID.String()),
+		Color:      r.color(ctx, content),
+
+		PlayCount:  playCount,
+		LastPlayed: lastPlayed,
+		DateAdded:  contentDateAdded(content),
+		BitRate:    content.BitRate.Int64Value(),
+		SampleRate: content.SampleRate.Int64Value(),
+		FileType:   fileTypeName(content.FileType.Int64Value()),
+		Comment:    content.Commnt.String(),
+		ISRC:       normalizeISRC(content.ISRC.String()),
+		LabelName:  r.labelName(ctx, content),
+
+		ArtworkPath: r.artworkPath(content),
+		GenrePath:   genrePath(genreName),
+	}
+}
+
+// durationMs returns the duration of track in milliseconds, from its length
+// in seconds if it hasn't been analyzed, or 0 if neither is known.
+func (track *Track) durationMs() int64 {
+	if track.DurationMs > 0 {
+		return track.DurationMs
+	}
+
+	return track.Length * 1000
+}
+
+// genrePath returns the GenrePath of a track of the given genre.
+func genrePath(genreName string) []string {
+	if genreName == "" {
+		return []string{}
+	}
+
+	return []string{genreName}
+}
+
+// artworkPath returns the file of the artwork rekordbox cached for content.
+// ImagePath is relative to the same folder as the analysis files; if that is
+// unknown, the path is returned as rekordbox stores it.
+func (r *resolver) artworkPath(content *rekordbox.DjmdContent) *string {
+	path := content.ImagePath.String()
+	if path == "" {
+		return nil
+	}
+
+	if r.analysisDir != "" {
+		path = filepath.Join(r.analysisDir, filepath.FromSlash(path))
+	}
+
+	return &path
+}
+
+// maxLengthSeconds bounds plausible track lengths in seconds. Some libraries
+// store Length in samples instead, which is far larger for any real track.
+const maxLengthSeconds = 24 * 60 * 60
+
+// contentDurationMs returns the length of content in milliseconds, converting
+// from samples using the sample rate where Length is stored in samples.
+func contentDurationMs(content *rekordbox.DjmdContent) int64 {
+	length := content.Length.Int64Value()
+	if sampleRate := content.SampleRate.Int64Value(); length > maxLengthSeconds && sampleRate > 0 {
+		return int64(msFromSamples(length, sampleRate))
+	}
+
+	return length * 1000
+}
+
+// dateAddedLayouts are the formats rekordbox has written DateCreated in.
+// Those without a zone hold local time.
+var dateAddedLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.000 -07:00",
+	time.RFC3339,
+}
+
+// parseDateAdded parses a DateCreated value, returning nil if it is empty or
+// in no known format.
+func parseDateAdded(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range dateAddedLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+// contentDateAdded returns when content was added to the collection: the date
+// rekordbox shows as "Date Added", or failing that when its row was created.
+func contentDateAdded(content *rekordbox.DjmdContent) *time.Time {
+	if t := parseDateAdded(content.DateCreated.String()); t != nil {
+		return t
+	}
+
+	if t := content.CreatedAt.Time(); !t.IsZero() {
+		return &t
+	}
+
+	return nil
+}
+
+// unsetID reports whether a reference column such as ArtistID holds no
+// reference: NULL, empty, or 0 as written by older rekordbox versions and
+// some importers.
+func unsetID(id string) bool {
+	return id == "" || id == "0"
+}
+
+// cachedName returns the name of the row with the given id from cache, calling
+// fetch and remembering its result on a miss. Failed lookups are cached as ""
+// so a missing row only produces one warning. Unset IDs give "" without a
+// lookup.
+func (r *resolver) cachedName(cache map[string]string, kind, id, contentID string, fetch func() (string, error)) string {
+	if unsetID(id) {
+		return ""
+	}
+
+	r.mu.Lock()
+	name, ok := cache[id]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name, err := fetch()
+	if err != nil {
+		slog.Warn(kind+" not found", "id", id, "content_id", contentID, "error", err)
+		name = ""
+	}
+
+	r.mu.Lock()
+	cache[id] = name
+	r.mu.Unlock()
+	return name
+}
+
+func (r *resolver) artistName(ctx context.Context, content *rekordbox.DjmdContent) string {
+	return r.cachedName(r.artists, "artist", content.ArtistID.String(), content.ID.String(), func() (string, error) {
+		artist, err := r.client.DjmdArtistByID(ctx, content.ArtistID)
+		if err != nil || artist == nil {
+			return "", err
+		}
+		return artist.Name.String(), nil
+	})
+}
+
+func (r *resolver) albumName(ctx context.Context, content *rekordbox.DjmdContent) string {
+	return r.cachedName(r.albums, "album", content.AlbumID.String(), content.ID.String(), func() (string, error) {
+		album, err := r.client.DjmdAlbumByID(ctx, content.AlbumID)
+		if err != nil || album == nil {
+			return "", err
+		}
+		return album.Name.String(), nil
+	})
+}
+
+func (r *resolver) genreName(ctx context.Context, content *rekordbox.DjmdContent) string {
+	return r.cachedName(r.genres, "genre", content.GenreID.String(), content.ID.String(), func() (string, error) {
+		genre, err := r.client.DjmdGenreByID(ctx, content.GenreID)
+		if err != nil || genre == nil {
+			return "", err
+		}
+		return genre.Name.String(), nil
+	})
+}
+
+func (r *resolver) keyName(ctx context.Context, content *rekordbox.DjmdContent) string {
+	return r.cachedName(r.keys, "key", content.KeyID.String(), content.ID.String(), func() (string, error) {
+		key, err := r.client.DjmdKeyByID(ctx, content.KeyID)
+		if err != nil || key == nil {
+			return "", err
+		}
+		return key.ScaleName.String(), nil
+	})
+}
+
+// labelName returns the name of the content's label, or nil if it has none or
+// the label row is missing.
+func (r *resolver) labelName(ctx context.Context, content *rekordbox.DjmdContent) *string {
+	name := r.cachedName(r.labels, "label", content.LabelID.String(), content.ID.String(), func() (string, error) {
+		label, err := r.client.DjmdLabelByID(ctx, content.LabelID)
+		if err != nil || label == nil {
+			return "", err
+		}
+		return label.Name.String(), nil
+	})
+	if name == "" {
+		return nil
+	}
+
+	return &name
+}
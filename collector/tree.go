@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+)
+
+// Kinds of nodes in the playlist tree.
+const (
+	nodeKindFolder   = "folder"
+	nodeKindPlaylist = "playlist"
+	nodeKindSmart    = "smart_playlist"
+)
+
+// PlaylistNode is a folder or playlist in the playlist tree. IDs match the
+// dj_md_playlist rows of the flat export.
+type PlaylistNode struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	// CombinedName and Tracks are set for playlists only
+	CombinedName string          `json:"combined_name,omitempty"`
+	Tracks       []*Track        `json:"tracks,omitempty"`
+	Children     []*PlaylistNode `json:"children,omitempty"`
+	// Seq is the position of the node within its folder, which children
+	// are ordered by
+	Seq int64 `json:"seq"`
+}
+
+// collectTree collects the playlists selected by opts and arranges them under
+// their folders.
+func collectTree(ctx context.Context, client libraryClient, opts CollectOptions) ([]*PlaylistNode, error) {
+	rows, err := client.AllDjmdPlaylist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing playlists: %w", err)
+	}
+
+	playlists, err := collectPlaylists(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return arrangeTree(rows, playlists), nil
+}
+
+// arrangeTree nests the collected playlists under the folders of rows, the
+// full list of playlist rows. Folders left empty because all their playlists
+// were filtered out are dropped; folders that are empty in rekordbox are kept.
+func arrangeTree(rows []*rekordbox.DjmdPlaylist, playlists []*Playlist) []*PlaylistNode {
+	collected := map[string]*Playlist{}
+	for _, pl := range playlists {
+		collected[pl.DJMdPlaylist.ID.String()] = pl
+	}
+
+	nodes := map[string]*PlaylistNode{}
+	hadChildren := map[string]bool{}
+	for _, row := range rows {
+		node := &PlaylistNode{
+			ID:       row.ID.String(),
+			ParentID: row.ParentID.String(),
+			Name:     row.Name.String(),
+			Seq:      row.Seq.Int64Value(),
+		}
+		hadChildren[node.ParentID] = true
+
+		switch row.Attribute.Int64Value() {
+		case playlistAttributeFolder:
+			node.Kind = nodeKindFolder
+		case playlistAttributeSmart:
+			node.Kind = nodeKindSmart
+		default:
+			node.Kind = nodeKindPlaylist
+		}
+
+		if node.Kind != nodeKindFolder {
+			pl, ok := collected[node.ID]
+			if !ok {
+				continue
+			}
+			node.CombinedName = pl.CombinedName
+			node.Tracks = pl.Tracks
+		}
+
+		nodes[node.ID] = node
+	}
+
+	roots := []*PlaylistNode{}
+	for _, node := range nodes {
+		parent, ok := nodes[node.ParentID]
+		switch {
+		case isRootParent(node.ParentID):
+			roots = append(roots, node)
+		case ok:
+			parent.Children = append(parent.Children, node)
+		default:
+			// named as top level by getRecursivePlaylistPath too
+			slog.Warn("dangling playlist parent, treating playlist as top level", "playlist", node.Name, "parent_id", node.ParentID)
+			roots = append(roots, node)
+		}
+		// nodes whose parents form a cycle are unreachable from the root
+		// and left out
+	}
+
+	return pruneTree(roots, hadChildren)
+}
+
+// pruneTree orders nodes the way rekordbox shows them and drops folders that
+// lost all of their children to filtering.
+func pruneTree(nodes []*PlaylistNode, hadChildren map[string]bool) []*PlaylistNode {
+	kept := []*PlaylistNode{}
+	for _, node := range nodes {
+		if node.Kind == nodeKindFolder {
+			node.Children = pruneTree(node.Children, hadChildren)
+			if len(node.Children) == 0 && hadChildren[node.ID] {
+				continue
+			}
+		}
+
+		kept = append(kept, node)
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].Seq != kept[j].Seq {
+			return kept[i].Seq < kept[j].Seq
+		}
+		return lessID(kept[i].ID, kept[j].ID)
+	})
+
+	return kept
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+)
+
+// unmatchedReason explains why a track found no Plex match, given the best
+// candidate score from its plexMatch.
+func unmatchedReason(score, threshold float64) string {
+	if score < 0 {
+		return "no Plex track with this path or title"
+	}
+
+	return fmt.Sprintf("best candidate scored %.2f, below the threshold of %.2f", score, threshold)
+}
+
+// writeUnmatchedCSV writes one row per unmatched track of plan, with a header
+// row, so the list can be worked through in a spreadsheet.
+func writeUnmatchedCSV(w io.Writer, plan *syncPlan) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"playlist", "artist", "title", "path", "reason"})
+
+	for _, pp := range plan.Playlists {
+		for _, track := range pp.Unmatched {
+			cw.Write([]string{pp.Name, track.Artist, track.Title, track.Path, track.Reason})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// saveUnmatchedCSV writes the unmatched tracks of plan to the file at path.
+func saveUnmatchedCSV(path string, plan *syncPlan) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeUnmatchedCSV(f, plan); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+)
+
+// updatePlan is the output of plan-update: for every rekordbox playlist
+// already synced to a Plex object, the items to add to and remove from it to
+// bring it up to date, rather than the full track list a sync would write.
+type updatePlan struct {
+	Playlists []*playlistDelta `json:"playlists"`
+	// Create names the playlists that have no Plex object yet, which have
+	// to be created in full
+	Create []string `json:"create"`
+	// Prune lists the Plex playlists that would be deleted
+	Prune []*prunedPlaylist `json:"prune,omitempty"`
+	Stats *runStats         `json:"stats"`
+}
+
+type playlistDelta struct {
+	Name        string `json:"name"`
+	RekordboxID string `json:"rekordbox_id"`
+	Key         string `json:"key,omitempty"`
+	PlexID      string `json:"plex_id"`
+	// RenameFrom is the current title of the Plex object if it differs
+	RenameFrom string `json:"rename_from,omitempty"`
+	// Add are the rating keys missing from the Plex object, in playlist
+	// order, and Remove those it holds that the playlist no longer does
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+	// Reordered is set if the object holds the right items in the wrong
+	// order once Add and Remove are applied, which only matters for
+	// playlists: collections are unordered
+	Reordered bool `json:"reordered"`
+}
+
+// planPlexUpdate plans a sync like a dry run of syncToPlex, then reads the
+// current items of each Plex object that is out of date and diffs them
+// against the matched tracks. Objects already up to date aren't read again.
+func planPlexUpdate(ctx context.Context, src playlistSource, plex *plexClient, collectOpts CollectOptions, opts SyncOptions) (*updatePlan, error) {
+	target, err := newSyncTarget(plex, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := syncToPlex(ctx, src, plex, collectOpts, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	plan := result.(*syncPlan)
+
+	update := &updatePlan{
+		Playlists: []*playlistDelta{},
+		Create:    []string{},
+		Prune:     plan.Prune,
+		Stats:     plan.Stats,
+	}
+	for _, pp := range plan.Playlists {
+		switch pp.Action {
+		case actionCreate:
+			update.Create = append(update.Create, pp.Name)
+			continue
+		case actionUpdate, actionUnchanged:
+		default:
+			continue
+		}
+
+		delta := &playlistDelta{
+			Name:        pp.Name,
+			RekordboxID: pp.RekordboxID,
+			Key:         pp.Key,
+			PlexID:      pp.PlexID,
+			RenameFrom:  pp.RenameFrom,
+			Add:         []string{},
+			Remove:      []string{},
+		}
+		if pp.Action == actionUpdate {
+			items, err := target.items(ctx, pp.PlexID)
+			if err != nil {
+				return nil, fmt.Errorf("reading items of %s: %w", pp.Name, err)
+			}
+
+			current := make([]string, 0, len(items))
+			for _, item := range items {
+				current = append(current, item.RatingKey)
+			}
+			delta.Add, delta.Remove, delta.Reordered = diffRatingKeys(current, pp.ratingKeys())
+			if opts.Target == targetCollection {
+				delta.Reordered = false
+			}
+		}
+		update.Playlists = append(update.Playlists, delta)
+	}
+
+	return update, nil
+}
+
+// diffRatingKeys returns the keys to add to current and remove from it to
+// hold the same items as want, counting repeated keys, and whether the
+// result would still be in a different order than want. Added keys are
+// taken to be appended.
+func diffRatingKeys(current, want []string) (add, remove []string, reordered bool) {
+	add, remove = []string{}, []string{}
+
+	wanted := map[string]int{}
+	for _, key := range want {
+		wanted[key]++
+	}
+	kept := []string{}
+	for _, key := range current {
+		if wanted[key] > 0 {
+			wanted[key]--
+			kept = append(kept, key)
+		} else {
+			remove = append(remove, key)
+		}
+	}
+
+	held := map[string]int{}
+	for _, key := range kept {
+		held[key]++
+	}
+	for _, key := range want {
+		if held[key] > 0 {
+			held[key]--
+		} else {
+			add = append(add, key)
+		}
+	}
+
+	result := append(kept, add...)
+	for i := range want {
+		if result[i] != want[i] {
+			return add, remove, true
+		}
+	}
+
+	return add, remove, false
+}
@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+)
+
+// playlistVerification compares what a Plex object holds after a sync with
+// what was written to it.
+type playlistVerification struct {
+	Expected int `json:"expected"`
+	Found    int `json:"found"`
+	// Missing and Extra are the rating keys that should be in the object but
+	// aren't, and that are in it but shouldn't be
+	Missing []string `json:"missing"`
+	Extra   []string `json:"extra"`
+	// Error is set if the object couldn't be read back
+	Error string `json:"error,omitempty"`
+}
+
+func (v *playlistVerification) ok() bool {
+	return v.Error == "" && v.Found == v.Expected && len(v.Missing) == 0 && len(v.Extra) == 0
+}
+
+// verifySync reads back every Plex object the sync wrote or found up to date
+// and records on its result whether it holds the planned tracks. Plex
+// answering a write with 200 doesn't guarantee that every item was added.
+// It returns how many objects didn't match.
+func verifySync(ctx context.Context, target syncTarget, plan *syncPlan, summary *syncSummary) int {
+	failed := 0
+	for i, pp := range plan.Playlists {
+		result := summary.Playlists[i]
+		switch result.Action {
+		case "created", "updated", "unchanged":
+		default:
+			continue
+		}
+
+		result.Verification = verifyPlaylist(ctx, target, pp)
+		if !result.Verification.ok() {
+			failed++
+			slog.Warn("Plex playlist doesn't match what was synced", "playlist", pp.Name, "missing", len(result.Verification.Missing), "extra", len(result.Verification.Extra), "error", result.Verification.Error)
+		}
+	}
+
+	return failed
+}
+
+func verifyPlaylist(ctx context.Context, target syncTarget, pp *playlistPlan) *playlistVerification {
+	want := pp.ratingKeys()
+	v := &playlistVerification{Expected: len(want), Missing: []string{}, Extra: []string{}}
+
+	items, err := target.items(ctx, pp.PlexID)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Found = len(items)
+
+	wanted := map[string]bool{}
+	for _, key := range want {
+		wanted[key] = true
+	}
+	have := map[string]bool{}
+	for _, item := range items {
+		have[item.RatingKey] = true
+		if !wanted[item.RatingKey] {
+			v.Extra = append(v.Extra, item.RatingKey)
+		}
+	}
+	for _, key := range want {
+		if !have[key] {
+			v.Missing = append(v.Missing, key)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// version is the build version, set at build time with
+// -ldflags "-X github.com/dvcrn/rekordbox-playlist-sync/collector.version=...".
+var version = "dev"
+
+// commit is the git commit built, set like version. When unset, it is taken
+// from the VCS information the Go toolchain embeds, if any.
+var commit = ""
+
+// buildInfo describes the running library for bug reports, see getInfo.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+	// OptionsPath is the options.json the exported functions read by
+	// default, empty if none was found
+	OptionsPath string `json:"options_path"`
+	// DBVersion is the schema version rekordbox recorded in its database
+	DBVersion string `json:"db_version"`
+	// Errors lists why any of the above could not be determined
+	Errors []string `json:"errors,omitempty"`
+}
+
+func newBuildInfo() *buildInfo {
+	info := &buildInfo{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// schemaVersion is bumped whenever the shape of the exported playlists changes
+// in a way that could break consumers.
+const schemaVersion = 2
+
+// playlistsEnvelope wraps the exported playlists with enough metadata for
+// consumers to check what produced them. What is only known once every
+// playlist is collected follows the playlists, so playlistsEncoder can write
+// them as they are collected.
+type playlistsEnvelope struct {
+	envelopeHead
+	Playlists []*Playlist `json:"playlists"`
+	envelopeTrailer
+}
+
+// envelopeHead is the part of playlistsEnvelope before the playlists.
+type envelopeHead struct {
+	SchemaVersion int       `json:"schema_version"`
+	Version       string    `json:"version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+}
+
+// envelopeTrailer is the part of playlistsEnvelope after the playlists.
+type envelopeTrailer struct {
+	Stats *runStats `json:"stats"`
+	// Errors lists the playlists left out because collecting them failed
+	Errors []*playlistError `json:"errors"`
+	// MissingFiles lists the entries whose file is missing, with
+	// check_files only
+	MissingFiles []*unresolvedTrack `json:"missing_files,omitempty"`
+	// Warnings lists the warnings and errors logged while collecting, in
+	// the shared library only
+	Warnings []*logWarning `json:"warnings,omitempty"`
+}
+
+func newPlaylistsEnvelope(playlists []*Playlist, stats *runStats) *playlistsEnvelope {
+	return &playlistsEnvelope{
+		envelopeHead:    newEnvelopeHead(),
+		Playlists:       playlists,
+		envelopeTrailer: newEnvelopeTrailer(stats),
+	}
+}
+
+func newEnvelopeHead() envelopeHead {
+	return envelopeHead{
+		SchemaVersion: schemaVersion,
+		Version:       version,
+		GeneratedAt:   time.Now().UTC(),
+	}
+}
+
+func newEnvelopeTrailer(stats *runStats) envelopeTrailer {
+	return envelopeTrailer{
+		Stats:        stats.finish(),
+		Errors:       stats.errors(),
+		MissingFiles: stats.missing(),
+	}
+}
+
+// playlistTreeEnvelope is the playlistsEnvelope of the tree export.
+type playlistTreeEnvelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	Version       string          `json:"version"`
+	GeneratedAt   time.Time       `json:"generated_at"`
+	Stats         *runStats       `json:"stats"`
+	Tree          []*PlaylistNode `json:"tree"`
+	// Errors, MissingFiles and Warnings are as in playlistsEnvelope
+	Errors       []*playlistError   `json:"errors"`
+	MissingFiles []*unresolvedTrack `json:"missing_files,omitempty"`
+	Warnings     []*logWarning      `json:"warnings,omitempty"`
+}
+
+func newPlaylistTreeEnvelope(tree []*PlaylistNode, stats *runStats) *playlistTreeEnvelope {
+	return &playlistTreeEnvelope{
+		SchemaVersion: schemaVersion,
+		Version:       version,
+		GeneratedAt:   time.Now().UTC(),
+		Stats:         stats.finish(),
+		Tree:          tree,
+		Errors:        stats.errors(),
+		MissingFiles:  stats.missing(),
+	}
+}
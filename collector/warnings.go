@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// logWarning is a warning or error logged during a call of the shared
+// library, returned to the host in its result.
+type logWarning struct {
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Playlist  string `json:"playlist,omitempty"`
+	ContentID string `json:"content_id,omitempty"`
+	TrackNo   *int64 `json:"track_no,omitempty"`
+	// Reason is the reason or error the warning carries, if any
+	Reason string `json:"reason,omitempty"`
+	// Details holds the other attributes of the record
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// warningLog collects the warnings logged while it is active.
+type warningLog struct {
+	mu       sync.Mutex
+	warnings []*logWarning
+}
+
+// activeWarningLogs are the warningLogs captureWarnings started and hasn't
+// stopped yet.
+var (
+	activeWarningLogsMu sync.Mutex
+	activeWarningLogs   = map[*warningLog]bool{}
+)
+
+// captureWarnings starts collecting every warning and error logged, until
+// the returned function is called, which returns them. Records carry no
+// notion of which call logged them, so calls capturing at the same time
+// each get the warnings of all of them.
+func captureWarnings() func() []*logWarning {
+	l := &warningLog{warnings: []*logWarning{}}
+	activeWarningLogsMu.Lock()
+	activeWarningLogs[l] = true
+	activeWarningLogsMu.Unlock()
+
+	return func() []*logWarning {
+		activeWarningLogsMu.Lock()
+		delete(activeWarningLogs, l)
+		activeWarningLogsMu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.warnings
+	}
+}
+
+// capturingWarnings reports whether any warningLog is active.
+func capturingWarnings() bool {
+	activeWarningLogsMu.Lock()
+	defer activeWarningLogsMu.Unlock()
+
+	return len(activeWarningLogs) > 0
+}
+
+// publishWarning adds w to every active warningLog.
+func publishWarning(w *logWarning) {
+	activeWarningLogsMu.Lock()
+	defer activeWarningLogsMu.Unlock()
+
+	for l := range activeWarningLogs {
+		l.mu.Lock()
+		l.warnings = append(l.warnings, w)
+		l.mu.Unlock()
+	}
+}
+
+// captureHandler passes records on to next, also publishing warnings and
+// errors to the active warningLogs whatever next's level.
+type captureHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *captureHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level) || (level >= slog.LevelWarn && capturingWarnings())
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn && capturingWarnings() {
+		publishWarning(newLogWarning(r, h.attrs))
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{next: h.next.WithAttrs(attrs), attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	return &captureHandler{next: h.next.WithGroup(name), attrs: h.attrs}
+}
+
+// newLogWarning converts r, with the attributes attrs of its logger, into
+// a logWarning.
+func newLogWarning(r slog.Record, attrs []slog.Attr) *logWarning {
+	w := &logWarning{Level: r.Level.String(), Message: r.Message}
+	add := func(a slog.Attr) bool {
+		value := a.Value.Resolve()
+		switch a.Key {
+		case "playlist":
+			w.Playlist = value.String()
+		case "content_id":
+			w.ContentID = value.String()
+		case "track_no":
+			if value.Kind() == slog.KindInt64 {
+				n := value.Int64()
+				w.TrackNo = &n
+			}
+		case "reason":
+			w.Reason = value.String()
+		case "error":
+			// warnings without a reason give the error as one
+			if w.Reason == "" {
+				w.Reason = value.String()
+				break
+			}
+			fallthrough
+		default:
+			if w.Details == nil {
+				w.Details = map[string]interface{}{}
+			}
+			if err, ok := value.Any().(error); ok {
+				w.Details[a.Key] = err.Error()
+			} else {
+				w.Details[a.Key] = value.Any()
+			}
+		}
+		return true
+	}
+
+	for _, a := range attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	return w
+}
@@ -0,0 +1,298 @@
+package collector
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/mattn/go-nulltype"
+)
+
+// xmlSource reads an exported rekordbox XML collection instead of the live
+// database, e.g. while rekordbox holds a lock on it. The XML carries no My
+// Tags, colors or change times, so those filters select nothing or everything.
+type xmlSource struct {
+	path string
+}
+
+// xmlLibrary is a parsed XML collection, with its playlist nodes turned into
+// rows like those of the database. The XML has no playlist IDs, so rows are
+// numbered in document order.
+type xmlLibrary struct {
+	rows    []*rekordbox.DjmdPlaylist
+	paths   map[string][]string
+	entries map[string][]string
+	tracks  map[string]*xmlTrack
+}
+
+func (s *xmlSource) load() (*xmlLibrary, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &xmlDJPlaylists{}
+	if err := xml.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	lib := &xmlLibrary{
+		paths:   map[string][]string{},
+		entries: map[string][]string{},
+		tracks:  map[string]*xmlTrack{},
+	}
+	for _, track := range doc.Collection.Tracks {
+		lib.tracks[track.TrackID] = track
+	}
+
+	if doc.Playlists.Root != nil {
+		lib.addNodes(doc.Playlists.Root.Nodes, "root", nil)
+	}
+
+	return lib, nil
+}
+
+func (lib *xmlLibrary) addNodes(nodes []*xmlNode, parentID string, parentPath []string) {
+	for i, node := range nodes {
+		id := strconv.Itoa(len(lib.rows) + 1)
+		attribute := int64(playlistAttributePlaylist)
+		if node.Type == 0 {
+			attribute = playlistAttributeFolder
+		}
+
+		lib.rows = append(lib.rows, &rekordbox.DjmdPlaylist{
+			ID:        nulltype.NullStringOf(id),
+			Seq:       nulltype.NullInt64Of(int64(i + 1)),
+			Name:      nulltype.NullStringOf(node.Name),
+			Attribute: nulltype.NullInt64Of(attribute),
+			ParentID:  nulltype.NullStringOf(parentID),
+		})
+
+		nodePath := append(append([]string{}, parentPath...), node.Name)
+		lib.paths[id] = nodePath
+
+		for _, key := range node.Tracks {
+			lib.entries[id] = append(lib.entries[id], key.Key)
+		}
+
+		lib.addNodes(node.Nodes, id, nodePath)
+	}
+}
+
+func (s *xmlSource) playlists(ctx context.Context, opts CollectOptions) ([]*Playlist, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	lib, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.playlists(opts), nil
+}
+
+func (s *xmlSource) tree(ctx context.Context, opts CollectOptions) ([]*PlaylistNode, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	lib, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return arrangeTree(lib.rows, lib.playlists(opts)), nil
+}
+
+// playlists builds the playlists selected by opts the way collect does for
+// the database.
+func (lib *xmlLibrary) playlists(opts CollectOptions) []*Playlist {
+	if !opts.Since.IsZero() {
+		slog.Warn("the XML collection has no change times, exporting all playlists")
+	}
+
+	// validated by the caller
+	fields, _ := opts.fields()
+
+	playlists := []*Playlist{}
+	skippedEmpty, skippedSmall, unanalyzed := 0, 0, 0
+	for _, row := range lib.rows {
+		if row.Attribute.Int64Value() == playlistAttributeFolder {
+			continue
+		}
+
+		path := lib.paths[row.ID.String()]
+		pl := &Playlist{
+			CombinedName: strings.Join(path, opts.nameSeparator()),
+			DJMdPlaylist: row,
+			UUID:         row.UUID.String(),
+		}
+		if !opts.includes(pl.CombinedName) {
+			continue
+		}
+		opts.applyName(pl, path)
+
+		seen := map[string]bool{}
+		for _, key := range lib.entries[row.ID.String()] {
+			if !opts.KeepDuplicates {
+				if seen[key] {
+					pl.DuplicatesRemoved++
+					continue
+				}
+				seen[key] = true
+			}
+
+			// the XML has no My Tags, so no track passes a tag filter
+			if !opts.keepsTrack(nil) {
+				continue
+			}
+
+			track, ok := lib.tracks[key]
+			if !ok {
+				slog.Warn("track not in collection", "playlist", pl.CombinedName, "content_id", key)
+				continue
+			}
+			resolved := track.track()
+			fields.clear(resolved)
+			if !opts.keepsBPM(resolved.BPM) || !opts.keepsAdded(resolved.DateAdded) || !opts.keepsRating(resolved.Rating) {
+				continue
+			}
+			// the XML has no analysis status, but analyzed tracks have a
+			// beat grid
+			if opts.AnalyzedOnly && len(track.Tempos) == 0 {
+				unanalyzed++
+				continue
+			}
+
+			pl.DJMdContents = append(pl.DJMdContents, track.content())
+			pl.Tracks = append(pl.Tracks, resolved)
+		}
+
+		if opts.filtersTracks() && len(pl.Tracks) == 0 {
+			skippedEmpty++
+			continue
+		}
+		if len(pl.Tracks) < opts.MinTracks {
+			skippedSmall++
+			continue
+		}
+
+		playlists = append(playlists, pl)
+	}
+
+	nodes := make(map[string]*rekordbox.DjmdPlaylist, len(lib.rows))
+	for _, row := range lib.rows {
+		nodes[row.ID.String()] = row
+	}
+	addSeqs(playlists, nodes)
+	addContentHashes(playlists)
+	addTotals(playlists)
+	sortPlaylists(playlists, opts)
+	disambiguateNames(playlists)
+	opts.stats.addPlaylists(playlists, skippedEmpty, skippedSmall)
+	opts.stats.addUnanalyzed(unanalyzed)
+	return playlists
+}
+
+// content returns the DjmdContent row rekordbox would hold for t, with the
+// columns that matching and the exporters read.
+func (t *xmlTrack) content() *rekordbox.DjmdContent {
+	folderPath := xmlLocationPath(t.Location)
+	bpm, _ := strconv.ParseFloat(t.AverageBpm, 64)
+
+	return &rekordbox.DjmdContent{
+		ID:          nulltype.NullStringOf(t.TrackID),
+		Title:       nulltype.NullStringOf(t.Name),
+		FolderPath:  nulltype.NullStringOf(folderPath),
+		FileNameL:   nulltype.NullStringOf(path.Base(folderPath)),
+		BPM:         nulltype.NullInt64Of(bpmToStored(bpm)),
+		Length:      nulltype.NullInt64Of(t.TotalTime),
+		BitRate:     nulltype.NullInt64Of(t.BitRate),
+		SampleRate:  nulltype.NullInt64Of(t.SampleRate),
+		Rating:      nulltype.NullInt64Of(starsFromXMLRating(t.Rating)),
+		Commnt:      nulltype.NullStringOf(t.Comments),
+		DateCreated: nulltype.NullStringOf(t.DateAdded),
+	}
+}
+
+func (t *xmlTrack) track() *Track {
+	bpm, _ := strconv.ParseFloat(t.AverageBpm, 64)
+
+	track := &Track{
+		ContentID:  t.TrackID,
+		Title:      t.Name,
+		FolderPath: xmlLocationPath(t.Location),
+		ArtistName: t.Artist,
+		AlbumName:  t.Album,
+		GenreName:  t.Genre,
+		GenrePath:  genrePath(t.Genre),
+		BPM:        bpm,
+		Length:     t.TotalTime,
+		DurationMs: t.TotalTime * 1000,
+		KeyName:    t.Tonality,
+		CamelotKey: camelotKey(t.Tonality),
+		Rating:     starsFromXMLRating(t.Rating),
+		Cues:       []*Cue{},
+		BeatGrid:   []*BeatGridEntry{},
+		MyTags:     []*MyTag{},
+		// the XML format has no related tracks
+		RelatedTracks: []*RelatedTrack{},
+		PlayCount:     t.PlayCount,
+		DateAdded:     parseDateAdded(t.DateAdded),
+		BitRate:       t.BitRate,
+		SampleRate:    t.SampleRate,
+		FileType:      strings.TrimSuffix(t.Kind, " File"),
+		Comment:       t.Comments,
+	}
+	if t.Label != "" {
+		label := t.Label
+		track.LabelName = &label
+	}
+
+	for _, tempo := range t.Tempos {
+		inizio, _ := strconv.ParseFloat(tempo.Inizio, 64)
+		bpm, _ := strconv.ParseFloat(tempo.Bpm, 64)
+		track.BeatGrid = append(track.BeatGrid, &BeatGridEntry{
+			PositionMs: inizio * 1000,
+			BPM:        bpm,
+			Beat:       tempo.Battito,
+		})
+	}
+
+	for _, mark := range t.PositionMarks {
+		start, _ := strconv.ParseFloat(mark.Start, 64)
+		cue := &Cue{Type: cueTypeMemory, PositionMs: start * 1000, Comment: mark.Name}
+		if mark.Num >= 0 && mark.Num < 8 {
+			cue.Type = cueTypeHot
+			cue.Slot = string("ABCDEFGH"[mark.Num])
+		}
+		track.Cues = append(track.Cues, cue)
+	}
+
+	return track
+}
+
+// xmlLocationPath turns a file://localhost/ location back into a file path,
+// the inverse of fileURL.
+func xmlLocationPath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "file" {
+		return location
+	}
+
+	p := u.Path
+	// file://localhost/C:/Music/... is a Windows path
+	if len(p) > 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+
+	return p
+}
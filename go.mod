@@ -1,13 +1,15 @@
 module github.com/dvcrn/rekordbox-playlist-sync
 
-go 1.18
+go 1.21
 
-require github.com/dvcrn/go-rekordbox v0.0.0-20231108014618-009cde44fc50
+require (
+	github.com/dvcrn/go-rekordbox v0.0.0-20231108014618-009cde44fc50
+	github.com/mattn/go-nulltype v0.0.0-20200221160555-75ae8a76f2e9
+)
 
 require (
 	github.com/andreburgaud/crypt2go v1.1.0 // indirect
 	github.com/jmoiron/sqlx v1.3.5 // indirect
-	github.com/mattn/go-nulltype v0.0.0-20200221160555-75ae8a76f2e9 // indirect
 	github.com/nurcahyaari/sqlabst v0.0.0-20220902160139-0f2eb23feed8 // indirect
 	github.com/xeodou/go-sqlcipher v0.0.0-20200727080346-d681773ef093 // indirect
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
@@ -69,10 +69,6 @@ class Logger:
         """Log number of Rekordbox playlists found"""
         self.info(f'\nFound {count} Rekordbox playlists to sync\n')
 
-    def skipping_smart_playlist(self, name: str):
-        """Log skipping of a smart playlist"""
-        self.tqdm_write(f'⏭️  Skipping smart playlist: {name}')
-
     def processing_playlist(self, name: str):
         """Log start of playlist processing"""
         if self.verbose:
@@ -2,112 +2,290 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
+	"unsafe"
 
-	"C"
-
-	"github.com/dvcrn/go-rekordbox/rekordbox"
+	"github.com/dvcrn/rekordbox-playlist-sync/collector"
 )
-import "encoding/json"
 
-type Playlist struct {
-	CombinedName string                   `json:"combined_name"`
-	DJMdPlaylist *rekordbox.DjmdPlaylist  `json:"dj_md_playlist,omitempty"`
-	DJMdContents []*rekordbox.DjmdContent `json:"dj_md_contents,omitempty"`
-}
+// #include <stdlib.h>
+import "C"
 
-func getRecursivePlaylistName(ctx context.Context, client *rekordbox.Client, playlist *rekordbox.DjmdPlaylist, nameSoFar string) string {
-	// check if has a parent
-	if playlist.ParentID.String() == "root" {
-		return nameSoFar
-	}
+// The exports of the shared library. Each converts its arguments, calls into
+// the collector package and records the status getLastStatus reports.
+//
+// All of them may be called from several threads at once, including the same
+// export concurrently. Every call opens the database with its own client and
+// runs with its own context; the only state they share is the log output,
+// the progress callback, the output cache and the last status, each of which
+// is synchronized. Concurrent calls don't wait for one another, so for the
+// status of a particular call, use the "error" of its JSON rather than
+// getLastStatus, which reports whichever call finished last. Likewise, the
+// "warnings" of a call running alongside others also hold theirs.
+
+// errorJSON is what the exported functions return instead of panicking, since
+// a panic would take down the host process that loaded the library. status is
+// reported by getLastStatus.
+func errorJSON(status int, err error) *C.char {
+	recordResult(status, err)
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return C.CString(string(b))
+}
 
-	// get parent
-	parent, err := client.DjmdPlaylistByID(ctx, playlist.ParentID)
+// resultJSON returns the JSON b, or the error of producing it.
+func resultJSON(b []byte, err error) *C.char {
 	if err != nil {
-		// Return current name if parent not found
-		fmt.Fprintf(os.Stderr, "Warning: Parent playlist not found for %s: %v\n", nameSoFar, err)
-		return nameSoFar
+		return errorJSON(collector.ErrorStatus(err), err)
 	}
+	recordResult(collector.StatusOK, nil)
 
-	name := fmt.Sprintf("%s - %s", parent.Name.String(), nameSoFar)
-	return getRecursivePlaylistName(ctx, client, parent, name)
+	return C.CString(string(b))
 }
 
+// getPlaylists returns the playlists as a JSON envelope
+// {"schema_version": 2, "version": "...", "generated_at": "...", "playlists": [...]},
+// or {"error": "..."} if they could not be collected. options is a JSON object such as
+// {"include_prefixes": ["Plexamp - "], "since": "2024-01-02T15:04:05Z"}, or
+// NULL for all playlists. With "since", playlists unchanged after that time are
+// omitted; with "track_tags": ["Peak Time"], only tracks with one of those My
+// Tags are kept. "options_path" selects the options.json to read, which
+// otherwise comes from $REKORDBOX_OPTIONS_PATH or is detected. With
+// "cache_ttl_seconds", repeated calls with the same options return the
+// previous result until it is that old or the database changes; "no_cache"
+// forces a fresh collection.
+//
+// Like every string returned by this library, the result is allocated with
+// malloc and owned by the caller, who must release it with freeString.
+//
 //export getPlaylists
-func getPlaylists() *C.char {
-	ctx := context.Background()
-
-	homeDir, err := os.UserHomeDir()
+func getPlaylists(options *C.char) *C.char {
+	opts, err := collector.ParseCollectOptions(C.GoString(options))
 	if err != nil {
-		panic(err)
+		return errorJSON(collector.StatusInvalidOptions, err)
 	}
+	opts.Progress = hostProgressFunc()
 
-	optionsFilePath := filepath.Join(homeDir, "/Library/Application Support/Pioneer/rekordboxAgent/storage/", "options.json")
+	return resultJSON(collector.PlaylistsJSON(context.Background(), opts))
+}
 
-	// Files and paths
-	client, err := rekordbox.NewClient(optionsFilePath)
+// getPlaylistsSince is getPlaylists with the default options, returning only
+// the playlists that changed at or after unixMillis, a time in milliseconds
+// since the Unix epoch: those whose row, entries or tracks were updated since.
+// Hosts polling for changes keep the time themselves.
+//
+//export getPlaylistsSince
+func getPlaylistsSince(unixMillis C.longlong) *C.char {
+	// since is exclusive, and rekordbox stores times to the millisecond
+	opts := collector.CollectOptions{Since: time.UnixMilli(int64(unixMillis)).Add(-time.Millisecond)}
+	opts.Progress = hostProgressFunc()
+
+	return resultJSON(collector.PlaylistsJSON(context.Background(), opts))
+}
+
+// getPlaylistTree is getPlaylists with the playlists nested in their folders:
+// the envelope holds a "tree" of nodes, where folders list their contents as
+// "children" and playlists carry their "tracks". options are as for
+// getPlaylists.
+//
+//export getPlaylistTree
+func getPlaylistTree(options *C.char) *C.char {
+	opts, err := collector.ParseCollectOptions(C.GoString(options))
 	if err != nil {
-		panic(err)
+		return errorJSON(collector.StatusInvalidOptions, err)
 	}
+	opts.Progress = hostProgressFunc()
 
-	defer client.Close()
+	return resultJSON(collector.PlaylistTreeJSON(context.Background(), opts))
+}
 
-	playlists, err := client.AllDjmdPlaylist(ctx)
+// getPlaylistNames returns a JSON array of the playlists options selects
+// without their tracks, for offering a selection: each has its "id",
+// "parent_id", "combined_name" and "track_count", counted from the playlist
+// entries without reading the tracks, so on a large library it is much faster
+// than getPlaylists. options are as for getPlaylists, or NULL for all
+// playlists; track filters don't apply.
+//
+//export getPlaylistNames
+func getPlaylistNames(options *C.char) *C.char {
+	opts, err := collector.ParseCollectOptions(C.GoString(options))
 	if err != nil {
-		panic(err)
+		return errorJSON(collector.StatusInvalidOptions, err)
 	}
 
-	parsedPlaylists := []*Playlist{}
-	for _, playlist := range playlists {
-		pl := &Playlist{}
+	return resultJSON(collector.PlaylistNamesJSON(context.Background(), opts))
+}
 
-		playlistSongs, err := client.DjmdSongPlaylistByPlaylistID(ctx, playlist.ID)
-		if err != nil {
-			panic(err)
-		}
+// getPlaylistByID returns the playlist with the given rekordbox ID, with its
+// tracks resolved as by getPlaylists, as a JSON object, or {"error": "..."} if
+// there is no such playlist. Hosts use it to refresh a single playlist after
+// it was edited without collecting the whole library.
+//
+//export getPlaylistByID
+func getPlaylistByID(id *C.char) *C.char {
+	playlistID := C.GoString(id)
+	if playlistID == "" {
+		return errorJSON(collector.StatusInvalidOptions, fmt.Errorf("no playlist ID given"))
+	}
 
-		if len(playlistSongs) == 0 {
-			continue
-		}
+	return resultJSON(collector.PlaylistJSON(context.Background(), playlistID))
+}
 
-		pl.DJMdPlaylist = playlist
-		pl.CombinedName = getRecursivePlaylistName(ctx, client, playlist, playlist.Name.String())
+// getUnmatchedReport returns a JSON array of every playlist entry whose
+// content row or file on disk is missing, with the reason for each.
+//
+//export getUnmatchedReport
+func getUnmatchedReport() *C.char {
+	return resultJSON(collector.UnmatchedReportJSON(context.Background()))
+}
 
-		for _, playlistSong := range playlistSongs {
-			// Skip deleted playlist entries
-			if playlistSong.RbLocalDeleted.Int64Value() != 0 {
-				continue
-			}
+// syncPlaylistsToPlex pushes the rekordbox playlists to the Plex server at
+// serverURL, creating missing playlists and updating changed ones. options is
+// a JSON collector.PlexSyncOptions object or NULL for the defaults. It returns
+// a JSON summary of what was written and how many tracks matched.
+//
+//export syncPlaylistsToPlex
+func syncPlaylistsToPlex(serverURL, token, options *C.char) *C.char {
+	return runPlexSync(serverURL, token, options, false)
+}
 
-			content, err := client.DjmdContentByID(ctx, playlistSong.ContentID)
-			if err != nil {
-				// Skip this track if not found
-				fmt.Fprintf(os.Stderr, "Warning: Track content not found for ContentID %v (Entry ID: %v, Track #%v) in playlist %s: %v\n", playlistSong.ContentID, playlistSong.ID, playlistSong.TrackNo, playlist.Name.String(), err)
-				continue
-			}
+// planSyncToPlex is the dry-run counterpart of syncPlaylistsToPlex: it takes
+// the same arguments and matches every track, but only returns the JSON plan
+// of what would be written instead of modifying any Plex playlist.
+//
+//export planSyncToPlex
+func planSyncToPlex(serverURL, token, options *C.char) *C.char {
+	return runPlexSync(serverURL, token, options, true)
+}
 
-			// Skip deleted content
-			if content.RbLocalDeleted.Int64Value() != 0 {
-				continue
-			}
+func runPlexSync(serverURL, token, options *C.char, dryRun bool) *C.char {
+	opts, err := collector.ParsePlexSyncOptions(C.GoString(options))
+	if err != nil {
+		return errorJSON(collector.StatusInvalidOptions, err)
+	}
+	opts.Progress = hostProgressFunc()
 
-			pl.DJMdContents = append(pl.DJMdContents, content)
-		}
+	return resultJSON(collector.PlexSyncJSON(context.Background(), C.GoString(serverURL), C.GoString(token), opts, dryRun))
+}
 
-		parsedPlaylists = append(parsedPlaylists, pl)
+// setLogOutput redirects the library's log messages, which go to stderr by
+// default, to the file at path, appending to it. An empty path restores
+// stderr. level is one of "debug", "info", "warn" or "error"; empty means
+// "info". It returns 0 on success and -1 if the file could not be opened or
+// the level is unknown, in which case the previous output is kept.
+//
+//export setLogOutput
+func setLogOutput(path, level *C.char) C.int {
+	if err := collector.RedirectLogs(C.GoString(path), C.GoString(level)); err != nil {
+		return -1
 	}
 
-	// marshal playlists to json
-	b, err := json.Marshal(parsedPlaylists)
+	return 0
+}
+
+// getLastStatus returns the status of the most recent call to getPlaylists,
+// getPlaylistsSince, getPlaylistTree, getPlaylistNames, getPlaylistByID,
+// getUnmatchedReport, getOrphanTracks, syncPlaylistsToPlex or planSyncToPlex:
+// 0 on success, 1 if the options were invalid, 2 if the rekordbox database
+// could not be opened and 3 if collecting or syncing failed. Hosts can check
+// it before parsing the returned JSON. With calls on several threads at once it is the status of
+// the one that finished last, so a host relying on it has to serialize each
+// call with the check that follows.
+//
+//export getLastStatus
+func getLastStatus() C.int {
+	status, _ := lastStatus()
+	return C.int(status)
+}
+
+// getLastError returns the error message of the most recent call, or an
+// empty string if it succeeded. The result must be released with freeString.
+//
+//export getLastError
+func getLastError() *C.char {
+	_, msg := lastStatus()
+	return C.CString(msg)
+}
+
+// getVersion returns the version of this build, so hosts can check which
+// schema to expect before calling anything else.
+//
+//export getVersion
+func getVersion() *C.char {
+	return C.CString(collector.Version())
+}
+
+// getInfo returns JSON describing this build and the library it reads:
+// {"version": "...", "commit": "...", "go_version": "...", "options_path":
+// "...", "db_version": "..."}, plus "errors" listing why the options path or
+// database version could not be determined, for including in bug reports.
+//
+//export getInfo
+func getInfo() *C.char {
+	return resultJSON(collector.InfoJSON(context.Background()))
+}
+
+// checkEnvironment lets hosts show whether a sync can run before starting
+// one. It reads no playlists, only checks that the options.json at
+// optionsPath (NULL or empty to locate it) is readable, that its database
+// exists, opens and answers a query and, when serverURL is given, that the
+// Plex server is reachable and accepts token. It returns {"ok": true|false,
+// "checks": [{"name": "...", "ok": ..., "message": "..."}, ...]}.
+//
+//export checkEnvironment
+func checkEnvironment(optionsPath, serverURL, token *C.char) *C.char {
+	return resultJSON(collector.EnvironmentJSON(context.Background(), C.GoString(optionsPath), C.GoString(serverURL), C.GoString(token)))
+}
+
+// getOrphanTracks returns the tracks of the collection that no playlist
+// lists, as a JSON array of the track objects getPlaylists has, to find
+// imports that still need organizing. options takes the track filters of
+// getPlaylists, such as "bpm_min" or "track_tags", and may be NULL.
+// Tracks only an intelligent playlist selects count as in no playlist.
+//
+//export getOrphanTracks
+func getOrphanTracks(options *C.char) *C.char {
+	opts, err := collector.ParseCollectOptions(C.GoString(options))
 	if err != nil {
-		panic(err)
+		return errorJSON(collector.StatusInvalidOptions, err)
 	}
 
-	return C.CString(string(b))
+	return resultJSON(collector.OrphanTracksJSON(context.Background(), opts))
+}
+
+// getOptionsInfo describes the options.json at optionsPath (NULL or empty to
+// locate it), for confirming which library is read: {"options_path": "...",
+// "source": "...", "db_path": "...", "db_exists": ..., "key_found": ...,
+// "options": {...}, "errors": [...]}. The decryption key itself is never
+// included.
+//
+//export getOptionsInfo
+func getOptionsInfo(optionsPath *C.char) *C.char {
+	return resultJSON(collector.OptionsJSON(C.GoString(optionsPath)))
+}
+
+// setProgressCallback registers a C function
+// void callback(int processed, int total) that getPlaylists and the Plex sync
+// call after each playlist is collected. It may be called from any thread, but
+// never concurrently. Passing NULL unregisters it.
+//
+//export setProgressCallback
+func setProgressCallback(callback unsafe.Pointer) {
+	setHostProgress(callback)
+}
+
+// freeString releases a string returned by any of the exported functions.
+// Every returned string is allocated with malloc and ownership passes to the
+// caller, so hosts that call us repeatedly must free each result exactly once
+// and must not use it afterwards. Passing NULL is a no-op.
+//
+//export freeString
+func freeString(s *C.char) {
+	C.free(unsafe.Pointer(s))
 }
 
 func main() {
+	os.Exit(collector.RunCLI(os.Args[1:]))
 }
@@ -0,0 +1,49 @@
+package main
+
+/*
+typedef void (*progressCallback)(int processed, int total);
+
+static void callProgressCallback(void *cb, int processed, int total) {
+	((progressCallback)cb)(processed, total);
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// hostProgress is the C function registered with setProgressCallback, or nil.
+var (
+	hostProgressMu sync.Mutex
+	hostProgress   unsafe.Pointer
+)
+
+// hostProgressCallMu serializes the callback across concurrent exports, each
+// of which only serializes its own calls.
+var hostProgressCallMu sync.Mutex
+
+func setHostProgress(cb unsafe.Pointer) {
+	hostProgressMu.Lock()
+	defer hostProgressMu.Unlock()
+	hostProgress = cb
+}
+
+// hostProgressFunc returns a progress function calling the registered C
+// callback, or nil if none is registered.
+func hostProgressFunc() func(processed, total int) {
+	hostProgressMu.Lock()
+	cb := hostProgress
+	hostProgressMu.Unlock()
+
+	if cb == nil {
+		return nil
+	}
+
+	return func(processed, total int) {
+		hostProgressCallMu.Lock()
+		defer hostProgressCallMu.Unlock()
+		C.callProgressCallback(cb, C.int(processed), C.int(total))
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "sync"
+
+// lastResult is the outcome of the most recent exported call, its status one
+// of the collector.Status codes. It is shared by all threads, so hosts calling
+// us concurrently must serialize a call and the status check that follows it.
+var lastResult struct {
+	sync.Mutex
+	status int
+	err    string
+}
+
+func recordResult(status int, err error) {
+	lastResult.Lock()
+	defer lastResult.Unlock()
+
+	lastResult.status = status
+	lastResult.err = ""
+	if err != nil {
+		lastResult.err = err.Error()
+	}
+}
+
+func lastStatus() (int, string) {
+	lastResult.Lock()
+	defer lastResult.Unlock()
+
+	return lastResult.status, lastResult.err
+}